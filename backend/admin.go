@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Error code of admin requests the store could not serve.
+const errStore = "store_error"
+
+// registerAdminRoutes adds the operator endpoints to mux. The labelling
+// queue is open to trainers; the others require the admin role.
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/config", s.requireRole(roleAdmin, s.handleAdminConfig))
+	mux.HandleFunc("GET /admin/experiment", s.requireRole(roleAdmin, s.handleAdminExperiment))
+	mux.HandleFunc("GET /admin/labels", s.requireRole(roleTrainer, s.handleAdminLabelQueue))
+	mux.HandleFunc("POST /admin/labels", s.requireRole(roleTrainer, limitRate(s.limits.train, limitTrain, s.handleAdminLabel)))
+}
+
+// handleAdminConfig shows the effective configuration with secrets redacted.
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, config.Redacted())
+}
+
+// handleAdminExperiment compares the feedback rating and fallback rate of
+// each retrieval variant.
+func (s *Server) handleAdminExperiment(w http.ResponseWriter, r *http.Request) {
+	reports, err := experimentReport(r.Context())
+	if err != nil {
+		dbErrors.WithLabelValues("variant_stats").Inc()
+		loggerFrom(r.Context()).Error("Error reading variant stats", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, errStore, "could not read the experiment results")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"variants": reports})
+}
+
+// handleAdminLabelQueue lists the queries most worth labelling, highest
+// priority first. ?limit= sets how many.
+func (s *Server) handleAdminLabelQueue(w http.ResponseWriter, r *http.Request) {
+	limit := defaultLabelQueueLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLabelQueueLimit {
+			writeAPIError(w, http.StatusBadRequest, errInvalidMessage, "limit must be an integer from 1 to "+strconv.Itoa(maxLabelQueueLimit))
+			return
+		}
+		limit = n
+	}
+
+	tasks, err := labelQueue(r.Context(), limit)
+	if err != nil {
+		dbErrors.WithLabelValues("label_candidates").Inc()
+		loggerFrom(r.Context()).Error("Error reading the labelling queue", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, errStore, "could not read the labelling queue")
+		return
+	}
+	if tasks == nil {
+		tasks = []LabelTask{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": tasks})
+}
+
+// labelRequest is a trainer's answer to a queued query: the corpus section
+// that answers it, an answer written out, or a dismissal.
+type labelRequest struct {
+	Query   string `json:"query"`
+	TurnID  string `json:"turn_id"`
+	Section string `json:"section"`
+	Answer  string `json:"answer"`
+	Dismiss bool   `json:"dismiss"`
+}
+
+// handleAdminLabel labels a queued query. A section or answer is trained
+// like a /train request; a dismissal only takes the query off the queue.
+func (s *Server) handleAdminLabel(w http.ResponseWriter, r *http.Request) {
+	var req labelRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if problem := validateText("query", req.Query); problem != "" {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage, problem)
+		return
+	}
+	if req.TurnID != "" && !turnIDPattern.MatchString(req.TurnID) {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage, "turn_id must be a turn id from the labelling queue")
+		return
+	}
+	given := 0
+	for _, set := range []bool{req.Section != "", req.Answer != "", req.Dismiss} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage, "exactly one of section, answer or dismiss is required")
+		return
+	}
+
+	label := Label{Query: req.Query, TurnID: req.TurnID, Answer: req.Answer, Status: labelLabelled}
+	if principal, ok := principalFrom(r.Context()); ok {
+		label.Labeller = principal.Subject
+	}
+	switch {
+	case req.Dismiss:
+		label.Status = labelDismissed
+	case req.Section != "":
+		modelMu.RLock()
+		section, ok := sectionByTitle(req.Section)
+		modelMu.RUnlock()
+		if !ok {
+			writeAPIError(w, http.StatusBadRequest, errInvalidMessage, "section must be the title of a corpus section")
+			return
+		}
+		label.Section, label.Answer = section.Title, section.Text()
+	}
+
+	if err := applyLabel(r.Context(), label); err != nil {
+		dbErrors.WithLabelValues("save_label").Inc()
+		loggerFrom(r.Context()).Error("Error saving label", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, errStore, "could not save the label")
+		return
+	}
+	writeJSON(w, http.StatusOK, label)
+}
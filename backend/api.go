@@ -0,0 +1,154 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// Maximum size of a REST request body.
+const maxRequestBodySize = 64 << 10
+
+// apiQueryRequest is the body of POST /api/v1/query.
+type apiQueryRequest struct {
+	Query string `json:"query"`
+}
+
+// apiIntent describes an intent in GET /api/v1/intents.
+type apiIntent struct {
+	Name            string   `json:"name"`
+	TrainingPhrases []string `json:"training_phrases"`
+}
+
+// apiError is the body of every REST error response. Codes match the error
+// codes of the WebSocket protocol.
+type apiError struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// registerAPIRoutes adds the REST API to mux.
+func (s *Server) registerAPIRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/query", s.requireChat(limitRate(s.limits.requests, limitRequests, s.handleAPIQuery)))
+	mux.HandleFunc("GET /api/v1/intents", s.handleAPIIntents)
+	mux.HandleFunc("GET /api/v1/keywords/{name}", s.handleAPIKeyword)
+	mux.HandleFunc("GET /api/v1/terms/{term}", s.handleAPITerm)
+	mux.HandleFunc("POST /api/v1/feedback", s.requireChat(limitRate(s.limits.requests, limitRequests, s.handleAPIFeedback)))
+	mux.HandleFunc("GET /api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+}
+
+// handleAPIQuery answers a single stateless query.
+func (s *Server) handleAPIQuery(w http.ResponseWriter, r *http.Request) {
+	var req apiQueryRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if problem := validateText("query", req.Query); problem != "" {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage, problem)
+		return
+	}
+
+	ctx := withLogger(r.Context(), slog.With(logRequest, newLogID()))
+	result := Answer(ctx, Query{Text: req.Query})
+	saveTurn("", req.Query, result)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAPIIntents lists the intents the classifier knows about.
+func (s *Server) handleAPIIntents(w http.ResponseWriter, r *http.Request) {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
+	list := make([]apiIntent, 0, len(intents))
+	for _, intent := range intents {
+		list = append(list, apiIntent{Name: intent.Name, TrainingPhrases: intent.TrainingPhrases})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleAPIKeyword describes a single Go keyword.
+func (s *Server) handleAPIKeyword(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	modelMu.RLock()
+	keyword, ok := findKeyword(name)
+	modelMu.RUnlock()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("unknown keyword %q", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, keyword)
+}
+
+// handleAPITerm defines a programming term and says where the definition
+// came from.
+func (s *Server) handleAPITerm(w http.ResponseWriter, r *http.Request) {
+	term := r.PathValue("term")
+	modelMu.RLock()
+	definition, ok := findTerm(term)
+	modelMu.RUnlock()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no definition of %q", term))
+		return
+	}
+	writeJSON(w, http.StatusOK, definition)
+}
+
+// handleAPIFeedback records a rating for an earlier answer.
+func (s *Server) handleAPIFeedback(w http.ResponseWriter, r *http.Request) {
+	var feedback Feedback
+	if !decodeJSONBody(w, r, &feedback) {
+		return
+	}
+	if problem := validateText("query", feedback.Query); problem != "" {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage, problem)
+		return
+	}
+	if feedback.TurnID != "" && !turnIDPattern.MatchString(feedback.TurnID) {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage, "turn_id must be a turn id returned with an answer")
+		return
+	}
+	if feedback.Response == "" {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage, "response is required")
+		return
+	}
+	if feedback.Rating < minRating || feedback.Rating > maxRating {
+		writeAPIError(w, http.StatusBadRequest, errInvalidMessage,
+			fmt.Sprintf("rating must be an integer from %d to %d", minRating, maxRating))
+		return
+	}
+
+	saveFeedbackToDB(feedback)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// decodeJSONBody decodes the request body into v, writing a 400 response
+// and returning false if it is not valid JSON.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errInvalidJSON, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "err", err)
+	}
+}
+
+// writeAPIError writes an apiError response.
+func writeAPIError(w http.ResponseWriter, status int, code, detail string) {
+	writeJSON(w, status, apiError{Code: code, Detail: detail})
+}
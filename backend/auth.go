@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Role is what an authenticated caller may do. Each role includes the
+// ones before it: an admin can also train and chat.
+type Role string
+
+const (
+	roleChat    Role = "chat"    // Ask questions and rate answers
+	roleTrainer Role = "trainer" // Add training data
+	roleAdmin   Role = "admin"   // Operator endpoints
+)
+
+var roleRank = map[Role]int{roleChat: 1, roleTrainer: 2, roleAdmin: 3}
+
+// Error codes of rejected REST requests.
+const (
+	errUnauthorized = "unauthorized"
+	errForbidden    = "forbidden"
+)
+
+// Shortest API key or token secret accepted.
+const minCredentialLength = 16
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// Allows reports whether r grants the permissions of required.
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[required]
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Subject string // API key name or token subject
+	Role    Role
+}
+
+// Authentication errors. errUnknownCredential lets a chain of
+// authenticators pass a credential on to the next one.
+var (
+	errNoCredential      = errors.New("no credential")
+	errUnknownCredential = errors.New("unknown credential")
+	errTokenExpired      = errors.New("token has expired")
+)
+
+// Authenticator resolves a credential presented by a client.
+type Authenticator interface {
+	Authenticate(credential string) (Principal, error)
+}
+
+// authChain tries each authenticator in turn.
+type authChain []Authenticator
+
+func (c authChain) Authenticate(credential string) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(credential)
+		if !errors.Is(err, errUnknownCredential) {
+			return p, err
+		}
+	}
+	return Principal{}, errUnknownCredential
+}
+
+// APIKey is a static credential configured in auth.api_keys.
+type APIKey struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	Key  string `json:"key" secret:"true"`
+}
+
+// apiKeyAuthenticator accepts the configured static API keys.
+type apiKeyAuthenticator struct {
+	keys   [][sha256.Size]byte // Hashes, so comparisons take the same time for every key length
+	owners []Principal
+}
+
+func newAPIKeyAuthenticator(keys []APIKey) *apiKeyAuthenticator {
+	a := &apiKeyAuthenticator{}
+	for _, k := range keys {
+		a.keys = append(a.keys, sha256.Sum256([]byte(k.Key)))
+		a.owners = append(a.owners, Principal{Subject: k.Name, Role: k.Role})
+	}
+	return a
+}
+
+func (a *apiKeyAuthenticator) Authenticate(credential string) (Principal, error) {
+	sum := sha256.Sum256([]byte(credential))
+	for i, key := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], key[:]) == 1 {
+			return a.owners[i], nil
+		}
+	}
+	return Principal{}, errUnknownCredential
+}
+
+// Prefix of HMAC tokens, naming the token format.
+const tokenPrefix = "v1."
+
+// tokenClaims is the signed payload of an HMAC token.
+type tokenClaims struct {
+	Subject string `json:"sub"`
+	Role    Role   `json:"role"`
+	Expires int64  `json:"exp"` // Unix seconds
+}
+
+// hmacAuthenticator verifies tokens of the form
+// v1.<base64url claims>.<base64url HMAC-SHA256 of "v1.<claims>">, signed
+// with auth.token_secret. Tokens are checked locally; nothing is stored.
+type hmacAuthenticator struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func newHMACAuthenticator(secret string) *hmacAuthenticator {
+	return &hmacAuthenticator{secret: []byte(secret), now: time.Now}
+}
+
+func (a *hmacAuthenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a token for subject with role that expires after ttl.
+func (a *hmacAuthenticator) Issue(subject string, role Role, ttl time.Duration) (string, error) {
+	claims, err := json.Marshal(tokenClaims{Subject: subject, Role: role, Expires: a.now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := tokenPrefix + base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + a.sign(payload), nil
+}
+
+func (a *hmacAuthenticator) Authenticate(credential string) (Principal, error) {
+	dot := strings.LastIndexByte(credential, '.')
+	if !strings.HasPrefix(credential, tokenPrefix) || dot < len(tokenPrefix) {
+		return Principal{}, errUnknownCredential
+	}
+	payload, signature := credential[:dot], credential[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(a.sign(payload))) {
+		return Principal{}, errors.New("bad token signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload[len(tokenPrefix):])
+	if err != nil {
+		return Principal{}, fmt.Errorf("malformed token: %w", err)
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return Principal{}, fmt.Errorf("malformed token: %w", err)
+	}
+	if !claims.Role.Valid() {
+		return Principal{}, fmt.Errorf("token has unknown role %q", claims.Role)
+	}
+	if a.now().Unix() >= claims.Expires {
+		return Principal{}, errTokenExpired
+	}
+	return Principal{Subject: claims.Subject, Role: claims.Role}, nil
+}
+
+// newAuthenticator builds the authenticators enabled by c. It returns nil
+// when no credentials are configured.
+func newAuthenticator(c AuthConfig) Authenticator {
+	var chain authChain
+	if len(c.APIKeys) > 0 {
+		chain = append(chain, newAPIKeyAuthenticator(c.APIKeys))
+	}
+	if c.TokenSecret != "" {
+		chain = append(chain, newHMACAuthenticator(c.TokenSecret))
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+// credential returns the API key or token sent with r, from the
+// Authorization bearer header or X-API-Key. Browsers cannot set headers on
+// WebSocket handshakes, so those may pass it as the token query parameter.
+func credential(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// authenticate identifies the caller of r.
+func (s *Server) authenticate(r *http.Request) (Principal, error) {
+	cred := credential(r)
+	if cred == "" {
+		return Principal{}, errNoCredential
+	}
+	if s.auth == nil {
+		return Principal{}, errUnknownCredential
+	}
+	return s.auth.Authenticate(cred)
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller authenticated by requireRole, if any.
+func principalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// requireRole wraps next so that only callers holding role reach it.
+// Others get 401 without a valid credential and 403 with too weak a role.
+func (s *Server) requireRole(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.authenticate(r)
+		if err != nil {
+			detail := "a valid API key or token is required"
+			switch {
+			case s.auth == nil:
+				detail = "authentication is not configured on this server"
+			case errors.Is(err, errTokenExpired):
+				detail = "the token has expired"
+			}
+			if !errors.Is(err, errNoCredential) {
+				loggerFrom(r.Context()).Warn("Rejected credential", "path", r.URL.Path, "remote", r.RemoteAddr, "err", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gocodebot"`)
+			writeAPIError(w, http.StatusUnauthorized, errUnauthorized, detail)
+			return
+		}
+		if !principal.Role.Allows(role) {
+			writeAPIError(w, http.StatusForbidden, errForbidden, fmt.Sprintf("the %s role is required", role))
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// requireChat applies requireRole(roleChat) to chat endpoints when
+// auth.require_chat is set, and leaves them open otherwise.
+func (s *Server) requireChat(next http.HandlerFunc) http.HandlerFunc {
+	if !config.Auth.RequireChat {
+		return next
+	}
+	return s.requireRole(roleChat, next)
+}
+
+// checkOrigin accepts WebSocket handshakes from the page's own host and
+// from the origins in allowed. Requests without an Origin header come from
+// non-browser clients and are accepted too.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	origins := make(map[string]bool, len(allowed))
+	for _, origin := range allowed {
+		origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || origins["*"] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) || origins[strings.ToLower(u.Scheme+"://"+u.Host)] {
+			return true
+		}
+		loggerFrom(r.Context()).Warn("Rejected WebSocket origin", "origin", origin, "remote", r.RemoteAddr)
+		return false
+	}
+}
+
+// runTokenCommand implements "token", which prints an HMAC token signed
+// with auth.token_secret.
+func runTokenCommand(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	subject := fs.String("subject", "", "who the token is for")
+	role := fs.String("role", string(roleChat), "chat, trainer or admin")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the token is valid")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	switch {
+	case config.Auth.TokenSecret == "":
+		return errors.New("usage: token needs auth.token_secret to be configured")
+	case *subject == "":
+		return errors.New("usage: token -subject name [-role chat|trainer|admin] [-ttl 24h]")
+	case !Role(*role).Valid():
+		return fmt.Errorf("unknown role %q", *role)
+	case *ttl <= 0:
+		return errors.New("-ttl must be positive")
+	}
+
+	token, err := newHMACAuthenticator(config.Auth.TokenSecret).Issue(*subject, Role(*role), *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, token)
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTokenSecret = "0123456789abcdef0123456789abcdef"
+
+// testAuth returns an authenticator accepting a chat and an admin API key
+// and tokens signed with testTokenSecret, with the clock stopped at now.
+func testAuth(now time.Time) (authChain, *hmacAuthenticator) {
+	tokens := newHMACAuthenticator(testTokenSecret)
+	tokens.now = func() time.Time { return now }
+	keys := newAPIKeyAuthenticator([]APIKey{
+		{Name: "frontend", Role: roleChat, Key: "chat-key-0123456789"},
+		{Name: "ops", Role: roleAdmin, Key: "admin-key-0123456789"},
+	})
+	return authChain{keys, tokens}, tokens
+}
+
+// signPayload returns the token for a raw payload after the prefix,
+// validly signed.
+func signPayload(a *hmacAuthenticator, payload string) string {
+	payload = tokenPrefix + payload
+	return payload + "." + a.sign(payload)
+}
+
+// signClaims returns a validly signed token carrying claims as raw JSON.
+func signClaims(a *hmacAuthenticator, claims string) string {
+	return signPayload(a, base64.RawURLEncoding.EncodeToString([]byte(claims)))
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	auth, tokens := testAuth(now)
+	valid, err := tokens.Issue("alice", roleTrainer, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := tokens.Issue("alice", roleTrainer, -time.Second)
+	other := newHMACAuthenticator("another secret of enough length")
+	other.now = tokens.now
+	foreign, _ := other.Issue("alice", roleAdmin, time.Hour)
+
+	// The claims of the valid token with the role raised, under its signature
+	payload, signature, _ := strings.Cut(strings.TrimPrefix(valid, tokenPrefix), ".")
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	escalated := strings.Replace(string(claims), `"trainer"`, `"admin"`, 1)
+	tampered := tokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(escalated)) + "." + signature
+	exp := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+
+	tests := []struct {
+		name       string
+		credential string
+		want       Principal
+		wantErr    error // Checked with errors.Is when set
+	}{
+		{"chat API key", "chat-key-0123456789", Principal{Subject: "frontend", Role: roleChat}, nil},
+		{"admin API key", "admin-key-0123456789", Principal{Subject: "ops", Role: roleAdmin}, nil},
+		{"wrong API key", "chat-key-0123456780", Principal{}, errUnknownCredential},
+		{"API key prefix", "chat-key", Principal{}, errUnknownCredential},
+		{"valid token", valid, Principal{Subject: "alice", Role: roleTrainer}, nil},
+		{"expired token", expired, Principal{}, errTokenExpired},
+		{"token expiring now", signClaims(tokens, `{"sub":"alice","role":"chat","exp":`+exp(0)+`}`), Principal{}, errTokenExpired},
+		{"tampered signature", valid[:len(valid)-2] + "AA", Principal{}, nil},
+		{"tampered claims", tampered, Principal{}, nil},
+		{"signed with another secret", foreign, Principal{}, nil},
+		{"unsigned", tokenPrefix + payload, Principal{}, nil},
+		{"empty signature", tokenPrefix + payload + ".", Principal{}, nil},
+		{"claims not base64", signPayload(tokens, "!!!"), Principal{}, nil},
+		{"claims not JSON", signClaims(tokens, "not json"), Principal{}, nil},
+		{"unknown role", signClaims(tokens, `{"sub":"alice","role":"root","exp":`+exp(time.Hour)+`}`), Principal{}, nil},
+		{"no role", signClaims(tokens, `{"sub":"alice","exp":`+exp(time.Hour)+`}`), Principal{}, nil},
+		{"no expiry", signClaims(tokens, `{"sub":"alice","role":"chat"}`), Principal{}, errTokenExpired},
+		{"other format", "v2.e30.AAAA", Principal{}, errUnknownCredential},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auth.Authenticate(tt.credential)
+			if tt.want == (Principal{}) {
+				if err == nil {
+					t.Fatalf("Authenticate(%q) = %+v, want an error", tt.credential, got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("Authenticate(%q) error = %v, want %v", tt.credential, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Authenticate(%q) = %+v, %v; want %+v", tt.credential, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	now := time.Now()
+	auth, tokens := testAuth(now)
+	chatToken, _ := tokens.Issue("alice", roleChat, time.Hour)
+	trainerToken, _ := tokens.Issue("bob", roleTrainer, time.Hour)
+	expired, _ := tokens.Issue("bob", roleTrainer, -time.Minute)
+	handler := (&Server{auth: auth}).requireRole(roleTrainer, func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r.Context())
+		w.Write([]byte(p.Subject))
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		wantCode int
+		wantBody string // Substring of the response body
+	}{
+		{"no credential", "", "", http.StatusUnauthorized, errUnauthorized},
+		{"wrong key", "X-API-Key", "not-a-key-0123456789", http.StatusUnauthorized, errUnauthorized},
+		{"expired token", "Authorization", "Bearer " + expired, http.StatusUnauthorized, "the token has expired"},
+		{"chat role", "Authorization", "Bearer " + chatToken, http.StatusForbidden, "the trainer role is required"},
+		{"chat key", "X-API-Key", "chat-key-0123456789", http.StatusForbidden, errForbidden},
+		{"trainer role", "Authorization", "Bearer " + trainerToken, http.StatusOK, "bob"},
+		{"admin includes trainer", "X-API-Key", "admin-key-0123456789", http.StatusOK, "ops"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/train", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want %d containing %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			if tt.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+
+	// Without configured credentials nothing is accepted
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/train", nil)
+	r.Header.Set("X-API-Key", "admin-key-0123456789")
+	(&Server{}).requireRole(roleChat, handler)(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without auth configured: %d, want 401", w.Code)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		url     string
+		want    string
+	}{
+		{"bearer", map[string]string{"Authorization": "Bearer  abc "}, "/api", "abc"},
+		{"API key header", map[string]string{"X-API-Key": "key"}, "/api", "key"},
+		{"bearer first", map[string]string{"Authorization": "Bearer abc", "X-API-Key": "key"}, "/api", "abc"},
+		{"other scheme", map[string]string{"Authorization": "Basic abc"}, "/api", ""},
+		{"query parameter outside a handshake", nil, "/api?token=abc", ""},
+		{"query parameter of a handshake", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, "/ws?token=abc", "abc"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := credential(r); got != tt.want {
+			t.Errorf("%s: credential = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same host", nil, "https://bot.example.com", true},
+		{"same host, other case", nil, "https://BOT.example.com", true},
+		{"other host", nil, "https://evil.example.com", false},
+		{"allowed", []string{"https://app.example.com/"}, "https://app.example.com", true},
+		{"allowed, other case", []string{"https://App.Example.com"}, "https://app.example.com", true},
+		{"allowed host, other scheme", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"allowed host, other port", []string{"https://app.example.com"}, "https://app.example.com:8443", false},
+		{"lookalike", []string{"https://app.example.com"}, "https://app.example.com.evil.net", false},
+		{"wildcard", []string{"*"}, "https://evil.example.com", true},
+		{"unparsable", nil, "://%zz", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://bot.example.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkOrigin(tt.allowed)(r); got != tt.want {
+			t.Errorf("%s: checkOrigin(%q) for %q = %v, want %v", tt.name, tt.allowed, tt.origin, got, tt.want)
+		}
+	}
+}
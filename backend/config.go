@@ -0,0 +1,682 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config files read when -config and CONFIG_FILE are not given. Neither has
+// to exist. db.json is the older flat format keyed by environment variable.
+const (
+	defaultConfigFile = "../config/config.json"
+	legacyConfigFile  = "../config/db.json"
+)
+
+// Config holds every setting of the bot. Values are resolved in order of
+// increasing precedence: defaults, config file, environment, flags.
+type Config struct {
+	Server    ServerConfig    `json:"server"`
+	Storage   StorageConfig   `json:"storage"`
+	Model     ModelConfig     `json:"model"`
+	Retrieval RetrievalConfig `json:"retrieval"`
+	Intents   IntentsConfig   `json:"intents"`
+	Labelling LabellingConfig `json:"labelling"`
+	Scheduler SchedulerConfig `json:"scheduler"`
+	Log       LogConfig       `json:"log"`
+	Auth      AuthConfig      `json:"auth"`
+	Limits    LimitsConfig    `json:"limits"`
+}
+
+// ServerConfig configures the HTTP and WebSocket server.
+type ServerConfig struct {
+	Addr               string   `json:"addr"`                 // Listen address, host:port
+	FrontendDir        string   `json:"frontend_dir"`         // Static files served at /
+	SessionIdleTimeout Duration `json:"session_idle_timeout"` // Inactivity before a session's context is dropped
+	ResponseChunkSize  int      `json:"response_chunk_size"`  // Maximum characters per streamed delta
+	ReadTimeout        Duration `json:"read_timeout"`         // Limit on reading a whole request
+	WriteTimeout       Duration `json:"write_timeout"`        // Limit on writing a response
+	IdleTimeout        Duration `json:"idle_timeout"`         // Keep-alive connection lifetime between requests
+	ShutdownTimeout    Duration `json:"shutdown_timeout"`     // Time allowed to drain connections and flush writes
+	AllowedOrigins     []string `json:"allowed_origins"`      // Extra WebSocket origins, such as "https://example.com", or "*"
+}
+
+// StorageConfig configures the store and the write-behind queue.
+type StorageConfig struct {
+	Driver              string   `json:"driver"` // mysql, sqlite or memory; empty picks from URL
+	URL                 string   `json:"url" secret:"true"`
+	AutoMigrate         bool     `json:"auto_migrate"`
+	WriteQueueSize      int      `json:"write_queue_size"`
+	WriteBatchSize      int      `json:"write_batch_size"`
+	WriteFlushInterval  Duration `json:"write_flush_interval"`
+	WriteSpillFile      string   `json:"write_spill_file"`
+	WriteQuarantineFile string   `json:"write_quarantine_file"` // Rows the store rejects on their own
+}
+
+// ModelConfig names the files the model is built from.
+type ModelConfig struct {
+	CorpusFile   string         `json:"corpus_file"` // Markdown corpus, read before the other sources
+	KeywordsFile string         `json:"keywords_file"`
+	Sources      []CorpusSource `json:"sources"` // Further Markdown, text, Q&A and Go sources
+}
+
+// RetrievalConfig tunes how answers are found.
+type RetrievalConfig struct {
+	K              int       `json:"k"`                // Neighbours consulted by KNN
+	KNNMaxDistance float64   `json:"knn_max_distance"` // Furthest a neighbour may be, between unit vectors (0 to √2)
+	TopMatches     int       `json:"top_matches"`      // Ranked sections returned with each answer
+	Variants       []Variant `json:"variants"`         // Section retrieval strategies to A/B test; none means control only
+}
+
+// IntentsConfig tunes intent discovery.
+type IntentsConfig struct {
+	MinExamples         int     `json:"min_examples"`          // Phrases needed before a discovered intent is used
+	SmallTalkConfidence float64 `json:"small_talk_confidence"` // Least confidence for a greeting or farewell to be answered as one
+	SmallTalkMaxScore   float64 `json:"small_talk_max_score"`  // A section matching at least this well answers the query instead
+}
+
+// LabellingConfig picks the turns queued for labelling. Fallbacks are
+// always queued.
+type LabellingConfig struct {
+	MinConfidence float64 `json:"min_confidence"` // Intents matched with less confidence are queued
+	TieRatio      float64 `json:"tie_ratio"`      // Answers whose runner-up scored at least this fraction of them are queued
+	MaxRating     int     `json:"max_rating"`     // Answers rated this or lower are queued
+}
+
+// SchedulerConfig sets how often background jobs run. Zero disables a job.
+type SchedulerConfig struct {
+	ValidateIntentsInterval Duration `json:"validate_intents_interval"`
+	RetrainInterval         Duration `json:"retrain_interval"`
+	ReloadInterval          Duration `json:"reload_interval"` // How often the model files are checked for changes
+}
+
+// LogConfig configures structured logging.
+type LogConfig struct {
+	Level         string `json:"level"`          // debug, info, warn or error
+	Format        string `json:"format"`         // text or json
+	RedactQueries bool   `json:"redact_queries"` // Log a fingerprint instead of query text
+}
+
+// AuthConfig configures who may use which endpoints. Without API keys or
+// a token secret, /train and /admin refuse every request.
+type AuthConfig struct {
+	APIKeys     []APIKey `json:"api_keys"`
+	TokenSecret string   `json:"token_secret" secret:"true"` // Signs HMAC tokens
+	RequireChat bool     `json:"require_chat"`               // Require the chat role on /ws and /api/v1 query and feedback
+}
+
+// LimitsConfig protects the server from clients that send too much. Rate
+// limits are token buckets per API key or token subject, else per IP.
+type LimitsConfig struct {
+	RequestsPerMinute       int      `json:"requests_per_minute"`        // Queries and feedback, over WebSocket and REST
+	RequestBurst            int      `json:"request_burst"`              // Requests allowed at once after a quiet spell
+	TrainPerMinute          int      `json:"train_per_minute"`           // POSTs to /train
+	TrainBurst              int      `json:"train_burst"`                // /train requests allowed at once
+	MaxMessageSize          int      `json:"max_message_size"`           // Bytes per WebSocket message
+	MaxConnectionsPerClient int      `json:"max_connections_per_client"` // Open WebSocket connections
+	WebSocketIdleTimeout    Duration `json:"websocket_idle_timeout"`     // Silence before a WebSocket is closed
+}
+
+// Duration is a time.Duration written as a string such as "30m" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\" or \"1h\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Settings resolved at startup.
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:               ":8080",
+			FrontendDir:        "../frontend",
+			SessionIdleTimeout: Duration(defaultSessionIdleTimeout),
+			ResponseChunkSize:  defaultResponseChunkSize,
+			ReadTimeout:        Duration(defaultReadTimeout),
+			WriteTimeout:       Duration(defaultWriteTimeout),
+			IdleTimeout:        Duration(defaultIdleTimeout),
+			ShutdownTimeout:    Duration(defaultShutdownTimeout),
+		},
+		Storage: StorageConfig{
+			AutoMigrate:         true,
+			WriteQueueSize:      defaultWriteQueueSize,
+			WriteBatchSize:      defaultWriteBatchSize,
+			WriteFlushInterval:  Duration(defaultWriteFlushInterval),
+			WriteSpillFile:      defaultWriteSpillFile,
+			WriteQuarantineFile: defaultWriteQuarantineFile,
+		},
+		Model: ModelConfig{
+			CorpusFile:   "go_corpus.md",
+			KeywordsFile: "Go_Keyword_Entities.yaml",
+		},
+		Retrieval: RetrievalConfig{
+			K:              3,
+			KNNMaxDistance: 0.9,
+			TopMatches:     3,
+		},
+		Intents: IntentsConfig{
+			MinExamples:         3,
+			SmallTalkConfidence: 0.8,
+			SmallTalkMaxScore:   0.25,
+		},
+		Labelling: LabellingConfig{
+			MinConfidence: 0.3,
+			TieRatio:      0.9,
+			MaxRating:     2,
+		},
+		Scheduler: SchedulerConfig{
+			ReloadInterval: Duration(5 * time.Second),
+		},
+		Limits: LimitsConfig{
+			RequestsPerMinute:       defaultRequestsPerMinute,
+			RequestBurst:            defaultRequestBurst,
+			TrainPerMinute:          defaultTrainPerMinute,
+			TrainBurst:              defaultTrainBurst,
+			MaxMessageSize:          defaultMaxMessageSize,
+			MaxConnectionsPerClient: defaultMaxConnections,
+			WebSocketIdleTimeout:    Duration(defaultWebSocketIdleTimeout),
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: logFormatText,
+		},
+	}
+}
+
+// setting binds one configuration value to its environment variable and
+// command-line flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	field func(c *Config) interface{} // Pointer to the field
+}
+
+var settings = []setting{
+	{"SERVER_ADDR", "addr", "listen address (host:port)", func(c *Config) interface{} { return &c.Server.Addr }},
+	{"FRONTEND_DIR", "frontend", "directory of static files served at /", func(c *Config) interface{} { return &c.Server.FrontendDir }},
+	{"SESSION_IDLE_TIMEOUT", "session-idle-timeout", "inactivity before a session's context is dropped", func(c *Config) interface{} { return &c.Server.SessionIdleTimeout }},
+	{"RESPONSE_CHUNK_SIZE", "response-chunk-size", "maximum characters per streamed delta", func(c *Config) interface{} { return &c.Server.ResponseChunkSize }},
+	{"READ_TIMEOUT", "read-timeout", "limit on reading a whole request", func(c *Config) interface{} { return &c.Server.ReadTimeout }},
+	{"WRITE_TIMEOUT", "write-timeout", "limit on writing a response", func(c *Config) interface{} { return &c.Server.WriteTimeout }},
+	{"IDLE_TIMEOUT", "idle-timeout", "keep-alive connection lifetime between requests", func(c *Config) interface{} { return &c.Server.IdleTimeout }},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time allowed to drain connections and flush writes", func(c *Config) interface{} { return &c.Server.ShutdownTimeout }},
+	{"DB_DRIVER", "db-driver", "storage driver: mysql, sqlite or memory", func(c *Config) interface{} { return &c.Storage.Driver }},
+	{"DB_URL", "db-url", "data source name of the store", func(c *Config) interface{} { return &c.Storage.URL }},
+	{"DB_AUTO_MIGRATE", "db-auto-migrate", "apply pending migrations at startup", func(c *Config) interface{} { return &c.Storage.AutoMigrate }},
+	{"WRITE_QUEUE_SIZE", "write-queue-size", "writes buffered before backpressure", func(c *Config) interface{} { return &c.Storage.WriteQueueSize }},
+	{"WRITE_BATCH_SIZE", "write-batch-size", "writes per batch", func(c *Config) interface{} { return &c.Storage.WriteBatchSize }},
+	{"WRITE_FLUSH_INTERVAL", "write-flush-interval", "longest time a write waits in the queue", func(c *Config) interface{} { return &c.Storage.WriteFlushInterval }},
+	{"WRITE_SPILL_FILE", "write-spill-file", "where batches go when the store keeps failing", func(c *Config) interface{} { return &c.Storage.WriteSpillFile }},
+	{"WRITE_QUARANTINE_FILE", "write-quarantine-file", "where rows go that the store rejects on their own", func(c *Config) interface{} { return &c.Storage.WriteQuarantineFile }},
+	{"CORPUS_FILE", "corpus", "markdown corpus the model is built from", func(c *Config) interface{} { return &c.Model.CorpusFile }},
+	{"KEYWORDS_FILE", "keywords", "Go keyword descriptions", func(c *Config) interface{} { return &c.Model.KeywordsFile }},
+	{"CORPUS_SOURCES", "sources", "comma-separated kind:path corpus sources (kind markdown, text, qa or go)", func(c *Config) interface{} { return &c.Model.Sources }},
+	{"KNN_K", "k", "neighbours consulted by KNN", func(c *Config) interface{} { return &c.Retrieval.K }},
+	{"KNN_MAX_DISTANCE", "knn-max-distance", "furthest a KNN neighbour may be from the query, between unit vectors", func(c *Config) interface{} { return &c.Retrieval.KNNMaxDistance }},
+	{"TOP_MATCHES", "top-matches", "ranked sections returned with each answer", func(c *Config) interface{} { return &c.Retrieval.TopMatches }},
+	{"RETRIEVAL_VARIANTS", "variants", "comma-separated name:weighting:similarity[:weight] retrieval variants to A/B test", func(c *Config) interface{} { return &c.Retrieval.Variants }},
+	{"INTENT_MIN_EXAMPLES", "intent-min-examples", "phrases needed before a discovered intent is used", func(c *Config) interface{} { return &c.Intents.MinExamples }},
+	{"INTENT_SMALL_TALK_CONFIDENCE", "intent-small-talk-confidence", "least confidence for a greeting or farewell to be answered as one", func(c *Config) interface{} { return &c.Intents.SmallTalkConfidence }},
+	{"INTENT_SMALL_TALK_MAX_SCORE", "intent-small-talk-max-score", "section score at which a greeting or farewell is answered from the section instead", func(c *Config) interface{} { return &c.Intents.SmallTalkMaxScore }},
+	{"LABEL_MIN_CONFIDENCE", "label-min-confidence", "queue intents matched with less confidence for labelling", func(c *Config) interface{} { return &c.Labelling.MinConfidence }},
+	{"LABEL_TIE_RATIO", "label-tie-ratio", "queue answers whose runner-up scored at least this fraction of them", func(c *Config) interface{} { return &c.Labelling.TieRatio }},
+	{"LABEL_MAX_RATING", "label-max-rating", "queue answers rated this or lower", func(c *Config) interface{} { return &c.Labelling.MaxRating }},
+	{"VALIDATE_INTENTS_INTERVAL", "validate-intents-interval", "how often discovered intents are promoted (0 disables)", func(c *Config) interface{} { return &c.Scheduler.ValidateIntentsInterval }},
+	{"RETRAIN_INTERVAL", "retrain-interval", "how often the model is retrained from feedback (0 disables)", func(c *Config) interface{} { return &c.Scheduler.RetrainInterval }},
+	{"RELOAD_INTERVAL", "reload-interval", "how often the corpus and keyword files are checked for changes (0 disables)", func(c *Config) interface{} { return &c.Scheduler.ReloadInterval }},
+	{"ALLOWED_ORIGINS", "allowed-origins", "comma-separated WebSocket origins allowed besides the server's own", func(c *Config) interface{} { return &c.Server.AllowedOrigins }},
+	{"AUTH_API_KEYS", "api-keys", "comma-separated name:role:key API keys", func(c *Config) interface{} { return &c.Auth.APIKeys }},
+	{"AUTH_TOKEN_SECRET", "token-secret", "secret that signs HMAC tokens", func(c *Config) interface{} { return &c.Auth.TokenSecret }},
+	{"AUTH_REQUIRE_CHAT", "require-chat", "require the chat role on /ws and the REST chat endpoints", func(c *Config) interface{} { return &c.Auth.RequireChat }},
+	{"REQUESTS_PER_MINUTE", "requests-per-minute", "queries and feedback allowed per client per minute", func(c *Config) interface{} { return &c.Limits.RequestsPerMinute }},
+	{"REQUEST_BURST", "request-burst", "requests a client may make at once", func(c *Config) interface{} { return &c.Limits.RequestBurst }},
+	{"TRAIN_PER_MINUTE", "train-per-minute", "training requests allowed per client per minute", func(c *Config) interface{} { return &c.Limits.TrainPerMinute }},
+	{"TRAIN_BURST", "train-burst", "training requests a client may make at once", func(c *Config) interface{} { return &c.Limits.TrainBurst }},
+	{"MAX_MESSAGE_SIZE", "max-message-size", "largest WebSocket message in bytes", func(c *Config) interface{} { return &c.Limits.MaxMessageSize }},
+	{"MAX_CONNECTIONS_PER_CLIENT", "max-connections-per-client", "open WebSocket connections allowed per client", func(c *Config) interface{} { return &c.Limits.MaxConnectionsPerClient }},
+	{"WEBSOCKET_IDLE_TIMEOUT", "websocket-idle-timeout", "silence before a WebSocket connection is closed", func(c *Config) interface{} { return &c.Limits.WebSocketIdleTimeout }},
+	{"LOG_LEVEL", "log-level", "lowest level logged: debug, info, warn or error", func(c *Config) interface{} { return &c.Log.Level }},
+	{"LOG_FORMAT", "log-format", "log output format: text or json", func(c *Config) interface{} { return &c.Log.Format }},
+	{"LOG_REDACT_QUERIES", "log-redact-queries", "log a fingerprint instead of query text", func(c *Config) interface{} { return &c.Log.RedactQueries }},
+}
+
+// setValue parses s into the field pointed to by ptr.
+func setValue(ptr interface{}, s string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = s
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		*p = f
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		*p = b
+	case *Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", s)
+		}
+		*p = Duration(d)
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	case *[]APIKey:
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			parts := strings.SplitN(item, ":", 3)
+			if len(parts) != 3 {
+				return errors.New("API keys must be written name:role:key")
+			}
+			*p = append(*p, APIKey{Name: parts[0], Role: Role(parts[1]), Key: parts[2]})
+		}
+	case *[]Variant:
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := parseVariant(item)
+			if err != nil {
+				return err
+			}
+			*p = append(*p, v)
+		}
+	case *[]CorpusSource:
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			source, err := parseCorpusSource(item)
+			if err != nil {
+				return err
+			}
+			*p = append(*p, source)
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
+
+// loadConfig resolves the configuration from defaults, the config file,
+// the environment and args, in that order. It returns the arguments left
+// after the flags.
+func loadConfig(args []string) (Config, []string, error) {
+	c := defaultConfig()
+
+	fs := flag.NewFlagSet("gocodebot", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", "", "JSON config file (default "+defaultConfigFile+")")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		name := s.flag
+		fs.Func(name, s.usage, func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return c, nil, err
+	}
+
+	// Config file: -config, then CONFIG_FILE, then the optional defaults
+	path, required := *configFile, true
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		path, required = defaultConfigFile, false
+	}
+	if err := readConfigFile(&c, path, required); err != nil {
+		return c, nil, err
+	}
+	if err := readLegacyConfigFile(&c, legacyConfigFile); err != nil {
+		return c, nil, err
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := setValue(s.field(&c), value); err != nil {
+				return c, nil, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := flagValues[s.flag]; ok {
+			if err := setValue(s.field(&c), value); err != nil {
+				return c, nil, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	// Without a driver, use MySQL when a data source is configured
+	if c.Storage.Driver == "" {
+		c.Storage.Driver = storeMemory
+		if c.Storage.URL != "" {
+			c.Storage.Driver = storeMySQL
+		}
+	}
+
+	return c, fs.Args(), c.Validate()
+}
+
+// readConfigFile decodes a JSON config file over c. A missing file is an
+// error only if required.
+func readConfigFile(c *Config, path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readLegacyConfigFile applies a flat {"DB_URL": "..."} file, keyed by
+// environment variable, with the precedence of the config file. Keys that
+// name no setting are logged and skipped.
+func readLegacyConfigFile(c *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	slog.Warn("Legacy config file is deprecated", "file", path, "replacement", defaultConfigFile)
+	for key, value := range values {
+		found := false
+		for _, s := range settings {
+			if s.env == key {
+				if err := setValue(s.field(c), value); err != nil {
+					return fmt.Errorf("config file %s: %s: %w", path, key, err)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			// The old loader exported every key, so files may hold others
+			slog.Warn("Ignoring unknown setting in legacy config file", "file", path, "key", key)
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		problem("server.addr: %q is not a host:port address", c.Server.Addr)
+	}
+	if c.Server.SessionIdleTimeout <= 0 {
+		problem("server.session_idle_timeout must be positive")
+	}
+	if c.Server.ResponseChunkSize < 1 {
+		problem("server.response_chunk_size must be at least 1")
+	}
+	for name, d := range map[string]Duration{
+		"server.read_timeout":     c.Server.ReadTimeout,
+		"server.write_timeout":    c.Server.WriteTimeout,
+		"server.idle_timeout":     c.Server.IdleTimeout,
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+	} {
+		if d <= 0 {
+			problem("%s must be positive", name)
+		}
+	}
+
+	switch c.Storage.Driver {
+	case storeMySQL, storeSQLite:
+		if c.Storage.URL == "" {
+			problem("storage.url is required for the %s driver", c.Storage.Driver)
+		}
+	case storeMemory:
+	default:
+		problem("storage.driver: unknown driver %q (want %s, %s or %s)", c.Storage.Driver, storeMySQL, storeSQLite, storeMemory)
+	}
+	if c.Storage.WriteQueueSize < 1 {
+		problem("storage.write_queue_size must be at least 1")
+	}
+	if c.Storage.WriteBatchSize < 1 {
+		problem("storage.write_batch_size must be at least 1")
+	}
+	if c.Storage.WriteFlushInterval <= 0 {
+		problem("storage.write_flush_interval must be positive")
+	}
+	if c.Storage.WriteSpillFile == "" {
+		problem("storage.write_spill_file is required")
+	}
+	if c.Storage.WriteQuarantineFile == "" {
+		problem("storage.write_quarantine_file is required")
+	}
+
+	if c.Model.CorpusFile != "" {
+		if _, err := os.Stat(c.Model.CorpusFile); err != nil {
+			problem("model.corpus_file: %v", err)
+		}
+	}
+	if _, err := os.Stat(c.Model.KeywordsFile); err != nil {
+		problem("model.keywords_file: %v", err)
+	}
+	if len(c.Model.corpusSources()) == 0 {
+		problem("model: a corpus_file or sources are required")
+	}
+	for i, source := range c.Model.Sources {
+		switch source.Kind {
+		case sourceMarkdown, sourceText, sourceQA, sourceGo:
+		default:
+			problem("model.sources[%d]: unknown kind %q (want %s, %s, %s or %s)", i, source.Kind, sourceMarkdown, sourceText, sourceQA, sourceGo)
+		}
+		if source.Path == "" {
+			problem("model.sources[%d]: path is required", i)
+		} else if _, err := filepath.Match(source.Path, ""); err != nil {
+			problem("model.sources[%d]: %v", i, err)
+		}
+	}
+
+	if c.Retrieval.K < 1 {
+		problem("retrieval.k must be at least 1")
+	}
+	if c.Retrieval.KNNMaxDistance <= 0 || c.Retrieval.KNNMaxDistance > math.Sqrt2 {
+		problem("retrieval.knn_max_distance must be above 0 and at most √2")
+	}
+	if c.Retrieval.TopMatches < 1 {
+		problem("retrieval.top_matches must be at least 1") // Answers fall back to the best ranked section
+	}
+	variants := make(map[string]bool)
+	for i, v := range c.Retrieval.Variants {
+		if v.Name == "" || variants[v.Name] {
+			problem("retrieval.variants[%d]: name must be set and unique", i)
+		}
+		variants[v.Name] = true
+		if v.Weighting != weightingTFIDF && v.Weighting != weightingBM25 {
+			problem("retrieval.variants[%d]: unknown weighting %q (want %s or %s)", i, v.Weighting, weightingTFIDF, weightingBM25)
+		}
+		if v.Similarity != similarityCosine && v.Similarity != similarityEuclidean {
+			problem("retrieval.variants[%d]: unknown similarity %q (want %s or %s)", i, v.Similarity, similarityCosine, similarityEuclidean)
+		}
+		if v.Weight < 0 {
+			problem("retrieval.variants[%d]: weight must not be negative", i)
+		}
+	}
+	if c.Intents.MinExamples < 1 {
+		problem("intents.min_examples must be at least 1")
+	}
+	if c.Intents.SmallTalkConfidence < 0 || c.Intents.SmallTalkConfidence > 1 {
+		problem("intents.small_talk_confidence must be from 0 to 1")
+	}
+	if c.Intents.SmallTalkMaxScore <= 0 || c.Intents.SmallTalkMaxScore > 1 {
+		problem("intents.small_talk_max_score must be above 0 and at most 1")
+	}
+	if c.Labelling.MinConfidence < 0 || c.Labelling.MinConfidence > 1 {
+		problem("labelling.min_confidence must be from 0 to 1")
+	}
+	if c.Labelling.TieRatio <= 0 || c.Labelling.TieRatio > 1 {
+		problem("labelling.tie_ratio must be above 0 and at most 1")
+	}
+	if c.Labelling.MaxRating < 0 || c.Labelling.MaxRating > maxRating {
+		problem("labelling.max_rating must be from 0 (never) to %d", maxRating)
+	}
+	if c.Scheduler.ValidateIntentsInterval < 0 {
+		problem("scheduler.validate_intents_interval must not be negative")
+	}
+	if c.Scheduler.RetrainInterval < 0 {
+		problem("scheduler.retrain_interval must not be negative")
+	}
+	if c.Scheduler.ReloadInterval < 0 {
+		problem("scheduler.reload_interval must not be negative")
+	}
+	for name, n := range map[string]int{
+		"limits.requests_per_minute":        c.Limits.RequestsPerMinute,
+		"limits.request_burst":              c.Limits.RequestBurst,
+		"limits.train_per_minute":           c.Limits.TrainPerMinute,
+		"limits.train_burst":                c.Limits.TrainBurst,
+		"limits.max_connections_per_client": c.Limits.MaxConnectionsPerClient,
+	} {
+		if n < 1 {
+			problem("%s must be at least 1", name)
+		}
+	}
+	if c.Limits.MaxMessageSize < maxQueryLength {
+		problem("limits.max_message_size must be at least %d", maxQueryLength)
+	}
+	if c.Limits.WebSocketIdleTimeout <= 0 {
+		problem("limits.websocket_idle_timeout must be positive")
+	}
+	if _, err := parseLogLevel(c.Log.Level); err != nil {
+		problem("log.level: unknown level %q (want debug, info, warn or error)", c.Log.Level)
+	}
+	for _, origin := range c.Server.AllowedOrigins {
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "") {
+			problem("server.allowed_origins: %q is not an origin such as https://example.com", origin)
+		}
+	}
+	names := make(map[string]bool)
+	for i, key := range c.Auth.APIKeys {
+		if key.Name == "" || names[key.Name] {
+			problem("auth.api_keys[%d]: name must be set and unique", i)
+		}
+		names[key.Name] = true
+		if !key.Role.Valid() {
+			problem("auth.api_keys[%d]: unknown role %q (want %s, %s or %s)", i, key.Role, roleChat, roleTrainer, roleAdmin)
+		}
+		if len(key.Key) < minCredentialLength {
+			problem("auth.api_keys[%d]: key must be at least %d characters", i, minCredentialLength)
+		}
+	}
+	if c.Auth.TokenSecret != "" && len(c.Auth.TokenSecret) < minCredentialLength {
+		problem("auth.token_secret must be at least %d characters", minCredentialLength)
+	}
+	if c.Auth.RequireChat && len(c.Auth.APIKeys) == 0 && c.Auth.TokenSecret == "" {
+		problem("auth.require_chat needs auth.api_keys or auth.token_secret")
+	}
+	if c.Log.Format != logFormatText && c.Log.Format != logFormatJSON {
+		problem("log.format: unknown format %q (want %s or %s)", c.Log.Format, logFormatText, logFormatJSON)
+	}
+
+	return errors.Join(problems...)
+}
+
+// Redacted returns the configuration as a JSON-ready map with every field
+// tagged secret:"true" replaced by a placeholder.
+func (c Config) Redacted() map[string]interface{} {
+	return redact(reflect.ValueOf(c))
+}
+
+func redact(v reflect.Value) map[string]interface{} {
+	out := make(map[string]interface{})
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		value := v.Field(i)
+		switch {
+		case field.Tag.Get("secret") == "true":
+			if value.String() != "" {
+				out[name] = "[redacted]"
+			} else {
+				out[name] = ""
+			}
+		case value.Kind() == reflect.Struct:
+			out[name] = redact(value)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			items := make([]interface{}, value.Len())
+			for j := range items {
+				items[j] = redact(value.Index(j))
+			}
+			out[name] = items
+		default:
+			out[name] = value.Interface()
+		}
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearConfigEnv unsets every setting's environment variable for the test.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"CONFIG_FILE"}
+	for _, s := range settings {
+		names = append(names, s.env)
+	}
+	for _, name := range names {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Setenv(name, "") // Restored after the test
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// writeConfigFile writes content to a file in a temporary directory.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, "config.json", `{
+		"server": {"addr": ":1001", "read_timeout": "3s"},
+		"retrieval": {"k": 4, "top_matches": 2},
+		"log": {"level": "warn"}
+	}`)
+	t.Setenv("KNN_K", "5")
+	t.Setenv("TOP_MATCHES", "6")
+
+	c, args, err := loadConfig([]string{"-config", path, "-top-matches", "7", "eval", "golden.jsonl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"default", c.Server.ResponseChunkSize, defaultResponseChunkSize},
+		{"file over default", c.Server.Addr, ":1001"},
+		{"file duration", c.Server.ReadTimeout, Duration(3 * time.Second)},
+		{"file log level", c.Log.Level, "warn"},
+		{"environment over file", c.Retrieval.K, 5},
+		{"flag over environment", c.Retrieval.TopMatches, 7},
+		{"driver without a URL", c.Storage.Driver, storeMemory},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if strings.Join(args, " ") != "eval golden.jsonl" {
+		t.Errorf("args = %q, want the command after the flags", args)
+	}
+
+	// CONFIG_FILE names the file when -config does not
+	t.Setenv("CONFIG_FILE", path)
+	if c, _, err := loadConfig(nil); err != nil || c.Server.Addr != ":1001" {
+		t.Errorf("with CONFIG_FILE: addr %q, %v; want :1001", c.Server.Addr, err)
+	}
+	t.Setenv("DB_URL", "user:pass@tcp(db:3306)/bot")
+	if c, _, err := loadConfig(nil); err != nil || c.Storage.Driver != storeMySQL {
+		t.Errorf("with a URL and no driver: driver %q, %v; want %s", c.Storage.Driver, err, storeMySQL)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	clearConfigEnv(t)
+	tests := []struct {
+		name    string
+		file    string // Config file content, if any
+		env     map[string]string
+		args    []string
+		wantErr []string // Substrings of the error
+	}{
+		{
+			name:    "missing file named by -config",
+			args:    []string{"-config", filepath.Join(t.TempDir(), "missing.json")},
+			wantErr: []string{"config file:"},
+		},
+		{
+			name:    "unknown key",
+			file:    `{"server": {"adress": ":1"}}`,
+			wantErr: []string{`unknown field "adress"`},
+		},
+		{
+			name:    "bad duration in file",
+			file:    `{"server": {"read_timeout": "soon"}}`,
+			wantErr: []string{`invalid duration "soon"`},
+		},
+		{
+			name:    "bad environment value",
+			env:     map[string]string{"KNN_K": "three"},
+			wantErr: []string{`environment variable KNN_K: "three" is not an integer`},
+		},
+		{
+			name:    "bad flag value",
+			args:    []string{"-db-auto-migrate", "perhaps"},
+			wantErr: []string{`flag -db-auto-migrate: "perhaps" is not a boolean`},
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-no-such-flag"},
+			wantErr: []string{"no-such-flag"},
+		},
+		{
+			name: "every invalid setting at once",
+			file: `{"retrieval": {"k": 0, "knn_max_distance": 2}, "storage": {"driver": "sqlite"}}`,
+			args: []string{"-addr", "nowhere", "-log-level", "loud", "-api-keys", "ops:root:short"},
+			wantErr: []string{
+				`server.addr: "nowhere" is not a host:port address`,
+				"retrieval.k must be at least 1",
+				"retrieval.knn_max_distance must be above 0 and at most √2",
+				"storage.url is required for the sqlite driver",
+				`log.level: unknown level "loud"`,
+				`auth.api_keys[0]: unknown role "root"`,
+				"auth.api_keys[0]: key must be at least",
+			},
+		},
+		{
+			name:    "chat auth without credentials",
+			env:     map[string]string{"AUTH_REQUIRE_CHAT": "true"},
+			wantErr: []string{"auth.require_chat needs auth.api_keys or auth.token_secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, "config.json", tt.file)}, args...)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := loadConfig(args)
+			if err == nil {
+				t.Fatal("loadConfig succeeded, want an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadLegacyConfigFile(t *testing.T) {
+	c := defaultConfig()
+	path := writeConfigFile(t, "db.json", `{"DB_DRIVER": "sqlite", "DB_URL": "bot.db", "GOPATH": "/go"}`)
+	if err := readLegacyConfigFile(&c, path); err != nil {
+		t.Fatalf("unknown keys were rejected: %v", err)
+	}
+	if c.Storage.Driver != storeSQLite || c.Storage.URL != "bot.db" {
+		t.Errorf("storage = %q %q, want sqlite bot.db", c.Storage.Driver, c.Storage.URL)
+	}
+
+	if err := readLegacyConfigFile(&c, filepath.Join(t.TempDir(), "db.json")); err != nil {
+		t.Errorf("a missing legacy file is an error: %v", err)
+	}
+	bad := writeConfigFile(t, "db.json", `{"WRITE_BATCH_SIZE": "many"}`)
+	if err := readLegacyConfigFile(&c, bad); err == nil || !strings.Contains(err.Error(), "WRITE_BATCH_SIZE") {
+		t.Errorf("bad value: %v, want an error naming the key", err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Kinds of corpus source.
+const (
+	sourceMarkdown = "markdown" // Markdown files, one document per headed section
+	sourceText     = "text"     // Plain text files, one document per file
+	sourceQA       = "qa"       // JSONL files of question and answer pairs
+	sourceGo       = "go"       // Go packages, documented with go/doc
+)
+
+// Extensions of the files read from a directory, by kind of source.
+var sourceExtensions = map[string][]string{
+	sourceMarkdown: {".md", ".markdown"},
+	sourceText:     {".txt"},
+	sourceQA:       {".jsonl"},
+}
+
+// Kinds of corpus document, as recorded in DocSource.
+const (
+	docMarkdown = "markdown"
+	docText     = "text"
+	docQA       = "qa"
+	docPackage  = "package"
+	docType     = "type"
+	docFunc     = "func"
+	docMethod   = "method"
+	docKeyword  = "keyword" // A keyword knowledge base entry, as the source of a term definition
+)
+
+// DocSource records where a corpus section came from.
+type DocSource struct {
+	Kind    string `json:"kind"`              // markdown, text, qa, package, type, func, method or keyword
+	Package string `json:"package,omitempty"` // Import path of Go documentation
+	Name    string `json:"name,omitempty"`    // Declared name, such as Builder.WriteString, or keyword name
+	File    string `json:"file"`
+	Line    int    `json:"line"` // Line of the heading, question or declaration
+}
+
+// CorpusSource is a set of documents the corpus is built from.
+type CorpusSource struct {
+	Kind string `json:"kind"` // markdown, text, qa or go
+	Path string `json:"path"` // A file, a directory or a glob pattern; for go, a package directory or dir/...
+}
+
+// parseCorpusSource parses kind:path.
+func parseCorpusSource(s string) (CorpusSource, error) {
+	kind, path, ok := strings.Cut(s, ":")
+	if !ok {
+		return CorpusSource{}, errors.New("corpus sources must be written kind:path")
+	}
+	return CorpusSource{Kind: kind, Path: path}, nil
+}
+
+// corpusSources lists the sources of a model: its corpus file, if any,
+// then the configured sources.
+func (m ModelConfig) corpusSources() []CorpusSource {
+	var sources []CorpusSource
+	if m.CorpusFile != "" {
+		sources = append(sources, CorpusSource{Kind: sourceMarkdown, Path: m.CorpusFile})
+	}
+	return append(sources, m.Sources...)
+}
+
+// DocumentStore is the corpus every part of the model reads from, built
+// from all of its sources.
+type DocumentStore struct {
+	Sections   []Section // Every document, in source order
+	Lines      []string  // Text of the Markdown, text and Q&A documents
+	Files      []string  // Files read, for the model version
+	Duplicates int       // Documents dropped because an earlier source had them
+}
+
+// loadDocuments reads every source into one store. A file matched by more
+// than one source is read once, and a document whose title and text repeat
+// an earlier one is dropped.
+func loadDocuments(sources []CorpusSource) (*DocumentStore, error) {
+	docs := &DocumentStore{}
+	files := make(map[string]bool)
+	documents := make(map[string]bool)
+	add := func(sections []Section) {
+		for _, section := range sections {
+			key := documentKey(section)
+			if documents[key] {
+				docs.Duplicates++
+				continue
+			}
+			documents[key] = true
+			section.Index = len(docs.Sections)
+			docs.Sections = append(docs.Sections, section)
+			docs.Lines = append(docs.Lines, section.lines()...)
+		}
+	}
+
+	for _, source := range sources {
+		if source.Kind == sourceGo {
+			sections, err := loadGoPackages([]string{source.Path})
+			if err != nil {
+				return nil, err
+			}
+			for _, section := range sections {
+				if !files[section.Source.File] {
+					files[section.Source.File] = true
+					docs.Files = append(docs.Files, section.Source.File)
+				}
+			}
+			add(sections)
+			continue
+		}
+
+		names, err := sourceFiles(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s source %s: %w", source.Kind, source.Path, err)
+		}
+		for _, name := range names {
+			abs, err := filepath.Abs(name)
+			if err != nil {
+				return nil, err
+			}
+			if files[abs] {
+				continue
+			}
+			files[abs] = true
+			docs.Files = append(docs.Files, name)
+			sections, err := loadSourceFile(source.Kind, name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			add(sections)
+		}
+	}
+	return docs, nil
+}
+
+// sourceFiles expands the path of a Markdown, text or Q&A source. A
+// directory gives every file below it with the kind's extensions and a
+// glob pattern its matches, in lexical order. Environment variables are
+// expanded.
+func sourceFiles(source CorpusSource) ([]string, error) {
+	path := os.ExpandEnv(source.Path)
+	matches := []string{path}
+	if _, err := os.Stat(path); err != nil {
+		if matches, err = filepath.Glob(path); err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, errors.New("no such file, directory or matching files")
+		}
+	}
+
+	var names []string
+	for _, match := range matches {
+		err := filepath.WalkDir(match, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if name != match && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if name == match || slices.Contains(sourceExtensions[source.Kind], strings.ToLower(filepath.Ext(name))) {
+				names = append(names, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// loadSourceFile splits one file of the given kind into documents.
+func loadSourceFile(kind, filename string) ([]Section, error) {
+	switch kind {
+	case sourceMarkdown:
+		return loadCorpusSections(filename)
+	case sourceText:
+		return loadTextDocument(filename)
+	case sourceQA:
+		return loadQAPairs(filename)
+	}
+	return nil, fmt.Errorf("unknown corpus source kind %q", kind)
+}
+
+// loadTextDocument reads a plain text file as a single document titled
+// with the file's name.
+func loadTextDocument(filename string) ([]Section, error) {
+	lines, err := LoadCorpus(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(strings.Join(lines, "")) == "" {
+		return nil, nil
+	}
+	title := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	title = strings.NewReplacer("_", " ", "-", " ").Replace(title)
+	return []Section{{
+		Level:  1,
+		Title:  title,
+		Body:   lines,
+		Source: DocSource{Kind: docText, File: filename, Line: 1},
+	}}, nil
+}
+
+// qaPair is a line of a Q&A source.
+type qaPair struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// loadQAPairs reads a JSONL file of question and answer pairs, one
+// document per pair titled with the question.
+func loadQAPairs(filename string) ([]Section, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sections []Section
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20) // Answers can be long
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var pair qaPair
+		if err := json.Unmarshal(scanner.Bytes(), &pair); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if strings.TrimSpace(pair.Question) == "" || strings.TrimSpace(pair.Answer) == "" {
+			return nil, fmt.Errorf("line %d: question and answer are required", lineNo)
+		}
+		sections = append(sections, Section{
+			Level:  2,
+			Title:  strings.TrimSpace(pair.Question),
+			Body:   strings.Split(strings.TrimSpace(pair.Answer), "\n"),
+			Source: DocSource{Kind: docQA, File: filename, Line: lineNo},
+		})
+	}
+	return sections, scanner.Err()
+}
+
+// documentKey identifies a document by its title and text, ignoring case
+// and spacing.
+func documentKey(section Section) string {
+	text := section.Title + "\n" + strings.Join(section.Body, " ")
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// lines is the text a document adds to the corpus lines the TF-IDF model,
+// keywords and intents are built from. Go documentation only feeds section
+// retrieval.
+func (s Section) lines() []string {
+	switch s.Source.Kind {
+	case docMarkdown:
+		return append([]string{strings.Repeat("#", s.Level) + " " + s.Title}, s.Body...)
+	case docText:
+		return s.Body
+	case docQA:
+		return append([]string{s.Title}, s.Body...)
+	}
+	return nil
+}
@@ -1,44 +1,67 @@
 package main
 
 import (
-	"database/sql"
-	"log"
-	"os"
-
-	_ "github.com/go-sql-driver/mysql"
+	"context"
+	"log/slog"
+	"time"
 )
 
-// Database connection variable.
-var db *sql.DB
+// Storage backend shared by all handlers.
+var store Store
 
-type Config struct {
-	DatabaseURL string `json:"database_url"`
-	Port        int    `json:"port"`
+// useOfflineStore points commands that only read the model files, such
+// as eval, at a throwaway in-memory store without a spill file, so they
+// neither replay nor remove the writes the server spilled.
+func useOfflineStore() {
+	config.Storage.Driver, config.Storage.URL = storeMemory, ""
+	config.Storage.WriteSpillFile = ""
 }
 
+// connectDatabase opens the configured store and starts the write-behind
+// pipeline. SQL stores are migrated to the latest schema unless
+// storage.auto_migrate is off.
 func connectDatabase() {
+	if config.Storage.Driver == storeMemory {
+		slog.Warn("No database is configured; using the in-memory store. Nothing will be persisted.")
+	}
 
-	ReadJsonConfigToEnvVars("../config/db.json")
-	dbUrl := os.Getenv("DB_URL")
 	var err error
-	db, err = sql.Open("mysql", dbUrl)
+	store, err = openStore(config.Storage.Driver, config.Storage.URL)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Error opening store", "driver", config.Storage.Driver, "err", err)
 	}
-}
 
-func saveInteraction(query, response string) {
-	_, err := db.Exec("INSERT INTO interactions(query, response) VALUES(?, ?)", query, response)
-	if err != nil {
-		log.Println("Error saving interaction:", err)
+	if s, ok := store.(*sqlStore); ok && config.Storage.AutoMigrate {
+		applied, err := s.MigrateUp(context.Background())
+		if err != nil {
+			fatal("Error migrating database", "err", err)
+		}
+		for _, m := range applied {
+			slog.Info("Applied migration", "version", m.Version, "name", m.Name)
+		}
 	}
-}
 
-// logInteraction logs user interactions including their queries, responses, and ratings into the database.
-func logInteraction(query, response string, rating int) {
-	// Store user query, response, and rating in the interaction_logs table
-	_, err := db.Exec("INSERT INTO interaction_logs(query, response, feedback_rating) VALUES(?, ?, ?)", query, response, rating)
-	if err != nil {
-		log.Println("Error logging interaction:", err) // Log any error encountered while logging interaction
+	// Interactions and feedback are written behind the request path
+	spillFile := config.Storage.WriteSpillFile
+	if spillFile != "" {
+		n, quarantined, err := replaySpill(context.Background(), store, spillFile, config.Storage.WriteQuarantineFile)
+		if err != nil {
+			dbErrors.WithLabelValues("replay_spill").Inc()
+			slog.Error("Error replaying spill file", "file", spillFile, "err", err)
+		}
+		if quarantined > 0 {
+			slog.Error("Store rejected spilled writes; quarantined them", "count", quarantined, "file", config.Storage.WriteQuarantineFile)
+		}
+		if n > 0 {
+			slog.Info("Replayed spilled writes", "count", n, "file", spillFile)
+		}
 	}
+	writeQueue = newWriteBehind(store, writeBehindConfig{
+		QueueSize:      config.Storage.WriteQueueSize,
+		BatchSize:      config.Storage.WriteBatchSize,
+		FlushInterval:  time.Duration(config.Storage.WriteFlushInterval),
+		SpillFile:      spillFile,
+		QuarantineFile: config.Storage.WriteQuarantineFile,
+		RetryDelay:     defaultWriteRetryDelay,
+	})
 }
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// How a definition was mined, most trusted first. A term defined in more
+// than one way keeps the most trusted definition, and among equals the
+// first found.
+const (
+	definedByKeyword   = "keyword"   // The description of a knowledge base entity
+	definedByParagraph = "paragraph" // The first prose paragraph of a section, defining its title
+	definedByList      = "list"      // A list item such as "- **Static Typing**: ..."
+	definedBySentence  = "sentence"  // A sentence such as "A package is a way to ..."
+)
+
+var definitionRank = map[string]int{definedByKeyword: 0, definedByParagraph: 1, definedByList: 2, definedBySentence: 3}
+
+// TermDefinition is what a programming term means, and where that was found.
+type TermDefinition struct {
+	Term       string    `json:"term"` // As written where it was defined
+	Definition string    `json:"definition"`
+	DefinedBy  string    `json:"defined_by"` // keyword, paragraph, list or sentence
+	Source     DocSource `json:"source"`     // The document, or keyword file entry, it was mined from
+}
+
+var (
+	// "- **Static Typing**: Strong and explicit ..." or "- **`fmt`**: For ..."
+	definitionListItem = regexp.MustCompile(`^[-*+]\s+\*\*([^*]+?)(?::\*\*|\*\*\s*(?::|—|–|\s-\s))\s*(.+)$`)
+	// "A package is a way to ...", "Channels are used to ...", "`defer` means ..."
+	definingSentence = regexp.MustCompile("^(?:(?:A|An|The)\\s+)?(`?[A-Za-z][\\w./-]*`?(?:\\s+[A-Za-z][\\w./-]*){0,2})\\s+(is|are|refers to|means|can|provides|lets|allows|stores|holds)\\s+(.+)$")
+	// Lines that are not prose: list items, quotes, tables, headings and numbered steps
+	notProse = regexp.MustCompile(`^(?:[-*+>|#]|\d+[.)]\s)`)
+)
+
+// Words that open what a term is or does, as in "A package is a way to ..."
+// or "Channels are used to ...". Sentences such as "Developers are
+// encouraged to ..." define nothing.
+var definitionOpenings = map[string]struct{}{
+	"a": {}, "an": {}, "the": {}, "one": {}, "used": {}, "executed": {}, "called": {},
+	"run": {}, "managed": {}, "defined": {}, "created": {}, "written": {},
+}
+
+// Words that start sentences such as "It is ..." without naming a term.
+var definitionNonSubjects = map[string]struct{}{
+	"it": {}, "this": {}, "that": {}, "these": {}, "those": {}, "they": {}, "there": {},
+	"here": {}, "which": {}, "what": {}, "who": {}, "you": {}, "we": {}, "each": {},
+	"every": {}, "all": {}, "some": {}, "one": {}, "its": {}, "their": {}, "your": {},
+}
+
+// mineDefinitions defines programming terms from the keyword knowledge
+// base and the corpus, keyed by termKey. Terms nothing defines are left
+// out rather than given a placeholder.
+func mineDefinitions(keywords map[string]KeywordEntity, keywordsFile string, sections []Section) map[string]TermDefinition {
+	terms := make(map[string]TermDefinition)
+	// defineAs defines term under the name key, such as an alias of it
+	defineAs := func(key, term, definition, by string, source DocSource) {
+		term = strings.TrimSpace(strings.ReplaceAll(term, "`", ""))
+		definition = strings.TrimSpace(definition)
+		key = termKey(key)
+		if key == "" || definition == "" {
+			return
+		}
+		if existing, ok := terms[key]; ok && definitionRank[existing.DefinedBy] <= definitionRank[by] {
+			return
+		}
+		terms[key] = TermDefinition{Term: term, Definition: definition, DefinedBy: by, Source: source}
+	}
+	define := func(term, definition, by string, source DocSource) {
+		defineAs(term, term, definition, by, source)
+	}
+
+	for name, entity := range keywords {
+		source := DocSource{Kind: docKeyword, Name: name, File: keywordsFile, Line: entity.line}
+		define(name, entity.Description, definedByKeyword, source)
+		for _, alias := range entity.Aliases {
+			defineAs(alias, name, entity.Description, definedByKeyword, source)
+		}
+	}
+
+	for _, section := range sections {
+		switch section.Source.Kind {
+		case docPackage, docType, docFunc, docMethod:
+			// Go documentation defines the declaration it documents, and
+			// a package by its import path
+			term := section.Title
+			if section.Source.Kind == docPackage {
+				term = section.Source.Package
+			}
+			// An undocumented declaration opens with its code, not prose
+			documented := len(section.Body) > 0 && !strings.HasPrefix(section.Body[0], "`")
+			if text, i := firstParagraph(section.Body); documented && i >= 0 {
+				define(term, text, definedByParagraph, section.Source)
+			}
+			continue
+		case docMarkdown:
+			if text, i := firstParagraph(section.Body); i >= 0 {
+				define(section.Title, text, definedByParagraph, section.lineSource(i))
+			}
+		}
+
+		inFence := false
+		for i, line := range section.Body {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "```") {
+				inFence = !inFence
+				continue
+			}
+			if inFence || trimmed == "" {
+				continue
+			}
+			if m := definitionListItem.FindStringSubmatch(trimmed); m != nil {
+				define(m[1], plainText(m[2]), definedByList, section.lineSource(i))
+				continue
+			}
+			prose := strings.TrimSpace(strings.TrimLeft(trimmed, "-*+ "))
+			for _, sentence := range splitSentences(plainText(prose)) {
+				if term, ok := definedTerm(sentence, section.Title); ok {
+					// "... can store a limited number of messages:" introduces an example
+					if base, ok := strings.CutSuffix(sentence, ":"); ok {
+						sentence = base + "."
+					}
+					define(term, sentence, definedBySentence, section.lineSource(i))
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// termKey is how terms are looked up: lowercase, without backticks, with
+// single spaces.
+func termKey(term string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(term, "`", ""))), " ")
+}
+
+// findTerm looks up the definition of a term, trying the singular or
+// plural when the term itself is not defined. The caller holds modelMu.
+func findTerm(term string) (TermDefinition, bool) {
+	key := termKey(term)
+	if key == "" {
+		return TermDefinition{}, false
+	}
+	for _, candidate := range termForms(key) {
+		if definition, ok := programmingTerms[candidate]; ok {
+			return definition, true
+		}
+	}
+	return TermDefinition{}, false
+}
+
+// termForms returns key, then its singular if it looks plural, then its
+// plural. Words such as "class", "status" and "analysis" end in s without
+// being plurals, and "classes" and "matches" drop "es" rather than "s".
+func termForms(key string) []string {
+	forms := []string{key}
+	if base, ok := strings.CutSuffix(key, "es"); ok && hasSibilantEnding(base) {
+		forms = append(forms, base)
+	}
+	if base, ok := strings.CutSuffix(key, "s"); ok && !strings.HasSuffix(base, "s") && !strings.HasSuffix(base, "u") && !strings.HasSuffix(base, "i") {
+		forms = append(forms, base)
+	}
+	if hasSibilantEnding(key) {
+		return append(forms, key+"es")
+	}
+	return append(forms, key+"s")
+}
+
+// hasSibilantEnding reports whether word ends in a sound that takes "es"
+// in the plural, as class, bus, box, match and push do.
+func hasSibilantEnding(word string) bool {
+	for _, suffix := range []string{"s", "x", "z", "ch", "sh"} {
+		if strings.HasSuffix(word, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstParagraph returns the first paragraph of prose in a section body
+// and the index of its first line, or -1 if there is none. Lines of fewer
+// than four words, such as a lone command, are not prose. A closing
+// sentence that only introduces a list, such as "Key features include:",
+// is dropped.
+func firstParagraph(body []string) (string, int) {
+	var lines []string
+	start, inFence := -1, false
+	for i, line := range body {
+		if start < 0 && len(strings.Fields(line)) < 4 && !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+		}
+		prose := !inFence && trimmed != "" && !strings.HasPrefix(trimmed, "```") &&
+			!strings.HasPrefix(line, "    ") && !strings.HasPrefix(line, "\t") && !notProse.MatchString(trimmed)
+		if prose {
+			if start < 0 {
+				start = i
+			}
+			lines = append(lines, trimmed)
+			continue
+		}
+		if start >= 0 {
+			break
+		}
+	}
+	if start < 0 {
+		return "", -1
+	}
+
+	sentences := splitSentences(plainText(strings.Join(lines, " ")))
+	for len(sentences) > 0 && strings.HasSuffix(sentences[len(sentences)-1], ":") {
+		sentences = sentences[:len(sentences)-1]
+	}
+	if len(sentences) == 0 {
+		return "", -1
+	}
+	return strings.Join(sentences, " "), start
+}
+
+// definedTerm returns the term a sentence such as "Channels are used to
+// communicate between goroutines." defines. Other sentences, such as
+// "Buffered channels can store ...", only define the title of the section
+// they are in. Questions, negations, short sentences and sentences about
+// "it" or "this" define nothing.
+func definedTerm(sentence, title string) (string, bool) {
+	if strings.HasSuffix(sentence, "?") || len(strings.Fields(sentence)) < 5 {
+		return "", false
+	}
+	m := definingSentence.FindStringSubmatch(sentence)
+	if m == nil || strings.HasPrefix(m[3], "not ") {
+		return "", false
+	}
+	subject, title := termKey(m[1]), termKey(title)
+	if subject != title && subject+"s" != title && subject != title+"s" {
+		opening, _, _ := strings.Cut(strings.ToLower(m[3]), " ")
+		_, defines := definitionOpenings[opening]
+		switch m[2] {
+		case "is", "are", "refers to", "means":
+		default:
+			defines = false
+		}
+		if !defines {
+			return "", false
+		}
+	}
+	for _, word := range strings.Fields(strings.ToLower(strings.Trim(m[1], "`"))) {
+		if _, ok := definitionNonSubjects[word]; ok {
+			return "", false
+		}
+		if _, ok := stopWords[word]; ok {
+			return "", false
+		}
+	}
+	return m[1], true
+}
+
+// splitSentences splits prose at full stops, question marks and
+// exclamation marks followed by a space, so that selectors such as
+// fmt.Println stay whole.
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if strings.IndexByte(".!?", text[i]) >= 0 && (i+1 == len(text) || text[i+1] == ' ') {
+			if s := strings.TrimSpace(text[start : i+1]); s != "" {
+				sentences = append(sentences, s)
+			}
+			start = i + 1
+		}
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// plainText drops Markdown emphasis, keeping code spans.
+func plainText(text string) string {
+	return strings.NewReplacer("**", "", "__", "").Replace(text)
+}
+
+// lineSource is the source of the i-th body line of a section: the line
+// itself in Markdown and text files, the question or declaration
+// otherwise.
+func (s Section) lineSource(i int) DocSource {
+	source := s.Source
+	switch source.Kind {
+	case docMarkdown:
+		source.Line += 1 + i
+	case docText:
+		source.Line += i
+	}
+	return source
+}
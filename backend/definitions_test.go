@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefinedTerm(t *testing.T) {
+	tests := []struct {
+		sentence, title string
+		want            string // Empty when the sentence defines nothing
+	}{
+		{"Channels are used to communicate between goroutines.", "Concurrency", "Channels"},
+		{"A package is a way to group related Go files.", "Packages", "package"},
+		{"`defer` means the call runs when the function returns.", "Control Flow", "`defer`"},
+		{"Buffered channels can store a limited number of messages:", "Buffered Channels", "Buffered channels"},
+		{"Buffered channels can store a limited number of messages.", "Slices", ""}, // "can" defines only the title
+		{"Developers are encouraged to write small functions.", "Style", ""},
+		{"It is a way to run code concurrently.", "Goroutines", ""},
+		{"This is the most common way to write loops.", "Loops", ""},
+		{"Is a channel a pointer to a queue?", "Channels", ""},
+		{"A slice is not an array of values.", "Slices", ""},
+		{"Maps are fast.", "Maps", ""}, // Too short
+	}
+	for _, tt := range tests {
+		got, ok := definedTerm(tt.sentence, tt.title)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("definedTerm(%q, %q) = %q, %v; want %q", tt.sentence, tt.title, got, ok, tt.want)
+		}
+	}
+}
+
+func TestFirstParagraph(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      []string
+		want      string
+		wantIndex int
+	}{
+		{"prose", []string{"", "Goroutines are lightweight threads managed by the runtime.", "They start with the go keyword.", "", "More text here after a blank line."},
+			"Goroutines are lightweight threads managed by the runtime. They start with the go keyword.", 1},
+		{"short lines first", []string{"go run .", "Modules record the dependencies of a program."},
+			"Modules record the dependencies of a program.", 1},
+		{"list introduction dropped", []string{"Go is a compiled language with garbage collection. Key features include:", "- **Static Typing**: types are checked"},
+			"Go is a compiled language with garbage collection.", 0},
+		{"only an introduction", []string{"The main features of the language include:", "- fast builds"}, "", -1},
+		{"code fence skipped", []string{"```go", "for i := 0; i < 10; i++ {", "```", "A for loop repeats its body until the condition fails."},
+			"A for loop repeats its body until the condition fails.", 3},
+		{"indented code", []string{"    x := make(chan int, 10) // a channel", "Make allocates and initializes maps, slices and channels."},
+			"Make allocates and initializes maps, slices and channels.", 1},
+		{"list only", []string{"- one item of a list", "1. a numbered step here", "> a quote of several words"}, "", -1},
+		{"emphasis dropped", []string{"An **interface** is a set of method signatures."}, "An interface is a set of method signatures.", 0},
+	}
+	for _, tt := range tests {
+		got, i := firstParagraph(tt.body)
+		if got != tt.want || i != tt.wantIndex {
+			t.Errorf("%s: firstParagraph = %q, %d; want %q, %d", tt.name, got, i, tt.want, tt.wantIndex)
+		}
+	}
+}
+
+func TestMineDefinitions(t *testing.T) {
+	keywords := map[string]KeywordEntity{
+		"chan": {Name: "chan", Aliases: []string{"channel"}, Description: "Declares a channel type.", line: 3},
+	}
+	sections := []Section{{
+		Title:  "Language Features",
+		Source: DocSource{Kind: docMarkdown, File: "corpus.md", Line: 10},
+		Body: []string{
+			"Go was designed at Google for large code bases. Key features include:",
+			"- **Static Typing**: Types are checked at compile time.",
+			"- **`fmt`** - Formatted input and output.",
+			"- **Channel**: Overridden by the keyword.",
+			"Slices are used to hold sequences of values.",
+			"```",
+			"A map is a hash table, in code.",
+			"```",
+		},
+	}}
+	terms := mineDefinitions(keywords, "keywords.yaml", sections)
+
+	want := map[string]TermDefinition{
+		"chan":    {Term: "chan", Definition: "Declares a channel type.", DefinedBy: definedByKeyword, Source: DocSource{Kind: docKeyword, Name: "chan", File: "keywords.yaml", Line: 3}},
+		"channel": {Term: "chan", Definition: "Declares a channel type.", DefinedBy: definedByKeyword, Source: DocSource{Kind: docKeyword, Name: "chan", File: "keywords.yaml", Line: 3}},
+		"language features": {Term: "Language Features", Definition: "Go was designed at Google for large code bases.", DefinedBy: definedByParagraph,
+			Source: DocSource{Kind: docMarkdown, File: "corpus.md", Line: 11}},
+		"static typing": {Term: "Static Typing", Definition: "Types are checked at compile time.", DefinedBy: definedByList,
+			Source: DocSource{Kind: docMarkdown, File: "corpus.md", Line: 12}},
+		"fmt": {Term: "fmt", Definition: "Formatted input and output.", DefinedBy: definedByList,
+			Source: DocSource{Kind: docMarkdown, File: "corpus.md", Line: 13}},
+		"slices": {Term: "Slices", Definition: "Slices are used to hold sequences of values.", DefinedBy: definedBySentence,
+			Source: DocSource{Kind: docMarkdown, File: "corpus.md", Line: 15}},
+	}
+	if !reflect.DeepEqual(terms, want) {
+		t.Errorf("mineDefinitions =\n%+v\nwant\n%+v", terms, want)
+	}
+}
+
+func TestFindTerm(t *testing.T) {
+	saved := programmingTerms
+	defer func() { programmingTerms = saved }()
+	programmingTerms = make(map[string]TermDefinition)
+	for _, term := range []string{"class", "goroutine", "interfaces", "match", "status", "analysis", "process", "box"} {
+		programmingTerms[term] = TermDefinition{Term: term}
+	}
+
+	tests := []struct {
+		query, want string // want is empty when nothing should be found
+	}{
+		{"class", "class"},
+		{"Classes", "class"},
+		{"goroutines", "goroutine"},
+		{"`Goroutine`", "goroutine"},
+		{"interface", "interfaces"},
+		{"matches", "match"},
+		{"statuses", "status"},
+		{"status", "status"},
+		{"analysis", "analysis"},
+		{"processes", "process"},
+		{"boxes", "box"},
+		{"clas", ""}, // A word ending in s takes es in the plural
+		{"goroutiness", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, ok := findTerm(tt.query)
+		if got.Term != tt.want || ok != (tt.want != "") {
+			t.Errorf("findTerm(%q) = %q, %v; want %q", tt.query, got.Term, ok, tt.want)
+		}
+	}
+
+	// "class" must not be looked up as "clas"
+	programmingTerms["clas"] = TermDefinition{Term: "clas"}
+	delete(programmingTerms, "class")
+	if got, ok := findTerm("class"); ok {
+		t.Errorf("findTerm(class) = %q, want nothing", got.Term)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+)
+
+// Flow is a guided conversation defined declaratively as a set of named
+// states. The flow starts in Start and moves between states according to
+// the transitions of the current state. A state without transitions is
+// final: its prompt is sent and the flow ends.
+type Flow struct {
+	Name     string
+	Triggers []string // Phrases that start the flow when a query begins with them
+	Start    string   // Name of the initial state
+	States   map[string]FlowState
+}
+
+// FlowState is a single step of a flow.
+type FlowState struct {
+	// Prompt is sent when the state is entered. Placeholders of the form
+	// {name} are replaced with the flow variables.
+	Prompt string
+	// Action, if set, handles the user's reply before transitions are
+	// evaluated. It typically stores what it found in the flow variables.
+	Action func(ctx *FlowContext, input string)
+	// Transitions are evaluated in order; the first match wins.
+	Transitions []Transition
+	// Retry is sent when no transition matches. It defaults to the prompt.
+	Retry string
+}
+
+// Transition moves the flow to another state when its condition holds.
+type Transition struct {
+	When func(ctx *FlowContext, input string) bool // nil always matches
+	To   string
+}
+
+// FlowContext is the state of a running flow.
+type FlowContext struct {
+	Session *Session
+	Vars    map[string]string
+}
+
+// Dialogue tracks the flow a session is currently in.
+type Dialogue struct {
+	flow    *Flow
+	state   string
+	context *FlowContext
+}
+
+// Words that abandon any running flow.
+var cancelWords = map[string]struct{}{
+	"cancel": {}, "stop": {}, "quit": {}, "exit": {}, "never mind": {}, "nevermind": {},
+}
+
+// flows lists the guided flows the bot can run.
+var flows = []*Flow{debugErrorFlow, learnTopicFlow}
+
+// onWords matches when the normalised input is one of the given words.
+func onWords(words ...string) func(*FlowContext, string) bool {
+	return func(_ *FlowContext, input string) bool {
+		normalized := normalizeInput(input)
+		for _, word := range words {
+			if normalized == word {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// whenVar matches when the flow variable is set to a non-empty value.
+func whenVar(name string) func(*FlowContext, string) bool {
+	return func(ctx *FlowContext, _ string) bool {
+		return ctx.Vars[name] != ""
+	}
+}
+
+// normalizeInput lowercases the input and strips punctuation.
+func normalizeInput(input string) string {
+	return strings.TrimSpace(punctuation.ReplaceAllString(strings.ToLower(input), ""))
+}
+
+// handleDialogue routes a query through the session's active flow, or
+// starts a new flow when the query matches a trigger. It reports whether the
+// query was consumed by the dialogue manager.
+func handleDialogue(session *Session, query string) (string, bool) {
+	if session.dialogue == nil {
+		return startFlow(session, query)
+	}
+
+	d := session.dialogue
+	if _, ok := cancelWords[normalizeInput(query)]; ok {
+		session.dialogue = nil
+		return "Okay, leaving the " + d.flow.Name + " flow.", true
+	}
+
+	state := d.flow.States[d.state]
+	if state.Action != nil {
+		state.Action(d.context, query)
+	}
+
+	for _, t := range state.Transitions {
+		if t.When == nil || t.When(d.context, query) {
+			return d.enter(session, t.To), true
+		}
+	}
+
+	retry := state.Retry
+	if retry == "" {
+		retry = state.Prompt
+	}
+	return d.expand(retry), true
+}
+
+// startFlow starts the first flow whose trigger prefixes the query. Any text
+// after the trigger is handed to the initial state as the first reply.
+func startFlow(session *Session, query string) (string, bool) {
+	normalized := normalizeInput(query)
+	for _, flow := range flows {
+		for _, trigger := range flow.Triggers {
+			if normalized != trigger && !strings.HasPrefix(normalized, trigger+" ") {
+				continue
+			}
+
+			d := &Dialogue{flow: flow, context: &FlowContext{Session: session, Vars: map[string]string{}}}
+			session.dialogue = d
+			prompt := d.enter(session, flow.Start)
+
+			if rest := strings.TrimSpace(strings.TrimPrefix(normalized, trigger)); rest != "" {
+				return handleDialogue(session, rest)
+			}
+			return prompt, true
+		}
+	}
+	return "", false
+}
+
+// enter moves the dialogue into the named state and returns its prompt,
+// ending the flow if the state is final.
+func (d *Dialogue) enter(session *Session, name string) string {
+	d.state = name
+	state := d.flow.States[name]
+	if len(state.Transitions) == 0 {
+		session.dialogue = nil
+	}
+	return d.expand(state.Prompt)
+}
+
+// expand replaces {name} placeholders with flow variables.
+func (d *Dialogue) expand(text string) string {
+	pairs := make([]string, 0, 2*len(d.context.Vars))
+	for name, value := range d.context.Vars {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
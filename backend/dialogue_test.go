@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// loadTestModel installs the model built from the corpus and keywords
+// shipped with the bot, with the default configuration.
+func loadTestModel(t *testing.T) {
+	t.Helper()
+	config = defaultConfig()
+	model, err := loadModel(ModelConfig{CorpusFile: "go_corpus.md", KeywordsFile: "Go_Keyword_Entities.yaml"}, nil)
+	if err != nil {
+		t.Fatalf("loading model: %v", err)
+	}
+	model.install()
+}
+
+// dialogueStep is a query of a scripted conversation and what the reply
+// must contain.
+type dialogueStep struct {
+	say    string
+	want   []string // Substrings of the reply
+	active bool     // A flow is still running after the reply
+}
+
+func TestHandleDialogue(t *testing.T) {
+	loadTestModel(t)
+
+	tests := []struct {
+		name   string
+		script []dialogueStep
+	}{
+		{"debug error, not helped", []dialogueStep{
+			{"debug my error", []string{"Paste the error message"}, true},
+			{"./main.go:5:2: declared and not used: x", []string{"That looks like an unused variable.", "Did that help?"}, true},
+			{"maybe", []string{"Did that help? Please answer yes or no."}, true},
+			{"No.", []string{"go vet"}, false},
+		}},
+		{"debug error, helped", []dialogueStep{
+			{"I got an error", []string{"Paste the error message"}, true},
+			{"panic: runtime error: index out of range [3] with length 3", []string{"an out of range index", "len(s)"}, true},
+			{"thanks", []string{"Glad that helped!"}, false},
+		}},
+		{"debug error in one message", []dialogueStep{
+			{"help me debug assignment to entry in nil map", []string{"a nil map assignment", "make(map[K]V)"}, true},
+			{"yes", []string{"Glad that helped!"}, false},
+		}},
+		{"debug unknown error", []dialogueStep{
+			{"fix my error", []string{"Paste the error message"}, true},
+			{"xyzzy plugh", []string{"I don't recognise that error. Try asking"}, false},
+		}},
+		{"learn a topic", []dialogueStep{
+			{"teach me", []string{"What would you like to learn about?"}, true},
+			{"xyzzy plugh", []string{"I couldn't find that topic."}, true},
+			{"concurrency", []string{"Concurrency\n", "(1 of 5)"}, true},
+			{"next", []string{"Go-routines\n", "(2 of 5)"}, true},
+			{"hmm", []string{`Say "next" to continue with Concurrency`}, true},
+			{"continue", []string{"Channels\n", "(3 of 5)"}, true},
+			{"ok", []string{"Buffered Channels\n", "(4 of 5)"}, true},
+			{"go on", []string{"Select Statement\n", "(5 of 5)"}, true},
+			{"next", []string{"That's the end of Concurrency. Nice work!"}, false},
+		}},
+		{"learn a topic in one message", []dialogueStep{
+			{"I want to learn testing in Go", []string{"Testing in Go\n", "(1 of 5)"}, true},
+		}},
+		{"cancel debugging", []dialogueStep{
+			{"debug my error", []string{"Paste the error message"}, true},
+			{"never mind", []string{"Okay, leaving the debug my error flow."}, false},
+		}},
+		{"cancel a lesson", []dialogueStep{
+			{"teach me concurrency", []string{"(1 of 5)"}, true},
+			{"Stop!", []string{"Okay, leaving the learn a topic flow."}, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newSession()
+			for i, step := range tt.script {
+				reply, ok := handleDialogue(session, step.say)
+				if !ok {
+					t.Fatalf("step %d %q: not handled by the dialogue", i, step.say)
+				}
+				for _, want := range step.want {
+					if !strings.Contains(reply, want) {
+						t.Errorf("step %d %q: reply %q does not contain %q", i, step.say, reply, want)
+					}
+				}
+				if active := session.dialogue != nil; active != step.active {
+					t.Fatalf("step %d %q: flow active = %v, want %v", i, step.say, active, step.active)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleDialogueIgnoresOtherQueries(t *testing.T) {
+	loadTestModel(t)
+	for _, query := range []string{
+		"what is a channel", "debugging tips", "learned helplessness", "cancel",
+		"debug", "debug printing in go", "learn", "learn generics",
+	} {
+		session := newSession()
+		if reply, ok := handleDialogue(session, query); ok || session.dialogue != nil {
+			t.Errorf("handleDialogue(%q) = %q, %v; want it left to the pipeline", query, reply, ok)
+		}
+	}
+}
+
+func TestFollowUps(t *testing.T) {
+	loadTestModel(t)
+	concurrency, ok := sectionByTitle("Concurrency")
+	if !ok {
+		t.Fatal("the corpus has no Concurrency section")
+	}
+
+	session := newSession()
+	if got := session.Present(concurrency.Index); !strings.HasPrefix(got, "Concurrency\n") {
+		t.Fatalf("Present = %q, want the Concurrency section", got)
+	}
+	script := []dialogueStep{
+		{"tell me more", []string{`That's everything I have on Concurrency. Say "next" to continue with Go-routines.`}, false},
+		{"next", []string{"Go-routines\n", "go myFunction()"}, false},
+		{"show me an example", []string{"Example for Go-routines:", "go myFunction()"}, false},
+		{"example of buffered channels", []string{"Example for Buffered Channels:", "make(chan int, 2)"}, false},
+		{"more", []string{"Buffered Channels\n", "Buffered channels can store"}, false},
+		{"more", []string{"That's everything I have on Buffered Channels."}, false},
+		{"what's next?", []string{"Select Statement\n"}, false},
+	}
+	for i, step := range script {
+		result := Answer(context.Background(), Query{Text: step.say, Session: session})
+		for _, want := range step.want {
+			if !strings.Contains(result.Answer, want) {
+				t.Errorf("step %d %q: answer %q does not contain %q", i, step.say, result.Answer, want)
+			}
+		}
+	}
+	if session.LastTopic != "Select Statement" {
+		t.Errorf("LastTopic = %q, want Select Statement", session.LastTopic)
+	}
+}
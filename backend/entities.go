@@ -0,0 +1,400 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of linked entity.
+const (
+	entityKeyword = "keyword" // An entity of the keyword knowledge base
+	entitySection = "section" // A corpus section, such as "Buffered Channels"
+)
+
+// maxEntityTokens bounds the words in a section title used as an entity
+// name; longer titles are sentences rather than names.
+const maxEntityTokens = 6
+
+// EntityLink is a mention of a known entity in a query.
+type EntityLink struct {
+	ID         string  `json:"id"`   // Canonical id, such as keyword:goroutine, section:buffered-channels or section:strings.Builder
+	Kind       string  `json:"kind"` // keyword or section
+	Name       string  `json:"name"` // Canonical name
+	Text       string  `json:"text"` // The words of the query that mention it
+	Start      int     `json:"start"`
+	End        int     `json:"end"`        // Byte offsets of Text in the query
+	Confidence float64 `json:"confidence"` // 1 for the canonical name, less for aliases and misspellings
+}
+
+// Words of entity names and queries: identifiers, optionally joined into
+// import paths, selectors and hyphenated words such as net/http,
+// strings.Builder and go-routines.
+var entityTokenPattern = regexp.MustCompile(`[\p{L}\p{N}_]+(?:[./-][\p{L}\p{N}_]+)*`)
+
+// entityToken is a word of a query, lowercased, with its byte offsets.
+type entityToken struct {
+	text       string
+	start, end int
+}
+
+func entityTokens(text string) []entityToken {
+	var tokens []entityToken
+	for _, loc := range entityTokenPattern.FindAllStringIndex(text, -1) {
+		tokens = append(tokens, entityToken{text: strings.ToLower(text[loc[0]:loc[1]]), start: loc[0], end: loc[1]})
+	}
+	return tokens
+}
+
+// linkedEntity is an entry of the dictionary.
+type linkedEntity struct {
+	id, kind, name string
+}
+
+// entitySurface is a way of writing an entity: its canonical name or an
+// alias, as lowercase words.
+type entitySurface struct {
+	text   string // Words joined by spaces
+	words  int
+	entity int
+	alias  bool
+}
+
+// trieNode is a state of the Aho-Corasick automaton over words.
+type trieNode struct {
+	next map[string]*trieNode
+	fail *trieNode
+	out  []int // Surfaces ending here, including through fail links
+}
+
+// entityLinker finds the entities a query mentions. Names and aliases are
+// matched exactly, on word boundaries and ignoring case, by an
+// Aho-Corasick automaton over words, so multi-word names are found in one
+// pass. Words it leaves unmatched are compared with the names by
+// Damerau-Levenshtein distance to catch typos.
+type entityLinker struct {
+	entities []linkedEntity
+	surfaces []entitySurface
+	root     *trieNode
+	byLength map[int][]int // Surfaces by length in runes, for fuzzy matching
+}
+
+// newEntityLinker builds the dictionary from the keyword knowledge base
+// and the titles of the Markdown sections and Go documentation. Keywords
+// come first, so a section titled like a keyword does not take its name;
+// Go packages are also known by their import paths.
+func newEntityLinker(keywords map[string]KeywordEntity, sections []Section) *entityLinker {
+	l := &entityLinker{root: &trieNode{}, byLength: make(map[int][]int)}
+	seen := make(map[string]bool)
+	add := func(entity int, name string, alias bool) {
+		var words []string
+		for _, token := range entityTokens(name) {
+			words = append(words, token.text)
+		}
+		text := strings.Join(words, " ")
+		if len(words) == 0 || len(words) > maxEntityTokens || seen[text] {
+			return
+		}
+		seen[text] = true
+		l.surfaces = append(l.surfaces, entitySurface{text: text, words: len(words), entity: entity, alias: alias})
+		l.insert(words, len(l.surfaces)-1)
+	}
+
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l.entities = append(l.entities, linkedEntity{id: entityKeyword + ":" + name, kind: entityKeyword, name: name})
+		add(len(l.entities)-1, name, false)
+		for _, alias := range keywords[name].Aliases {
+			add(len(l.entities)-1, alias, true)
+		}
+	}
+
+	for _, section := range sections {
+		switch section.Source.Kind {
+		case docMarkdown, docPackage, docType, docFunc, docMethod:
+		default:
+			continue // Text files and questions are titled with sentences
+		}
+		l.entities = append(l.entities, linkedEntity{id: sectionEntityID(section), kind: entitySection, name: section.Title})
+		add(len(l.entities)-1, section.Title, false)
+		if section.Source.Kind == docPackage {
+			add(len(l.entities)-1, section.Source.Package, true)
+		}
+	}
+
+	l.link()
+	for i, surface := range l.surfaces {
+		n := len([]rune(surface.text))
+		l.byLength[n] = append(l.byLength[n], i)
+	}
+	return l
+}
+
+// sectionEntityID identifies Go documentation by import path and declared
+// name, which titles such as "Package template" leave ambiguous, and other
+// sections by their title, lowercased with words joined by hyphens.
+func sectionEntityID(section Section) string {
+	switch section.Source.Kind {
+	case docPackage:
+		return entitySection + ":" + section.Source.Package
+	case docType, docFunc, docMethod:
+		return entitySection + ":" + section.Source.Package + "." + section.Source.Name
+	}
+	var words []string
+	for _, token := range entityTokens(section.Title) {
+		words = append(words, token.text)
+	}
+	return entitySection + ":" + strings.Join(words, "-")
+}
+
+// insert adds the words of a surface to the trie.
+func (l *entityLinker) insert(words []string, surface int) {
+	node := l.root
+	for _, word := range words {
+		child, ok := node.next[word]
+		if !ok {
+			if node.next == nil {
+				node.next = make(map[string]*trieNode)
+			}
+			child = &trieNode{}
+			node.next[word] = child
+		}
+		node = child
+	}
+	node.out = append(node.out, surface)
+}
+
+// link sets the fail links of the trie breadth first, turning it into an
+// Aho-Corasick automaton.
+func (l *entityLinker) link() {
+	queue := []*trieNode{l.root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for word, child := range node.next {
+			fail := node.fail
+			for fail != nil && fail.next[word] == nil {
+				fail = fail.fail
+			}
+			if fail == nil {
+				child.fail = l.root
+			} else {
+				child.fail = fail.next[word]
+			}
+			child.out = append(child.out[:len(child.out):len(child.out)], child.fail.out...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+// entityCandidate is a possible link of the words first to last of a query.
+type entityCandidate struct {
+	first, last int
+	surface     int
+	confidence  float64
+}
+
+// Link returns the entities mentioned in text, in the order they appear.
+// Overlapping mentions are resolved in favour of the one with the most
+// words, then the most confident, so "buffered channels" links the section
+// rather than the channel keyword.
+func (l *entityLinker) Link(text string) []EntityLink {
+	links := []EntityLink{}
+	if l == nil {
+		return links
+	}
+	tokens := entityTokens(text)
+	candidates := l.exact(tokens)
+	candidates = append(candidates, l.fuzzy(tokens, candidates)...)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.last-a.first != b.last-b.first {
+			return a.last-a.first > b.last-b.first
+		}
+		if a.confidence != b.confidence {
+			return a.confidence > b.confidence
+		}
+		return a.first < b.first
+	})
+	taken := make([]bool, len(tokens))
+	linked := make(map[int]bool)
+	for _, c := range candidates {
+		free := true
+		for i := c.first; i <= c.last; i++ {
+			free = free && !taken[i]
+		}
+		entity := l.surfaces[c.surface].entity
+		if !free || linked[entity] {
+			continue
+		}
+		for i := c.first; i <= c.last; i++ {
+			taken[i] = true
+		}
+		linked[entity] = true
+		e := l.entities[entity]
+		start, end := tokens[c.first].start, tokens[c.last].end
+		links = append(links, EntityLink{
+			ID:         e.id,
+			Kind:       e.kind,
+			Name:       e.name,
+			Text:       text[start:end],
+			Start:      start,
+			End:        end,
+			Confidence: math.Round(c.confidence*1000) / 1000,
+		})
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Start < links[j].Start })
+	return links
+}
+
+// exact runs the automaton over the words of the query.
+func (l *entityLinker) exact(tokens []entityToken) []entityCandidate {
+	var candidates []entityCandidate
+	node := l.root
+	for i, token := range tokens {
+		for node != l.root && node.next[token.text] == nil {
+			node = node.fail
+		}
+		if next, ok := node.next[token.text]; ok {
+			node = next
+		}
+		for _, s := range node.out {
+			confidence := 1.0
+			if l.surfaces[s].alias {
+				confidence = 0.95
+			}
+			candidates = append(candidates, entityCandidate{first: i - l.surfaces[s].words + 1, last: i, surface: s, confidence: confidence})
+		}
+	}
+	return candidates
+}
+
+// fuzzy compares runs of up to three words that are not already an exact
+// match with every surface of about the same length. Runs shorter
+// than five letters, or starting or ending with a stop word, are skipped:
+// too many names are a typo away from them. A word that is a name's word
+// with letters added or removed in front, as "unbuffered" is to
+// "buffered", is another word rather than a typo.
+func (l *entityLinker) fuzzy(tokens []entityToken, exact []entityCandidate) []entityCandidate {
+	covered := make(map[[2]int]bool, len(exact))
+	for _, c := range exact {
+		covered[[2]int{c.first, c.last}] = true
+	}
+
+	var candidates []entityCandidate
+	for first := range tokens {
+		for last := first; last < len(tokens) && last < first+3; last++ {
+			if covered[[2]int{first, last}] || isStopWord(tokens[first].text) || isStopWord(tokens[last].text) {
+				continue
+			}
+			words := make([]string, 0, last-first+1)
+			for _, token := range tokens[first : last+1] {
+				words = append(words, token.text)
+			}
+			run := []rune(strings.Join(words, " "))
+			if len(run) < 5 {
+				continue
+			}
+			maxDistance := 1
+			if len(run) > 8 {
+				maxDistance = 2
+			}
+
+			best := entityCandidate{surface: -1}
+			for n := len(run) - maxDistance; n <= len(run)+maxDistance; n++ {
+				for _, s := range l.byLength[n] {
+					d := damerauLevenshtein(run, []rune(l.surfaces[s].text), maxDistance)
+					if d < 0 || changesPrefix(run, l.surfaces[s].text) {
+						continue
+					}
+					confidence := 0.9 * (1 - float64(d)/float64(max(len(run), n)))
+					if l.surfaces[s].alias {
+						confidence *= 0.95
+					}
+					if confidence > best.confidence {
+						best = entityCandidate{first: first, last: last, surface: s, confidence: confidence}
+					}
+				}
+			}
+			if best.surface >= 0 {
+				candidates = append(candidates, best)
+			}
+		}
+	}
+	return candidates
+}
+
+// changesPrefix reports whether run and name have the same words but for
+// one that is the other's with a prefix, such as "unbuffered channels" and
+// "buffered channels".
+func changesPrefix(run []rune, name string) bool {
+	runWords, nameWords := strings.Fields(string(run)), strings.Fields(name)
+	if len(runWords) != len(nameWords) {
+		return false
+	}
+	for i, word := range runWords {
+		if word != nameWords[i] && (strings.HasSuffix(word, nameWords[i]) || strings.HasSuffix(nameWords[i], word)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isStopWord(word string) bool {
+	_, ok := stopWords[word]
+	return ok
+}
+
+// damerauLevenshtein returns the optimal string alignment distance between
+// a and b: the insertions, deletions, substitutions and transpositions of
+// adjacent letters that turn one into the other. It gives up and returns
+// -1 once the distance is sure to exceed limit.
+func damerauLevenshtein(a, b []rune, limit int) int {
+	if len(a)-len(b) > limit || len(b)-len(a) > limit {
+		return -1
+	}
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return -1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	if prev[len(b)] > limit {
+		return -1
+	}
+	return prev[len(b)]
+}
+
+// linkedNames lists the names of linked entities.
+func linkedNames(links []EntityLink) []string {
+	names := make([]string, 0, len(links))
+	for _, link := range links {
+		names = append(names, link.Name)
+	}
+	return names
+}
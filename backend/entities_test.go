@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+// testLinker links the channel and goroutine keywords and a few sections.
+func testLinker() *entityLinker {
+	keywords := map[string]KeywordEntity{
+		"channel":   {Name: "channel", Aliases: []string{"chan"}},
+		"goroutine": {Name: "goroutine", Aliases: []string{"go routine"}},
+		"len":       {Name: "len", Aliases: []string{"len()"}},
+	}
+	sections := []Section{
+		{Title: "Buffered Channels", Source: DocSource{Kind: docMarkdown}},
+		{Title: "Channels", Source: DocSource{Kind: docMarkdown}},
+		{Title: "Package http", Source: DocSource{Kind: docPackage, Package: "net/http"}},
+		{Title: "Why do channels block?", Source: DocSource{Kind: docQA}},
+	}
+	return newEntityLinker(keywords, sections)
+}
+
+func TestEntityLinkerLink(t *testing.T) {
+	l := testLinker()
+	tests := []struct {
+		query string
+		want  []EntityLink // Only ID, Text, Start, End and Confidence are compared
+	}{
+		{"how do buffered channels work", []EntityLink{
+			{ID: "section:buffered-channels", Text: "buffered channels", Start: 7, End: 24, Confidence: 1},
+		}},
+		{"buffered channels or channels", []EntityLink{
+			{ID: "section:buffered-channels", Text: "buffered channels", Start: 0, End: 17, Confidence: 1},
+			{ID: "section:channels", Text: "channels", Start: 21, End: 29, Confidence: 1},
+		}},
+		{"what is a Channel?", []EntityLink{
+			{ID: "keyword:channel", Text: "Channel", Start: 10, End: 17, Confidence: 1},
+		}},
+		{"send on a chan", []EntityLink{
+			{ID: "keyword:channel", Text: "chan", Start: 10, End: 14, Confidence: 0.95},
+		}},
+		{"start a Go  Routine", []EntityLink{
+			{ID: "keyword:goroutine", Text: "Go  Routine", Start: 8, End: 19, Confidence: 0.95},
+		}},
+		{"import net/http", []EntityLink{
+			{ID: "section:net/http", Text: "net/http", Start: 7, End: 15, Confidence: 0.95},
+		}},
+		// Transposed letters are one edit away
+		{"start a gorotuine", []EntityLink{
+			{ID: "keyword:goroutine", Text: "gorotuine", Start: 8, End: 17, Confidence: 0.8},
+		}},
+		{"über goroutien", []EntityLink{
+			{ID: "keyword:goroutine", Text: "goroutien", Start: 6, End: 15, Confidence: 0.8},
+		}},
+		// A prefix makes another word, not a typo
+		{"unbuffered channels", []EntityLink{
+			{ID: "section:channels", Text: "channels", Start: 11, End: 19, Confidence: 1},
+		}},
+		// Questions are titled with sentences and are not entities
+		{"why do channels block", []EntityLink{
+			{ID: "section:channels", Text: "channels", Start: 7, End: 15, Confidence: 1},
+		}},
+		{"nothing known here", nil},
+	}
+	for _, tt := range tests {
+		got := l.Link(tt.query)
+		if len(got) != len(tt.want) {
+			t.Errorf("Link(%q) = %+v, want %+v", tt.query, got, tt.want)
+			continue
+		}
+		for i, link := range got {
+			want := tt.want[i]
+			if link.ID != want.ID || link.Text != want.Text || link.Start != want.Start || link.End != want.End || link.Confidence != want.Confidence {
+				t.Errorf("Link(%q)[%d] = %+v, want %+v", tt.query, i, link, want)
+			}
+			if tt.query[link.Start:link.End] != link.Text {
+				t.Errorf("Link(%q)[%d]: offsets %d:%d do not cover %q", tt.query, i, link.Start, link.End, link.Text)
+			}
+		}
+	}
+
+	var nilLinker *entityLinker
+	if links := nilLinker.Link("channels"); links == nil || len(links) != 0 {
+		t.Errorf("Link without a dictionary = %#v, want an empty list", links)
+	}
+}
+
+func TestDamerauLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"channel", "channel", 2, 0},
+		{"channel", "chanel", 2, 1},
+		{"channel", "chanmel", 2, 1},
+		{"goroutine", "gorotuine", 2, 1}, // A transposition is one edit
+		{"goroutine", "gortouine", 2, 2},
+		{"ab", "ba", 1, 1},
+		{"ca", "abc", 3, 3}, // Optimal string alignment edits no substring twice
+		{"", "abc", 3, 3},
+		{"channel", "chan", 2, -1},   // The lengths alone exceed the limit
+		{"channel", "kernel", 2, -1}, // Past the limit part way through
+		{"channel", "kernel", 4, 4},
+		{"über", "uber", 1, 1}, // Runes, not bytes
+	}
+	for _, tt := range tests {
+		if got := damerauLevenshtein([]rune(tt.a), []rune(tt.b), tt.limit); got != tt.want {
+			t.Errorf("damerauLevenshtein(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
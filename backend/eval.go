@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Label used in the confusion matrix for queries no intent matched.
+const noIntent = "(none)"
+
+// evalCase is one question of a golden set. Sections lists every section
+// that counts as a correct answer; Intent is optional.
+type evalCase struct {
+	Query    string   `json:"query" yaml:"query"`
+	Sections []string `json:"sections,omitempty" yaml:"sections"`
+	Intent   string   `json:"intent,omitempty" yaml:"intent"`
+}
+
+// evalOutcome is how the pipeline answered an evalCase.
+type evalOutcome struct {
+	evalCase
+	Ranked   []string      `json:"ranked"`            // Sections returned, most similar first
+	Rank     int           `json:"rank"`              // Position of the first expected section, 0 if absent
+	Recall   float64       `json:"recall"`            // Share of expected sections in Ranked
+	Got      string        `json:"got_intent"`        // Classified intent
+	Answered bool          `json:"answered"`          // The answer given came from an expected section
+	Fallback bool          `json:"fallback"`          // Answered with the fallback, or with nothing of substance
+	Latency  time.Duration `json:"latency_ns"`        // Time taken to answer
+	Answer   string        `json:"answer,omitempty"`  // Answer text, kept to explain regressions
+	Section  string        `json:"section,omitempty"` // Section the answer came from, if any
+}
+
+// evalSummary holds the metrics of a run.
+type evalSummary struct {
+	Cases          int                       `json:"cases"`
+	RetrievalCases int                       `json:"retrieval_cases"` // Cases with expected sections
+	IntentCases    int                       `json:"intent_cases"`    // Cases with an expected intent
+	K              int                       `json:"k"`
+	Top1           float64                   `json:"top1_accuracy"`
+	RecallAtK      float64                   `json:"recall_at_k"`
+	MRR            float64                   `json:"mrr"`
+	AnswerAccuracy float64                   `json:"answer_accuracy"` // Share of retrieval cases answered from an expected section
+	IntentAccuracy float64                   `json:"intent_accuracy"`
+	FallbackRate   float64                   `json:"fallback_rate"`
+	Confusion      map[string]map[string]int `json:"confusion"` // Expected intent, then classified intent
+}
+
+// evalRun is the saved result of an evaluation, which eval diff compares.
+type evalRun struct {
+	ModelVersion string        `json:"model_version"`
+	Variant      string        `json:"variant,omitempty"` // Retrieval variant evaluated
+	GoldenFile   string        `json:"golden_file"`
+	Time         time.Time     `json:"time"`
+	Summary      evalSummary   `json:"summary"`
+	Outcomes     []evalOutcome `json:"outcomes"`
+}
+
+// loadGoldenSet reads evalCases from a JSONL file, one case per line, or
+// from a YAML file holding a list of cases.
+func loadGoldenSet(path string) ([]evalCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cases []evalCase
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.NewDecoder(f).Decode(&cases); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			var c evalCase
+			if err := json.Unmarshal([]byte(text), &c); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			cases = append(cases, c)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, c := range cases {
+		if strings.TrimSpace(c.Query) == "" {
+			return nil, fmt.Errorf("%s: case %d has no query", path, i+1)
+		}
+	}
+	return cases, nil
+}
+
+// evaluate runs every case through the answer pipeline in a fresh session
+// and scores the first k ranked sections and the answer actually given.
+// An answer with nothing but related keywords and topics counts as a
+// fallback.
+func evaluate(cases []evalCase, k int) []evalOutcome {
+	outcomes := make([]evalOutcome, 0, len(cases))
+	for _, c := range cases {
+		result := Answer(context.Background(), Query{Text: c.Query})
+		o := evalOutcome{
+			evalCase: c,
+			Ranked:   make([]string, 0, len(result.Matches)),
+			Got:      result.Intent,
+			Fallback: result.Fallback || !substantiveAnswer(result.Answer),
+			Latency:  result.Latency,
+			Answer:   result.Answer,
+			Section:  result.Section,
+		}
+		for _, m := range result.Matches {
+			o.Ranked = append(o.Ranked, m.Section)
+		}
+		o.Rank, o.Recall = scoreRanking(c.Sections, o.Ranked, k)
+		o.Answered = result.Section != "" && slices.ContainsFunc(c.Sections, func(want string) bool {
+			return strings.EqualFold(want, result.Section)
+		})
+		outcomes = append(outcomes, o)
+	}
+	return outcomes
+}
+
+// substantiveAnswer reports whether an answer says anything beyond the
+// related keywords and topics appended to it.
+func substantiveAnswer(answer string) bool {
+	for _, line := range strings.Split(answer, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "Related Keywords:") && !strings.HasPrefix(line, "Related Topics:") {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreRanking returns the 1-based rank of the first expected section in
+// the top k of ranked, or 0, and the share of expected sections found there.
+// Section titles are compared case-insensitively.
+func scoreRanking(expected, ranked []string, k int) (int, float64) {
+	if len(expected) == 0 {
+		return 0, 0
+	}
+	rank, found := 0, 0
+	for i, title := range ranked {
+		if i == k {
+			break
+		}
+		for _, want := range expected {
+			if strings.EqualFold(title, want) {
+				if rank == 0 {
+					rank = i + 1
+				}
+				found++
+				break
+			}
+		}
+	}
+	return rank, float64(found) / float64(len(expected))
+}
+
+// summarize computes the metrics of outcomes.
+func summarize(outcomes []evalOutcome, k int) evalSummary {
+	s := evalSummary{Cases: len(outcomes), K: k, Confusion: make(map[string]map[string]int)}
+	intentCorrect, fallbacks := 0, 0
+	for _, o := range outcomes {
+		if o.Fallback {
+			fallbacks++
+		}
+		if len(o.Sections) > 0 {
+			s.RetrievalCases++
+			if o.Rank == 1 {
+				s.Top1++
+			}
+			if o.Rank > 0 {
+				s.MRR += 1 / float64(o.Rank)
+			}
+			if o.Answered {
+				s.AnswerAccuracy++
+			}
+			s.RecallAtK += o.Recall
+		}
+		if o.Intent != "" {
+			s.IntentCases++
+			got := o.Got
+			if got == "" {
+				got = noIntent
+			}
+			if s.Confusion[o.Intent] == nil {
+				s.Confusion[o.Intent] = make(map[string]int)
+			}
+			s.Confusion[o.Intent][got]++
+			if o.Got == o.Intent {
+				intentCorrect++
+			}
+		}
+	}
+
+	if s.RetrievalCases > 0 {
+		n := float64(s.RetrievalCases)
+		s.Top1 /= n
+		s.MRR /= n
+		s.RecallAtK /= n
+		s.AnswerAccuracy /= n
+	}
+	if s.IntentCases > 0 {
+		s.IntentAccuracy = float64(intentCorrect) / float64(s.IntentCases)
+	}
+	if s.Cases > 0 {
+		s.FallbackRate = float64(fallbacks) / float64(s.Cases)
+	}
+	return s
+}
+
+// unknownSections lists expected sections that are not in the corpus,
+// which usually means a typo in the golden set.
+func unknownSections(cases []evalCase) []string {
+	titles := make(map[string]bool, len(corpusSections))
+	for _, section := range corpusSections {
+		titles[strings.ToLower(section.Title)] = true
+	}
+	seen := make(map[string]bool)
+	var unknown []string
+	for _, c := range cases {
+		for _, title := range c.Sections {
+			if !titles[strings.ToLower(title)] && !seen[title] {
+				seen[title] = true
+				unknown = append(unknown, title)
+			}
+		}
+	}
+	return unknown
+}
+
+// printSummary writes the metrics and confusion matrix of run to w.
+func printSummary(w io.Writer, run evalRun) error {
+	s := run.Summary
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "model\t%s\n", run.ModelVersion)
+	if run.Variant != "" {
+		fmt.Fprintf(tw, "variant\t%s\n", run.Variant)
+	}
+	fmt.Fprintf(tw, "cases\t%d (%d with sections, %d with intents)\n", s.Cases, s.RetrievalCases, s.IntentCases)
+	fmt.Fprintf(tw, "top-1 accuracy\t%.3f\n", s.Top1)
+	fmt.Fprintf(tw, "recall@%d\t%.3f\n", s.K, s.RecallAtK)
+	fmt.Fprintf(tw, "MRR\t%.3f\n", s.MRR)
+	fmt.Fprintf(tw, "answer accuracy\t%.3f\n", s.AnswerAccuracy)
+	fmt.Fprintf(tw, "intent accuracy\t%.3f\n", s.IntentAccuracy)
+	fmt.Fprintf(tw, "fallback rate\t%.3f\n", s.FallbackRate)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(s.Confusion) == 0 {
+		return nil
+	}
+
+	// Rows are expected intents, columns classified intents
+	var expected []string
+	labelSet := make(map[string]bool)
+	for want, row := range s.Confusion {
+		expected = append(expected, want)
+		labelSet[want] = true
+		for got := range row {
+			labelSet[got] = true
+		}
+	}
+	labels := make([]string, 0, len(labelSet))
+	for label := range labelSet {
+		labels = append(labels, label)
+	}
+	sort.Strings(expected)
+	sort.Strings(labels)
+
+	fmt.Fprintln(w, "\nintent confusion (rows expected, columns classified):")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "\t")
+	for _, label := range labels {
+		fmt.Fprintf(tw, "%s\t", label)
+	}
+	fmt.Fprintln(tw)
+	for _, want := range expected {
+		fmt.Fprintf(tw, "%s\t", want)
+		for _, got := range labels {
+			fmt.Fprintf(tw, "%d\t", s.Confusion[want][got])
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// evalChange is a case whose outcome differs between two runs.
+type evalChange struct {
+	Query  string
+	Detail string
+}
+
+// diffRuns compares two runs case by case, matching cases by query. A case
+// regresses when it loses the expected intent, its answer no longer comes
+// from an expected section, or its expected section drops in the ranking;
+// it improves in the opposite cases.
+func diffRuns(base, next evalRun) (regressed, improved []evalChange) {
+	before := make(map[string]evalOutcome, len(base.Outcomes))
+	for _, o := range base.Outcomes {
+		before[o.Query] = o
+	}
+	rankLabel := func(rank int) string {
+		if rank == 0 {
+			return "missing"
+		}
+		return fmt.Sprintf("#%d", rank)
+	}
+
+	for _, o := range next.Outcomes {
+		b, ok := before[o.Query]
+		if !ok {
+			continue
+		}
+		if len(o.Sections) > 0 && b.Rank != o.Rank {
+			change := evalChange{Query: o.Query, Detail: fmt.Sprintf("section %s -> %s", rankLabel(b.Rank), rankLabel(o.Rank))}
+			if o.Rank == 0 || (b.Rank != 0 && o.Rank > b.Rank) {
+				regressed = append(regressed, change)
+			} else {
+				improved = append(improved, change)
+			}
+		}
+		if len(o.Sections) > 0 && b.Answered != o.Answered {
+			change := evalChange{Query: o.Query, Detail: fmt.Sprintf("answer from %q -> %q", b.Section, o.Section)}
+			if o.Answered {
+				improved = append(improved, change)
+			} else {
+				regressed = append(regressed, change)
+			}
+		}
+		if o.Intent != "" && (b.Got == o.Intent) != (o.Got == o.Intent) {
+			change := evalChange{Query: o.Query, Detail: fmt.Sprintf("intent %q -> %q (want %q)", b.Got, o.Got, o.Intent)}
+			if o.Got == o.Intent {
+				improved = append(improved, change)
+			} else {
+				regressed = append(regressed, change)
+			}
+		}
+	}
+	return regressed, improved
+}
+
+// printDiff writes a regression report comparing next against base.
+func printDiff(w io.Writer, base, next evalRun) (regressions int, err error) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "metric\t%s\t%s\tdelta\n", base.ModelVersion, next.ModelVersion)
+	for _, m := range []struct {
+		name       string
+		base, next float64
+	}{
+		{"top-1 accuracy", base.Summary.Top1, next.Summary.Top1},
+		{fmt.Sprintf("recall@%d", next.Summary.K), base.Summary.RecallAtK, next.Summary.RecallAtK},
+		{"MRR", base.Summary.MRR, next.Summary.MRR},
+		{"answer accuracy", base.Summary.AnswerAccuracy, next.Summary.AnswerAccuracy},
+		{"intent accuracy", base.Summary.IntentAccuracy, next.Summary.IntentAccuracy},
+		{"fallback rate", base.Summary.FallbackRate, next.Summary.FallbackRate},
+	} {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%+.3f\n", m.name, m.base, m.next, m.next-m.base)
+	}
+	if err := tw.Flush(); err != nil {
+		return 0, err
+	}
+	if base.Summary.K != next.Summary.K {
+		fmt.Fprintf(w, "warning: runs used different k (%d and %d)\n", base.Summary.K, next.Summary.K)
+	}
+
+	regressed, improved := diffRuns(base, next)
+	for _, group := range []struct {
+		title   string
+		changes []evalChange
+	}{{"regressed", regressed}, {"improved", improved}} {
+		fmt.Fprintf(w, "\n%s: %d\n", group.title, len(group.changes))
+		for _, c := range group.changes {
+			fmt.Fprintf(w, "  %q: %s\n", c.Query, c.Detail)
+		}
+	}
+	return len(regressed), nil
+}
+
+// readEvalRun loads a run saved by eval -out.
+func readEvalRun(path string) (evalRun, error) {
+	var run evalRun
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return run, err
+	}
+	if err := json.Unmarshal(data, &run); err != nil {
+		return run, fmt.Errorf("%s: %w", path, err)
+	}
+	return run, nil
+}
+
+// runEvalCommand implements "eval", which scores the answer pipeline
+// against a golden set, and "eval diff", which compares two saved runs.
+func runEvalCommand(args []string) error {
+	if len(args) > 0 && args[0] == "diff" {
+		return runEvalDiff(args[1:])
+	}
+
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	k := fs.Int("k", config.Retrieval.TopMatches, "ranked sections scored per query")
+	out := fs.String("out", "", "save the run as JSON for eval diff")
+	variantName := fs.String("variant", controlVariant.Name, "retrieval variant to evaluate, from retrieval.variants")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *k < 1 {
+		return errors.New("usage: eval [-k n] [-variant name] [-out run.json] golden.jsonl|golden.yaml")
+	}
+	variant, ok := findVariant(*variantName)
+	if !ok {
+		return fmt.Errorf("unknown variant %q", *variantName)
+	}
+
+	cases, err := loadGoldenSet(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Evaluate against the model files only: nothing is read from or
+	// written to the configured store
+	useOfflineStore()
+	config.Retrieval.TopMatches = *k
+	config.Retrieval.Variants = []Variant{variant} // Every session gets it
+	initialize()
+	defer writeQueue.Close(context.Background())
+
+	if unknown := unknownSections(cases); len(unknown) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: sections not in the corpus: %s\n", strings.Join(unknown, ", "))
+	}
+
+	outcomes := evaluate(cases, *k)
+	run := evalRun{
+		ModelVersion: modelVersion,
+		Variant:      variant.Name,
+		GoldenFile:   fs.Arg(0),
+		Time:         time.Now().UTC(),
+		Summary:      summarize(outcomes, *k),
+		Outcomes:     outcomes,
+	}
+	if err := printSummary(os.Stdout, run); err != nil {
+		return err
+	}
+
+	if *out != "" {
+		data, err := json.MarshalIndent(run, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("\nsaved run to %s\n", *out)
+	}
+	return nil
+}
+
+// runEvalDiff implements "eval diff base.json candidate.json".
+func runEvalDiff(args []string) error {
+	fs := flag.NewFlagSet("eval diff", flag.ContinueOnError)
+	fail := fs.Bool("fail", false, "exit with an error if any case regressed")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: eval diff [-fail] base.json candidate.json")
+	}
+
+	base, err := readEvalRun(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	next, err := readEvalRun(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	regressions, err := printDiff(os.Stdout, base, next)
+	if err != nil {
+		return err
+	}
+	if *fail && regressions > 0 {
+		return fmt.Errorf("%d cases regressed", regressions)
+	}
+	return nil
+}
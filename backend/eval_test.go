@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScoreRanking(t *testing.T) {
+	tests := []struct {
+		name       string
+		expected   []string
+		ranked     []string
+		k          int
+		wantRank   int
+		wantRecall float64
+	}{
+		{"first", []string{"Channels"}, []string{"Channels", "Goroutines"}, 3, 1, 1},
+		{"other case", []string{"channels"}, []string{"Goroutines", "CHANNELS"}, 3, 2, 1},
+		{"beyond k", []string{"Channels"}, []string{"Slices", "Maps", "Channels"}, 2, 0, 0},
+		{"half found", []string{"Maps", "Channels"}, []string{"Slices", "Maps", "Structs"}, 3, 2, 0.5},
+		{"all found", []string{"Maps", "Channels"}, []string{"Channels", "Maps"}, 3, 1, 1},
+		{"none ranked", []string{"Maps"}, nil, 3, 0, 0},
+		{"nothing expected", nil, []string{"Maps"}, 3, 0, 0},
+	}
+	for _, tt := range tests {
+		rank, recall := scoreRanking(tt.expected, tt.ranked, tt.k)
+		if rank != tt.wantRank || recall != tt.wantRecall {
+			t.Errorf("%s: scoreRanking = %d, %v; want %d, %v", tt.name, rank, recall, tt.wantRank, tt.wantRecall)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	outcomes := []evalOutcome{
+		{evalCase: evalCase{Sections: []string{"A"}}, Rank: 1, Recall: 1, Answered: true},
+		{evalCase: evalCase{Sections: []string{"B"}}, Rank: 2, Recall: 1},
+		{evalCase: evalCase{Sections: []string{"C", "D"}}, Rank: 0, Recall: 0, Fallback: true},
+		{evalCase: evalCase{Sections: []string{"E"}, Intent: "greeting"}, Rank: 4, Recall: 0.5, Answered: true, Got: "greeting"},
+		{evalCase: evalCase{Intent: "greeting"}, Got: "farewell"},
+		{evalCase: evalCase{Intent: "farewell"}, Fallback: true},
+		{evalCase: evalCase{}},
+	}
+	s := summarize(outcomes, 3)
+
+	for _, tt := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"cases", float64(s.Cases), 7},
+		{"retrieval cases", float64(s.RetrievalCases), 4},
+		{"intent cases", float64(s.IntentCases), 3},
+		{"top-1", s.Top1, 0.25},
+		{"recall", s.RecallAtK, 2.5 / 4},
+		{"MRR", s.MRR, (1 + 0.5 + 0.25) / 4},
+		{"answer accuracy", s.AnswerAccuracy, 0.5},
+		{"intent accuracy", s.IntentAccuracy, 1.0 / 3},
+		{"fallback rate", s.FallbackRate, 2.0 / 7},
+	} {
+		if math.Abs(tt.got-tt.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	want := map[string]map[string]int{
+		"greeting": {"greeting": 1, "farewell": 1},
+		"farewell": {noIntent: 1},
+	}
+	if !reflect.DeepEqual(s.Confusion, want) {
+		t.Errorf("confusion = %v, want %v", s.Confusion, want)
+	}
+
+	if s := summarize(nil, 3); s.Cases != 0 || s.Top1 != 0 || s.FallbackRate != 0 {
+		t.Errorf("summary of no outcomes = %+v, want zeros", s)
+	}
+}
+
+func TestDiffRuns(t *testing.T) {
+	outcome := func(query string, rank int, answered bool, got string) evalOutcome {
+		return evalOutcome{evalCase: evalCase{Query: query, Sections: []string{"S"}, Intent: "greeting"}, Rank: rank, Answered: answered, Section: "S", Got: got}
+	}
+	base := evalRun{Outcomes: []evalOutcome{
+		outcome("same", 1, true, "greeting"),
+		outcome("dropped", 1, true, "greeting"),
+		outcome("lost", 2, false, "greeting"),
+		outcome("rose", 3, false, ""),
+		outcome("only in base", 1, true, "greeting"),
+	}}
+	next := evalRun{Outcomes: []evalOutcome{
+		outcome("same", 1, true, "greeting"),
+		outcome("dropped", 2, false, "greeting"),
+		outcome("lost", 0, false, "farewell"),
+		outcome("rose", 1, true, "greeting"),
+		outcome("only in next", 0, false, ""),
+	}}
+	regressed, improved := diffRuns(base, next)
+
+	details := func(changes []evalChange) []string {
+		var s []string
+		for _, c := range changes {
+			s = append(s, c.Query+": "+c.Detail)
+		}
+		return s
+	}
+	wantRegressed := []string{
+		`dropped: section #1 -> #2`,
+		`dropped: answer from "S" -> "S"`,
+		`lost: section #2 -> missing`,
+		`lost: intent "greeting" -> "farewell" (want "greeting")`,
+	}
+	wantImproved := []string{
+		`rose: section #3 -> #1`,
+		`rose: answer from "S" -> "S"`,
+		`rose: intent "" -> "greeting" (want "greeting")`,
+	}
+	if got := details(regressed); !reflect.DeepEqual(got, wantRegressed) {
+		t.Errorf("regressed = %q, want %q", got, wantRegressed)
+	}
+	if got := details(improved); !reflect.DeepEqual(got, wantImproved) {
+		t.Errorf("improved = %q, want %q", got, wantImproved)
+	}
+}
+
+func TestLoadGoldenSet(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cases, err := loadGoldenSet(write("golden.jsonl", `# comment
+
+{"query": "what is a slice", "sections": ["Slices"]}
+{"query": "hello", "intent": "greeting"}
+`))
+	want := []evalCase{{Query: "what is a slice", Sections: []string{"Slices"}}, {Query: "hello", Intent: "greeting"}}
+	if err != nil || !reflect.DeepEqual(cases, want) {
+		t.Errorf("JSONL = %+v, %v; want %+v", cases, err, want)
+	}
+	cases, err = loadGoldenSet(write("golden.yaml", `- query: what is a slice
+  sections: [Slices]
+- query: hello
+  intent: greeting
+`))
+	if err != nil || !reflect.DeepEqual(cases, want) {
+		t.Errorf("YAML = %+v, %v; want %+v", cases, err, want)
+	}
+
+	for _, tt := range []struct {
+		name, content, wantErr string
+	}{
+		{"bad.jsonl", "{\"query\": \"a\"}\n{\"query\": \n", "bad.jsonl:2:"},
+		{"blank.jsonl", "{\"query\": \"a\"}\n{\"query\": \" \"}\n", "case 2 has no query"},
+		{"bad.yaml", "- query: [a\n", "bad.yaml"},
+	} {
+		if _, err := loadGoldenSet(write(tt.name, tt.content)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSubstantiveAnswer(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{"A slice is a view of an array.", true},
+		{"", false},
+		{"\nRelated Keywords: slice, array\nRelated Topics: Arrays\n", false},
+		{"Related Topics: Arrays\nSee the tour.", true},
+	}
+	for _, tt := range tests {
+		if got := substantiveAnswer(tt.answer); got != tt.want {
+			t.Errorf("substantiveAnswer(%q) = %v, want %v", tt.answer, got, tt.want)
+		}
+	}
+}
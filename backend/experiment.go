@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Section weightings and similarities a retrieval variant can combine.
+const (
+	weightingTFIDF      = "tfidf"
+	weightingBM25       = "bm25"
+	similarityCosine    = "cosine"
+	similarityEuclidean = "euclidean"
+)
+
+// Variant is a section retrieval strategy under test. Each session is
+// assigned one variant and keeps it for all of its turns.
+type Variant struct {
+	Name       string `json:"name"`
+	Weighting  string `json:"weighting"`  // tfidf or bm25
+	Similarity string `json:"similarity"` // cosine or euclidean
+	Weight     int    `json:"weight"`     // Share of sessions relative to the other variants; 0 counts as 1
+}
+
+// controlVariant is how sections were always retrieved. It is used when
+// no variants are configured.
+var controlVariant = Variant{Name: "control", Weighting: weightingTFIDF, Similarity: similarityCosine, Weight: 1}
+
+// parseVariant parses name:weighting:similarity[:weight].
+func parseVariant(s string) (Variant, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		return Variant{}, errors.New("variants must be written name:weighting:similarity[:weight]")
+	}
+	v := Variant{Name: parts[0], Weighting: parts[1], Similarity: parts[2], Weight: 1}
+	if len(parts) == 4 {
+		weight, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return Variant{}, fmt.Errorf("variant %s: weight %q is not an integer", v.Name, parts[3])
+		}
+		v.Weight = weight
+	}
+	return v, nil
+}
+
+// share is the variant's weight, counting 0 as 1.
+func (v Variant) share() int {
+	return max(v.Weight, 1)
+}
+
+// assignVariant picks a session's variant from a hash of its id, so a
+// session always lands in the same bucket.
+func assignVariant(sessionID string, variants []Variant) Variant {
+	if len(variants) == 0 {
+		return controlVariant
+	}
+	total := 0
+	for _, v := range variants {
+		total += v.share()
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(sessionID))
+	bucket := int(hash.Sum32() % uint32(total))
+	for _, v := range variants {
+		if bucket < v.share() {
+			return v
+		}
+		bucket -= v.share()
+	}
+	return variants[len(variants)-1]
+}
+
+// findVariant returns the configured variant called name. control is
+// always available.
+func findVariant(name string) (Variant, bool) {
+	for _, v := range config.Retrieval.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	if name == controlVariant.Name {
+		return controlVariant, true
+	}
+	return Variant{}, false
+}
+
+// variantReport compares a variant's answers, as served by
+// /admin/experiment.
+type variantReport struct {
+	Variant
+	Active        bool    `json:"active"`         // Assigned to new sessions
+	Turns         int     `json:"turns"`          // Answered queries
+	Fallbacks     int     `json:"fallbacks"`      // Answers nothing could be found for
+	FallbackRate  float64 `json:"fallback_rate"`  // Fallbacks per turn
+	Ratings       int     `json:"ratings"`        // Feedback received on its turns
+	AverageRating float64 `json:"average_rating"` // Mean star rating, 0 without ratings
+}
+
+// experimentReport lists every active variant, and every variant that
+// answered stored turns, with its results.
+func experimentReport(ctx context.Context) ([]variantReport, error) {
+	stats, err := store.VariantStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	active := config.Retrieval.Variants
+	if len(active) == 0 {
+		active = []Variant{controlVariant}
+	}
+	var reports []variantReport
+	index := make(map[string]int)
+	for _, v := range active {
+		index[v.Name] = len(reports)
+		reports = append(reports, variantReport{Variant: v, Active: true})
+	}
+	for _, s := range stats {
+		i, ok := index[s.Variant]
+		if !ok {
+			// Variants no longer configured are reported by name only
+			i = len(reports)
+			reports = append(reports, variantReport{Variant: Variant{Name: s.Variant}})
+		}
+		r := &reports[i]
+		r.Turns, r.Fallbacks, r.Ratings, r.AverageRating = s.Turns, s.Fallbacks, s.Ratings, s.AverageRating
+		if r.Turns > 0 {
+			r.FallbackRate = float64(r.Fallbacks) / float64(r.Turns)
+		}
+	}
+	return reports, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// errorPattern maps a family of Go compiler or runtime errors to advice.
+type errorPattern struct {
+	Category string
+	Pattern  *regexp.Regexp
+	Fix      string
+}
+
+// Common Go errors recognised by the "debug my error" flow.
+var goErrorPatterns = []errorPattern{
+	{
+		Category: "an undefined identifier",
+		Pattern:  regexp.MustCompile(`undefined:|undeclared name`),
+		Fix:      "Check the spelling and capitalisation of the name, make sure the package that declares it is imported, and remember that only names starting with a capital letter are exported from other packages.",
+	},
+	{
+		Category: "an unused variable",
+		Pattern:  regexp.MustCompile(`declared and not used|declared but not used`),
+		Fix:      "Go refuses to compile unused local variables. Use the variable, remove it, or assign it to the blank identifier `_`.",
+	},
+	{
+		Category: "an unused import",
+		Pattern:  regexp.MustCompile(`imported and not used`),
+		Fix:      "Remove the import, or run `goimports` to manage imports automatically. Use `import _ \"pkg\"` if you only need its side effects.",
+	},
+	{
+		Category: "a type mismatch",
+		Pattern:  regexp.MustCompile(`cannot use .* as .* (value|type)|mismatched types`),
+		Fix:      "Convert the value explicitly, e.g. `float64(n)`, or change the declared type so both sides agree. Go never converts between types implicitly.",
+	},
+	{
+		Category: "a missing return",
+		Pattern:  regexp.MustCompile(`missing return`),
+		Fix:      "Every path through a function with results must end in a `return`. Add a final `return` after the last `if` or `switch`.",
+	},
+	{
+		Category: "a nil pointer dereference",
+		Pattern:  regexp.MustCompile(`nil pointer dereference|invalid memory address`),
+		Fix:      "A pointer, map, interface or channel was used before it was initialised. Check the value for `nil` before using it and make sure constructors return initialised values. The stack trace shows the line that failed.",
+	},
+	{
+		Category: "an out of range index",
+		Pattern:  regexp.MustCompile(`index out of range|slice bounds out of range`),
+		Fix:      "Check `len(s)` before indexing and remember that valid indexes run from 0 to `len(s)-1`.",
+	},
+	{
+		Category: "a deadlock",
+		Pattern:  regexp.MustCompile(`all goroutines are asleep|deadlock`),
+		Fix:      "Every goroutine is blocked. Make sure each channel send has a receiver (or use a buffered channel), that channels are closed when ranging over them, and that `sync.WaitGroup` counters match.",
+	},
+	{
+		Category: "concurrent map access",
+		Pattern:  regexp.MustCompile(`concurrent map (writes|read and map write|iteration and map write)`),
+		Fix:      "Maps are not safe for concurrent use. Guard the map with a `sync.Mutex` or `sync.RWMutex`, or use `sync.Map`. Run your program with `-race` to find the culprit.",
+	},
+	{
+		Category: "a nil map assignment",
+		Pattern:  regexp.MustCompile(`assignment to entry in nil map`),
+		Fix:      "Initialise the map with `make(map[K]V)` or a map literal before assigning to it.",
+	},
+}
+
+// classifyGoError returns the pattern matching the error text, if any.
+func classifyGoError(text string) (errorPattern, bool) {
+	lower := strings.ToLower(text)
+	for _, p := range goErrorPatterns {
+		if p.Pattern.MatchString(lower) {
+			return p, true
+		}
+	}
+	return errorPattern{}, false
+}
+
+// debugErrorFlow asks for an error message, classifies it and suggests fixes.
+// Its triggers are whole phrases, as a bare "debug" would also catch
+// questions such as "debug printing in go".
+var debugErrorFlow = &Flow{
+	Name:     "debug my error",
+	Triggers: []string{"debug my error", "help me debug", "i have an error", "i got an error", "fix my error"},
+	Start:    "ask_error",
+	States: map[string]FlowState{
+		"ask_error": {
+			Prompt: "Paste the error message you're seeing and I'll try to explain it.",
+			Action: func(ctx *FlowContext, input string) {
+				if p, ok := classifyGoError(input); ok {
+					ctx.Vars["category"] = p.Category
+					ctx.Vars["fix"] = p.Fix
+				}
+				if idx, _ := findSection(input, ctx.Session.Variant); idx >= 0 {
+					ctx.Vars["reading"] = corpusSections[idx].Title
+				}
+			},
+			Transitions: []Transition{
+				{When: whenVar("category"), To: "suggest"},
+				{When: whenVar("reading"), To: "unknown"},
+				{To: "unrecognised"},
+			},
+		},
+		"suggest": {
+			Prompt: "That looks like {category}.\n{fix}\n\nDid that help? (yes/no)",
+			Transitions: []Transition{
+				{When: onWords("yes", "y", "yep", "yeah", "it did", "thanks", "thank you"), To: "solved"},
+				{When: onWords("no", "n", "nope", "not really", "it didnt"), To: "escalate"},
+			},
+			Retry: "Did that help? Please answer yes or no.",
+		},
+		"unknown": {
+			Prompt: "I don't recognise that error, but the section on {reading} may help. Ask me about it for details.",
+		},
+		"unrecognised": {
+			Prompt: "I don't recognise that error. Try asking me about the package or feature you were using.",
+		},
+		"solved": {
+			Prompt: "Glad that helped! Happy coding.",
+		},
+		"escalate": {
+			Prompt: "Sorry about that. Try running `go vet` and building with `-race`, and check the full error including the file and line number. Paste the surrounding code into a new question if you'd like me to look again.",
+		},
+	},
+}
+
+// continueLesson matches replies that ask for the next step of a lesson.
+var continueLesson = onWords("next", "continue", "ok", "okay", "yes", "more", "go on")
+
+// learnTopicFlow walks through a corpus section and its subsections in order.
+var learnTopicFlow = &Flow{
+	Name:     "learn a topic",
+	Triggers: []string{"learn a topic", "teach me", "i want to learn"},
+	Start:    "ask_topic",
+	States: map[string]FlowState{
+		"ask_topic": {
+			Prompt: "What would you like to learn about? For example: Concurrency, Testing in Go or Working with Packages.",
+			Action: startLesson,
+			Transitions: []Transition{
+				{When: whenVar("lesson"), To: "lesson"},
+			},
+			Retry: "I couldn't find that topic. Try another one, or say \"cancel\".",
+		},
+		"lesson": {
+			Prompt: "{lesson}\n\n({step} of {steps}) Say \"next\" to continue or \"stop\" to finish.",
+			Action: advanceLesson,
+			Transitions: []Transition{
+				{When: whenVar("finished"), To: "done"},
+				{When: continueLesson, To: "lesson"},
+			},
+			Retry: "Say \"next\" to continue with {topic} or \"stop\" to finish.",
+		},
+		"done": {
+			Prompt: "That's the end of {topic}. Nice work!",
+		},
+	},
+}
+
+// startLesson finds the section for the requested topic and queues it and
+// its subsections as the steps of the lesson.
+func startLesson(ctx *FlowContext, input string) {
+	idx, _ := findSection(input, ctx.Session.Variant)
+	if idx < 0 {
+		return
+	}
+	// Teach from the top-level topic so subsections are covered in order
+	for idx > 0 && corpusSections[idx].Level > 2 {
+		idx--
+	}
+
+	ctx.Vars["topic"] = corpusSections[idx].Title
+	ctx.Vars["first"] = strconv.Itoa(idx)
+	ctx.Vars["steps"] = strconv.Itoa(len(children(corpusSections, idx)) + 1)
+	ctx.Vars["step"] = "0"
+	showLessonStep(ctx)
+}
+
+// advanceLesson moves to the next step when the user asks to continue.
+func advanceLesson(ctx *FlowContext, input string) {
+	if continueLesson(ctx, input) {
+		showLessonStep(ctx)
+	}
+}
+
+// showLessonStep stores the next section of the lesson in the flow
+// variables, or marks the lesson finished.
+func showLessonStep(ctx *FlowContext) {
+	first, _ := strconv.Atoi(ctx.Vars["first"])
+	step, _ := strconv.Atoi(ctx.Vars["step"])
+	steps, _ := strconv.Atoi(ctx.Vars["steps"])
+	if step >= steps || first+step >= len(corpusSections) { // The corpus may have been reloaded since
+		ctx.Vars["finished"] = "true"
+		return
+	}
+
+	section := corpusSections[first+step]
+	ctx.Vars["lesson"] = section.Title + "\n" + section.Text()
+	ctx.Vars["step"] = strconv.Itoa(step + 1)
+	ctx.Session.LastSection = section.Index
+	ctx.Session.LastTopic = section.Title
+	ctx.Session.shown = len(section.Paragraphs())
+}
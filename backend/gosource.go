@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadGoPackages documents the Go packages matched by patterns as corpus
+// sections: one per package, then one per exported type, function and
+// method, carrying its doc comment, signature and examples. A pattern is a
+// package directory, or a directory followed by /... for every package
+// below it. Environment variables such as $GOROOT are expanded.
+func loadGoPackages(patterns []string) ([]Section, error) {
+	var sections []Section
+	for _, pattern := range patterns {
+		dirs, err := goPackageDirs(os.ExpandEnv(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		for _, dir := range dirs {
+			pkgSections, err := documentGoPackage(dir)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", dir, err)
+			}
+			sections = append(sections, pkgSections...)
+		}
+	}
+	return sections, nil
+}
+
+// goPackageDirs expands a pattern into package directories. Walking
+// skips testdata, vendor, internal and hidden directories, as the go
+// command does for documentation.
+func goPackageDirs(pattern string) ([]string, error) {
+	root, recursive := strings.CutSuffix(pattern, "/...")
+	if !recursive {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("not a directory")
+		}
+		return []string{root}, nil
+	}
+
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		if path != root && (name == "testdata" || name == "vendor" || name == "internal" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs, err
+}
+
+// importPath guesses the import path of the package in dir: relative to
+// GOROOT/src for the standard library, otherwise the module path from the
+// nearest go.mod joined with the directory below it.
+func importPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+	if rel, err := filepath.Rel(filepath.Join(build.Default.GOROOT, "src"), abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	for d := abs; ; d = filepath.Dir(d) {
+		if data, err := os.ReadFile(filepath.Join(d, "go.mod")); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if module, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+					rel, _ := filepath.Rel(d, abs)
+					return strings.Trim(strings.TrimSpace(module)+"/"+filepath.ToSlash(rel), "/.")
+				}
+			}
+		}
+		if parent := filepath.Dir(d); parent == d {
+			break
+		}
+	}
+	return filepath.Base(abs)
+}
+
+// documentGoPackage parses the package in dir and returns its sections.
+// Directories without Go files, and commands, give none.
+func documentGoPackage(dir string) ([]Section, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(dir, name); err != nil || !ok {
+			continue // Excluded by build constraints
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	// Keep one package, and its external tests for their examples
+	name := ""
+	for _, file := range files {
+		if !strings.HasSuffix(fset.File(file.Pos()).Name(), "_test.go") {
+			name = file.Name.Name
+			break
+		}
+	}
+	if name == "" || name == "main" {
+		return nil, nil
+	}
+	kept := files[:0]
+	for _, file := range files {
+		if file.Name.Name == name || file.Name.Name == name+"_test" {
+			kept = append(kept, file)
+		}
+	}
+
+	// Locate the package comment first, as go/doc takes over the syntax trees
+	clause := packageClause(fset, kept)
+	pkg, err := doc.NewFromFiles(fset, kept, importPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	d := goDocumenter{fset: fset, pkg: pkg}
+	p := fset.Position(clause)
+	d.add(2, "Package "+pkg.Name, d.packageBody(), DocSource{Kind: docPackage, Package: pkg.ImportPath, Name: pkg.Name, File: p.Filename, Line: p.Line})
+	return d.document(), nil
+}
+
+// packageClause returns the package clause of the file holding the
+// package comment: doc.go if there is one, else the first file with a
+// package comment, else the first file.
+func packageClause(fset *token.FileSet, files []*ast.File) token.Pos {
+	var best *ast.File
+	for _, file := range files {
+		name := filepath.Base(fset.Position(file.Pos()).Filename)
+		switch {
+		case strings.HasSuffix(name, "_test.go"):
+		case file.Doc != nil && name == "doc.go":
+			return file.Package
+		case best == nil || (best.Doc == nil && file.Doc != nil):
+			best = file
+		}
+	}
+	if best == nil {
+		return token.NoPos
+	}
+	return best.Package
+}
+
+// goDocumenter turns the documentation of one package into sections.
+type goDocumenter struct {
+	fset     *token.FileSet
+	pkg      *doc.Package
+	sections []Section
+}
+
+// packageBody is the package comment, import path and package examples.
+func (d *goDocumenter) packageBody() []string {
+	var body []string
+	body = append(body, d.text(d.pkg.Doc)...)
+	body = append(body, "", "Import path: `"+d.pkg.ImportPath+"`")
+	return append(body, d.examples(d.pkg.Examples)...)
+}
+
+// document adds, after the package section, the package's functions, then
+// each type followed by its constructors and methods.
+func (d *goDocumenter) document() []Section {
+	pkg := d.pkg
+
+	for _, f := range pkg.Funcs {
+		d.addFunc(f, "")
+	}
+	for _, t := range pkg.Types {
+		decl := *t.Decl
+		decl.Doc = nil // Already in the body as text
+		var body []string
+		body = append(body, d.text(t.Doc)...)
+		body = append(body, "", "```go")
+		body = append(body, strings.Split(d.node(&decl), "\n")...)
+		body = append(body, "```")
+		body = append(body, d.examples(t.Examples)...)
+		d.add(3, pkg.Name+"."+t.Name, body, d.source(docType, t.Name, t.Decl.Pos()))
+		for _, f := range t.Funcs {
+			d.addFunc(f, "")
+		}
+		for _, m := range t.Methods {
+			d.addFunc(m, t.Name)
+		}
+	}
+	return d.sections
+}
+
+// addFunc adds a function, or a method of the type recv.
+func (d *goDocumenter) addFunc(f *doc.Func, recv string) {
+	name, kind := f.Name, docFunc
+	if recv != "" {
+		name, kind = recv+"."+f.Name, docMethod
+	}
+	decl := *f.Decl
+	decl.Doc, decl.Body = nil, nil // Only the signature
+	var body []string
+	body = append(body, d.text(f.Doc)...)
+	body = append(body, "", "`"+d.node(&decl)+"`")
+	body = append(body, d.examples(f.Examples)...)
+	d.add(3, d.pkg.Name+"."+name, body, d.source(kind, name, f.Decl.Pos()))
+}
+
+// add appends a section. An undocumented declaration leaves the body
+// starting with the blank line meant to follow its doc comment, which is
+// dropped.
+func (d *goDocumenter) add(level int, title string, body []string, source DocSource) {
+	for len(body) > 0 && body[0] == "" {
+		body = body[1:]
+	}
+	d.sections = append(d.sections, Section{Level: level, Title: title, Body: body, Source: source})
+}
+
+func (d *goDocumenter) source(kind, name string, pos token.Pos) DocSource {
+	p := d.fset.Position(pos)
+	return DocSource{Kind: kind, Package: d.pkg.ImportPath, Name: name, File: p.Filename, Line: p.Line}
+}
+
+// text renders a doc comment as plain text lines, paragraphs separated by
+// blank lines, or nil for an undocumented declaration.
+func (d *goDocumenter) text(comment string) []string {
+	text := strings.TrimSpace(string(d.pkg.Text(comment)))
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// node prints a declaration or example as Go source.
+func (d *goDocumenter) node(node ast.Node) string {
+	var buf bytes.Buffer
+	if err := (&printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 4}).Fprint(&buf, d.fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// examples renders examples as fenced code blocks with their output.
+func (d *goDocumenter) examples(examples []*doc.Example) []string {
+	var lines []string
+	for _, ex := range examples {
+		code := d.node(ex.Code)
+		if _, ok := ex.Code.(*ast.BlockStmt); ok {
+			// Drop the braces of the example function body
+			code = strings.TrimSuffix(strings.TrimPrefix(code, "{"), "}")
+			code = dedent(strings.Split(strings.Trim(code, "\n"), "\n"))
+		}
+		title := "Example"
+		if ex.Suffix != "" {
+			title += " (" + ex.Suffix + ")"
+		}
+		lines = append(lines, "", title+":", "```go")
+		lines = append(lines, strings.Split(code, "\n")...)
+		lines = append(lines, "```")
+		if ex.Output != "" {
+			lines = append(lines, "", "Output:", "```")
+			lines = append(lines, strings.Split(strings.TrimRight(ex.Output, "\n"), "\n")...)
+			lines = append(lines, "```")
+		}
+	}
+	return lines
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeywordEntity is an entry of the keyword knowledge base: a Go keyword,
+// type, built-in, package or concept.
+type KeywordEntity struct {
+	Name        string   `json:"name" yaml:"name"`
+	Aliases     []string `json:"aliases,omitempty" yaml:"aliases,omitempty"` // Other ways of writing the name, such as len()
+	Category    string   `json:"category" yaml:"category"`
+	Description string   `json:"description" yaml:"description"`
+	Example     string   `json:"example,omitempty" yaml:"example,omitempty"` // Go code showing it in use
+	Related     []string `json:"related,omitempty" yaml:"related,omitempty"` // Names of related entities
+	Links       []string `json:"links,omitempty" yaml:"links,omitempty"`     // Reference documentation
+
+	line int // Line of the entity in its file, 0 if unknown
+}
+
+// Entity names are identifiers or import paths, such as float64 or net/http.
+var keywordNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(/[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// loadProgrammingKeywords reads and validates the keyword knowledge base,
+// keyed by entity name. YAML and JSON files hold a list of entities; any
+// other file is read in the legacy text format.
+func loadProgrammingKeywords(filename string) (map[string]KeywordEntity, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []KeywordEntity
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&entities); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		// Decode again as nodes for the line of each entity
+		var doc yaml.Node
+		if yaml.Unmarshal(data, &doc) == nil && len(doc.Content) > 0 {
+			for i, node := range doc.Content[0].Content {
+				if i < len(entities) {
+					entities[i].line = node.Line
+				}
+			}
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entities); err != nil {
+			return nil, err
+		}
+	default:
+		entities, _ = convertKeywordText(string(data))
+	}
+	if err := validateKeywordEntities(entities); err != nil {
+		return nil, err
+	}
+
+	keywords := make(map[string]KeywordEntity, len(entities))
+	for _, entity := range entities {
+		keywords[entity.Name] = entity
+	}
+	return keywords, nil
+}
+
+// validateKeywordEntities reports every problem with the entities: missing
+// fields, names that are not identifiers or import paths, names and aliases
+// used twice, related entities that do not exist and links that are not
+// web addresses. Each problem names the entity and, when known, its line.
+func validateKeywordEntities(entities []KeywordEntity) error {
+	var problems []error
+	problem := func(i int, format string, args ...interface{}) {
+		where := fmt.Sprintf("entity %d (%s)", i+1, entities[i].Name)
+		if entities[i].line > 0 {
+			where = fmt.Sprintf("line %d: %s", entities[i].line, where)
+		}
+		problems = append(problems, fmt.Errorf("%s: %s", where, fmt.Sprintf(format, args...)))
+	}
+
+	// Names and aliases share one namespace, ignoring case
+	owners := make(map[string]int)
+	for i, entity := range entities {
+		for _, name := range append([]string{entity.Name}, entity.Aliases...) {
+			key := strings.ToLower(strings.TrimSpace(name))
+			if key == "" {
+				continue
+			}
+			if j, ok := owners[key]; ok && j != i {
+				problem(i, "%q is already used by entity %d (%s)", name, j+1, entities[j].Name)
+				continue
+			}
+			owners[key] = i
+		}
+	}
+
+	for i, entity := range entities {
+		if !keywordNamePattern.MatchString(entity.Name) {
+			problem(i, "name must be an identifier or import path")
+		}
+		for _, alias := range entity.Aliases {
+			if strings.TrimSpace(alias) == "" {
+				problem(i, "aliases must not be empty")
+			}
+		}
+		if strings.TrimSpace(entity.Category) == "" {
+			problem(i, "category is required")
+		}
+		if strings.TrimSpace(entity.Description) == "" {
+			problem(i, "description is required")
+		}
+		for _, related := range entity.Related {
+			j, ok := owners[strings.ToLower(related)]
+			switch {
+			case !ok:
+				problem(i, "related entity %q does not exist", related)
+			case j == i:
+				problem(i, "an entity cannot be related to itself")
+			}
+		}
+		for _, link := range entity.Links {
+			if u, err := url.Parse(link); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				problem(i, "link %q is not an http or https URL", link)
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// findKeyword looks an entity up by name, then by name or alias ignoring
+// case. The caller holds modelMu.
+func findKeyword(name string) (KeywordEntity, bool) {
+	if entity, ok := programmingKeywords[name]; ok {
+		return entity, true
+	}
+	for _, entity := range programmingKeywords {
+		if strings.EqualFold(entity.Name, name) {
+			return entity, true
+		}
+		for _, alias := range entity.Aliases {
+			if strings.EqualFold(alias, name) {
+				return entity, true
+			}
+		}
+	}
+	return KeywordEntity{}, false
+}
+
+// convertKeywordText parses the legacy text format: category headings on
+// lines of their own, each followed by "name - description" lines. A line
+// naming several entities, such as "float32, float64", gives one entity
+// for each, related to the others; call syntax such as "len()" becomes an
+// alias. Names already defined are skipped and reported in notes.
+func convertKeywordText(text string) (entities []KeywordEntity, notes []string) {
+	defined := make(map[string]bool)
+	category := ""
+	for lineNo, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		names, description, ok := strings.Cut(line, " - ")
+		if !ok {
+			// Headings such as "Common Standard Library Packages (Entities)"
+			category, _, _ = strings.Cut(line, " (")
+			continue
+		}
+
+		first := len(entities)
+		for _, name := range splitKeywordNames(names) {
+			var aliases []string
+			if base, ok := strings.CutSuffix(name, "()"); ok {
+				name, aliases = base, []string{name}
+			}
+			if defined[strings.ToLower(name)] {
+				notes = append(notes, fmt.Sprintf("line %d: %s is already defined, skipped", lineNo+1, name))
+				continue
+			}
+			defined[strings.ToLower(name)] = true
+			entities = append(entities, KeywordEntity{
+				Name:        name,
+				Aliases:     aliases,
+				Category:    category,
+				Description: strings.TrimSpace(description),
+				Links:       keywordLinks(name),
+				line:        lineNo + 1,
+			})
+		}
+
+		siblings := entities[first:]
+		for i := range siblings {
+			for j := range siblings {
+				if i != j {
+					siblings[i].Related = append(siblings[i].Related, siblings[j].Name)
+				}
+			}
+		}
+	}
+	return entities, notes
+}
+
+// splitKeywordNames splits "defer, panic, and recover" into its names.
+func splitKeywordNames(names string) []string {
+	var split []string
+	for _, name := range strings.Split(names, ",") {
+		for _, name := range strings.Split(name, " and ") {
+			name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "and "))
+			if name != "" {
+				split = append(split, name)
+			}
+		}
+	}
+	return split
+}
+
+// keywordLinks points an entity at its reference documentation: the
+// language specification for keywords, the builtin package for predeclared
+// types and functions, and the package documentation for standard library
+// packages.
+func keywordLinks(name string) []string {
+	switch {
+	case token.IsKeyword(name):
+		return []string{"https://go.dev/ref/spec#Keywords"}
+	case types.Universe.Lookup(name) != nil:
+		return []string{"https://pkg.go.dev/builtin#" + name}
+	}
+	if pkg, err := build.Default.Import(name, "", build.FindOnly); err == nil && pkg.Goroot {
+		return []string{"https://pkg.go.dev/" + name}
+	}
+	return nil
+}
+
+// runKeywordsCommand implements "keywords convert [-out file.yaml] file.txt"
+// and "keywords check file".
+func runKeywordsCommand(args []string) error {
+	const usage = "usage: keywords convert [-out file.yaml] file.txt | check file"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "convert":
+		fs := flag.NewFlagSet("keywords convert", flag.ContinueOnError)
+		out := fs.String("out", "", "YAML file to write (default standard output)")
+		if err := fs.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New(usage)
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		entities, notes := convertKeywordText(string(data))
+		for _, note := range notes {
+			fmt.Fprintln(os.Stderr, note)
+		}
+		if err := validateKeywordEntities(entities); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString("# Go keyword knowledge base, converted from " + filepath.Base(fs.Arg(0)) + ".\n")
+		buf.WriteString("# Each entity has a name, aliases, category, description, example, related entities and links.\n")
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(entities); err != nil {
+			return err
+		}
+		if *out == "" {
+			_, err = os.Stdout.Write(buf.Bytes())
+			return err
+		}
+		if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %d entities to %s\n", len(entities), *out)
+		return nil
+
+	case "check":
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+		keywords, err := loadProgrammingKeywords(args[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		fmt.Printf("%s: %d entities\n", args[1], len(keywords))
+		return nil
+	}
+	return errors.New(usage)
+}
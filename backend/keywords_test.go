@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const validKeywords = `- name: chan
+  aliases: [channel]
+  category: Keywords
+  description: Declares a channel type.
+  related: [goroutine]
+  links: [https://go.dev/ref/spec#Channel_types]
+- name: goroutine
+  category: Concepts
+  description: A function running concurrently.
+  related: [channel]
+`
+
+func TestLoadProgrammingKeywords(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	keywords, err := loadProgrammingKeywords(write("valid.yaml", validKeywords))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keywords) != 2 || keywords["chan"].line != 1 || keywords["goroutine"].line != 7 {
+		t.Errorf("loaded %+v, want chan at line 1 and goroutine at line 7", keywords)
+	}
+	if !reflect.DeepEqual(keywords["chan"].Aliases, []string{"channel"}) {
+		t.Errorf("chan aliases = %q", keywords["chan"].Aliases)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr []string // Substrings of the error
+	}{
+		{"unknown field", "- name: chan\n  category: Keywords\n  description: d\n  see: [goroutine]\n", []string{"field see not found"}},
+		{"missing fields", "- name: chan\n- name: map\n  category: Keywords\n  description: d\n- name: func\n  category: Keywords\n", []string{
+			"line 1: entity 1 (chan): category is required",
+			"line 1: entity 1 (chan): description is required",
+			"line 5: entity 3 (func): description is required",
+		}},
+		{"duplicate name", validKeywords + "- name: chan\n  category: Keywords\n  description: d\n", []string{
+			`line 11: entity 3 (chan): "chan" is already used by entity 1 (chan)`,
+		}},
+		{"name used as an alias", validKeywords + "- name: Channel\n  category: Concepts\n  description: d\n", []string{
+			`line 11: entity 3 (Channel): "Channel" is already used by entity 1 (chan)`,
+		}},
+		{"alias used twice", validKeywords + "- name: select\n  aliases: [Goroutine, '']\n  category: Keywords\n  description: d\n", []string{
+			`line 11: entity 3 (select): "Goroutine" is already used by entity 2 (goroutine)`,
+			"line 11: entity 3 (select): aliases must not be empty",
+		}},
+		{"bad name", "- name: len()\n  category: Built-ins\n  description: d\n", []string{"line 1: entity 1 (len()): name must be an identifier or import path"}},
+		{"unknown relation", "- name: chan\n  category: Keywords\n  description: d\n  related: [select, chan]\n", []string{
+			`related entity "select" does not exist`,
+			"an entity cannot be related to itself",
+		}},
+		{"bad link", "- name: chan\n  category: Keywords\n  description: d\n  links: [go.dev/ref/spec, 'ftp://go.dev']\n", []string{
+			`link "go.dev/ref/spec" is not an http or https URL`,
+			`link "ftp://go.dev" is not an http or https URL`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadProgrammingKeywords(write(strings.ReplaceAll(tt.name, " ", "_")+".yaml", tt.content))
+			if err == nil {
+				t.Fatal("loaded without an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertKeywordText(t *testing.T) {
+	entities, notes := convertKeywordText(`Keywords (Entities)
+defer, panic, and recover - Handle panics.
+Built-in Functions
+len() - Returns the length.
+defer - Repeated.
+`)
+	var got []string
+	for _, e := range entities {
+		got = append(got, e.Category+"/"+e.Name+"/"+strings.Join(e.Aliases, ",")+"/"+strings.Join(e.Related, ","))
+	}
+	want := []string{
+		"Keywords/defer//panic,recover",
+		"Keywords/panic//defer,recover",
+		"Keywords/recover//defer,panic",
+		"Built-in Functions/len/len()/",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entities = %q, want %q", got, want)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "line 5: defer is already defined") {
+		t.Errorf("notes = %q, want defer skipped at line 5", notes)
+	}
+	if entities[0].line != 2 || entities[3].line != 4 {
+		t.Errorf("lines = %d, %d; want 2, 4", entities[0].line, entities[3].line)
+	}
+}
@@ -9,11 +9,12 @@ import (
 // It contains a vector (representing its TF-IDF values), the response associated with that entry, and the associated intent.
 type DataPoint struct {
 	Vector map[string]float64 // TF-IDF vector for the data point
+	Query  string             // The query the answer was given for, vectorized when set
 	Answer string             // The response associated with this data point
 	Intent string             // The identified intent of the data point (optional)
 }
 
-var dataset []DataPoint
+var dataset []DataPoint // Guarded by modelMu, as queries search it while answering
 
 // EuclideanDistance calculates the Euclidean distance between two vectors.
 func EuclideanDistance(vec1, vec2 map[string]float64) float64 {
@@ -57,35 +58,80 @@ func (a ByDistance) Less(i, j int) bool {
 	return a[i].Value < a[j].Value // Sort by increasing distance
 }
 
-// KNN function finds the k nearest neighbors to a given query vector.
-// It returns the most common answer among the nearest neighbors.
-func KNN(queryVec map[string]float64, dataset []DataPoint, k int) string {
-	distances := make([]Distance, len(dataset)) // Initialize distances slice
+// unitDistance is the Euclidean distance between the two vectors scaled to
+// unit length: 0 for the same direction, √2 for vectors sharing no terms.
+// An empty vector is 1 away from everything.
+func unitDistance(vec1, vec2 map[string]float64) float64 {
+	return EuclideanDistance(unitVector(vec1), unitVector(vec2))
+}
+
+func unitVector(vec map[string]float64) map[string]float64 {
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	unit := make(map[string]float64, len(vec))
+	for key, v := range vec {
+		if norm > 0 {
+			unit[key] = v / norm
+		}
+	}
+	return unit
+}
 
-	// Calculate the Euclidean distance for each data point in the dataset
+// KNN function finds the k nearest neighbors to a given query vector,
+// ignoring data points further than maxDistance between unit vectors, so
+// a query unlike every labelled example gets no answer.
+// It returns the most common answer among the nearest neighbors, and the
+// votes of the runner-up answer relative to it: 0 when no other answer got
+// a vote, 1 for a tie, which the nearer answer wins.
+func KNN(queryVec map[string]float64, dataset []DataPoint, k int, maxDistance float64) (string, float64) {
+	distances := make([]Distance, 0, len(dataset)) // Initialize distances slice
+
+	// Calculate the distance for each data point in the dataset
 	for i, point := range dataset {
-		dist := EuclideanDistance(queryVec, point.Vector) // Calculate distance
-		distances[i] = Distance{Index: i, Value: dist}    // Store index and distance
+		if len(point.Vector) == 0 {
+			continue // Nothing the model knows; it would be 1 away from every query
+		}
+		dist := unitDistance(queryVec, point.Vector) // Calculate distance
+		if dist <= maxDistance {
+			distances = append(distances, Distance{Index: i, Value: dist}) // Store index and distance
+		}
 	}
 
 	// Sort distances to find the nearest neighbors
-	sort.Sort(ByDistance(distances))
+	sort.Stable(ByDistance(distances))
 
-	// Count the frequency of answers among the k nearest neighbors
+	// Count the frequency of answers among the k nearest neighbors, noting
+	// the order they are first reached in
 	answerCount := make(map[string]int)
+	var answers []string
 	for i := 0; i < k && i < len(distances); i++ {
-		answerCount[dataset[distances[i].Index].Answer]++
+		answer := dataset[distances[i].Index].Answer
+		if answerCount[answer] == 0 {
+			answers = append(answers, answer)
+		}
+		answerCount[answer]++
 	}
 
-	// Determine the answer with the highest count (most common answer)
+	// Determine the answer with the highest count (most common answer); a
+	// tie goes to the answer of the nearest neighbour
 	var bestAnswer string
-	maxCount := 0
-	for answer, count := range answerCount {
+	maxCount, runnerUpCount := 0, 0
+	for _, answer := range answers {
+		count := answerCount[answer]
 		if count > maxCount {
+			runnerUpCount = maxCount
 			maxCount = count
 			bestAnswer = answer
+		} else if count > runnerUpCount {
+			runnerUpCount = count
 		}
 	}
+	if maxCount == 0 {
+		return "", 0
+	}
 
-	return bestAnswer // Return the most common answer among the nearest neighbors
+	return bestAnswer, float64(runnerUpCount) / float64(maxCount) // Return the most common answer among the nearest neighbors
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestUnitDistance(t *testing.T) {
+	tests := []struct {
+		name       string
+		vec1, vec2 map[string]float64
+		want       float64
+	}{
+		{"same", map[string]float64{"slice": 1, "append": 2}, map[string]float64{"slice": 1, "append": 2}, 0},
+		{"scaled", map[string]float64{"slice": 1, "append": 2}, map[string]float64{"slice": 3, "append": 6}, 0},
+		{"disjoint", map[string]float64{"slice": 1}, map[string]float64{"map": 5}, math.Sqrt2},
+		{"half shared", map[string]float64{"slice": 1, "append": 1}, map[string]float64{"slice": 1}, math.Sqrt(2 - math.Sqrt2)},
+		{"empty", map[string]float64{}, map[string]float64{"map": 5}, 1},
+	}
+	for _, tt := range tests {
+		if got := unitDistance(tt.vec1, tt.vec2); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: unitDistance = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKNN(t *testing.T) {
+	point := func(answer string, vec map[string]float64) DataPoint {
+		return DataPoint{Vector: vec, Answer: answer}
+	}
+	query := map[string]float64{"slice": 1, "append": 1}
+	tests := []struct {
+		name         string
+		dataset      []DataPoint
+		k            int
+		maxDistance  float64
+		want         string
+		wantRunnerUp float64
+	}{
+		{"majority", []DataPoint{
+			point("A", map[string]float64{"slice": 1, "append": 1}),
+			point("B", map[string]float64{"slice": 1, "append": 0.9}),
+			point("A", map[string]float64{"slice": 1, "append": 0.8}),
+		}, 3, 0.9, "A", 0.5},
+		{"unanimous", []DataPoint{
+			point("A", map[string]float64{"slice": 1}),
+			point("A", map[string]float64{"append": 1}),
+		}, 3, 0.9, "A", 0},
+		{"only the k nearest vote", []DataPoint{
+			point("B", map[string]float64{"slice": 1, "append": 0.5}),
+			point("A", map[string]float64{"slice": 1, "append": 1}),
+			point("B", map[string]float64{"slice": 1, "append": 0.4}),
+		}, 1, 0.9, "A", 0},
+		{"tie goes to the nearest", []DataPoint{
+			point("B", map[string]float64{"slice": 1, "append": 0.5}),
+			point("A", map[string]float64{"slice": 1, "append": 1}),
+		}, 2, 0.9, "A", 1},
+		{"scale does not matter", []DataPoint{
+			point("A", map[string]float64{"slice": 10, "append": 10}),
+			point("B", map[string]float64{"slice": 0.1, "append": 0.05}),
+		}, 1, 0.9, "A", 0},
+		{"too far", []DataPoint{
+			point("A", map[string]float64{"map": 1}),
+			point("B", map[string]float64{"slice": 1, "map": 3}),
+		}, 3, 0.9, "", 0},
+		{"far points do not vote", []DataPoint{
+			point("A", map[string]float64{"slice": 1, "append": 1}),
+			point("B", map[string]float64{"map": 1}),
+			point("B", map[string]float64{"channel": 1}),
+		}, 3, 0.9, "A", 0},
+		{"empty vectors skipped", []DataPoint{
+			point("A", nil),
+			point("B", map[string]float64{"slice": 1}),
+		}, 3, 1, "B", 0},
+		{"no data", nil, 3, 0.9, "", 0},
+	}
+	for _, tt := range tests {
+		got, runnerUp := KNN(query, tt.dataset, tt.k, tt.maxDistance)
+		if got != tt.want || runnerUp != tt.wantRunnerUp {
+			t.Errorf("%s: KNN = %q, %v; want %q, %v", tt.name, got, runnerUp, tt.want, tt.wantRunnerUp)
+		}
+	}
+}
+
+// TestTrainedAnswers checks that answers trained through /train are given
+// for the query they were trained on and close rewordings of it, and that
+// other queries are still answered from the corpus rather than by the
+// nearest trained answer, however far.
+func TestTrainedAnswers(t *testing.T) {
+	loadTestModel(t)
+	saved := dataset
+	defer func() {
+		dataset = saved
+		retrainTFIDFModel()
+	}()
+	const reverse = "Reverse a slice by swapping its ends until they meet."
+	const sortMap = "Copy the keys into a slice and sort it with sort.Slice."
+	dataset = []DataPoint{
+		{Query: "how do I reverse a slice", Answer: reverse},
+		{Query: "how do I sort a map by value", Answer: sortMap},
+	}
+	retrainTFIDFModel()
+
+	tests := []struct {
+		query string
+		want  string // Empty when KNN should not answer
+	}{
+		{"how do I reverse a slice", reverse},
+		{"so how do I reverse a slice in place", reverse},
+		{"how do I sort a map by value", sortMap},
+		{"what is a goroutine", ""},
+		{"explain interfaces", ""},
+	}
+	for _, tt := range tests {
+		modelMu.RLock()
+		got, _ := handleUserInput(tt.query)
+		modelMu.RUnlock()
+		if (tt.want == "" && got != "") || !strings.HasPrefix(got, tt.want) {
+			t.Errorf("handleUserInput(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// Queries KNN leaves alone still get an answer from the corpus
+	result := Answer(context.Background(), Query{Text: "what is a goroutine"})
+	if result.Fallback || strings.Contains(result.Answer, reverse) || strings.Contains(result.Answer, sortMap) {
+		t.Errorf("Answer(what is a goroutine) = %q, fallback %v; want a corpus answer", result.Answer, result.Fallback)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Reasons a query is queued for labelling.
+const (
+	reasonLowRating     = "low_rating"     // A user rated the answer poorly
+	reasonFallback      = "fallback"       // Nothing could answer it
+	reasonLowConfidence = "low_confidence" // The intent matched with low confidence
+	reasonNearTie       = "near_tie"       // The runner-up answer scored nearly as well
+)
+
+// reasonWeights rank the reasons when prioritising the queue. Users'
+// ratings count most, then answers the bot could not give at all.
+var reasonWeights = map[string]float64{
+	reasonLowRating:     3,
+	reasonFallback:      2,
+	reasonLowConfidence: 1,
+	reasonNearTie:       1,
+}
+
+// Statuses of a Label.
+const (
+	labelLabelled  = "labelled"
+	labelDismissed = "dismissed"
+)
+
+// Size of the labelling queue returned unless the caller asks otherwise.
+const (
+	defaultLabelQueueLimit = 50
+	maxLabelQueueLimit     = 500
+)
+
+// Candidate turns scanned per task returned. Popular queries repeat, so
+// more turns than tasks are needed to fill the queue.
+const labelScanFactor = 20
+
+// LabelTask is a query waiting for a trainer, built from every recent turn
+// that asked it.
+type LabelTask struct {
+	Query      string   `json:"query"`
+	Reasons    []string `json:"reasons"`
+	Priority   float64  `json:"priority"`
+	Asked      int      `json:"asked"`   // Candidate turns with this query
+	TurnID     string   `json:"turn_id"` // The most recent of them, whose answer is shown
+	Response   string   `json:"response"`
+	Intent     string   `json:"intent"`
+	Confidence float64  `json:"confidence"`
+	Section    string   `json:"section"`
+	RunnerUp   float64  `json:"runner_up"`
+	MinRating  int      `json:"min_rating,omitempty"` // Lowest rating of any of the turns, 0 if unrated
+}
+
+// labelReasons lists why a candidate turn is worth labelling under c.
+func labelReasons(lc LabelCandidate, c LabellingConfig) []string {
+	var reasons []string
+	if lc.MinRating > 0 && lc.MinRating <= c.MaxRating {
+		reasons = append(reasons, reasonLowRating)
+	}
+	if lc.Turn.Fallback {
+		reasons = append(reasons, reasonFallback)
+	}
+	if lc.Turn.Intent != "" && lc.Turn.Confidence < c.MinConfidence {
+		reasons = append(reasons, reasonLowConfidence)
+	}
+	if lc.Turn.RunnerUp >= c.TieRatio {
+		reasons = append(reasons, reasonNearTie)
+	}
+	return reasons
+}
+
+// buildLabelQueue groups candidates, most recent first, by query and
+// orders the tasks by priority: the weights of their reasons, scaled up
+// for queries asked more often.
+func buildLabelQueue(candidates []LabelCandidate, c LabellingConfig, limit int) []LabelTask {
+	var tasks []LabelTask
+	index := make(map[string]int)
+	for _, lc := range candidates {
+		i, ok := index[lc.Turn.Query]
+		if !ok {
+			i = len(tasks)
+			index[lc.Turn.Query] = i
+			t := lc.Turn
+			tasks = append(tasks, LabelTask{
+				Query: t.Query, TurnID: t.ID, Response: t.Response, Intent: t.Intent,
+				Confidence: t.Confidence, Section: t.Section, RunnerUp: t.RunnerUp,
+			})
+		}
+		task := &tasks[i]
+		task.Asked++
+		if lc.MinRating > 0 && (task.MinRating == 0 || lc.MinRating < task.MinRating) {
+			task.MinRating = lc.MinRating
+		}
+		for _, reason := range labelReasons(lc, c) {
+			if !slices.Contains(task.Reasons, reason) {
+				task.Reasons = append(task.Reasons, reason)
+			}
+		}
+	}
+
+	for i := range tasks {
+		weight := 0.0
+		for _, reason := range tasks[i].Reasons {
+			weight += reasonWeights[reason]
+		}
+		tasks[i].Priority = weight * (1 + math.Log(float64(tasks[i].Asked)))
+		sort.Slice(tasks[i].Reasons, func(a, b int) bool {
+			return reasonWeights[tasks[i].Reasons[a]] > reasonWeights[tasks[i].Reasons[b]]
+		})
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Priority > tasks[j].Priority })
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks
+}
+
+// labelQueue returns up to limit queries most worth labelling.
+func labelQueue(ctx context.Context, limit int) ([]LabelTask, error) {
+	c := config.Labelling
+	candidates, err := store.LabelCandidates(ctx, LabelCriteria{
+		MinConfidence: c.MinConfidence,
+		TieRatio:      c.TieRatio,
+		MaxRating:     c.MaxRating,
+		Limit:         limit * labelScanFactor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buildLabelQueue(candidates, c, limit), nil
+}
+
+// sectionByTitle returns the corpus section titled title, ignoring case.
+// The caller holds modelMu.
+func sectionByTitle(title string) (Section, bool) {
+	for _, section := range corpusSections {
+		if strings.EqualFold(section.Title, title) {
+			return section, true
+		}
+	}
+	return Section{}, false
+}
+
+// applyLabel stores a label and trains the bot with its answer, as /train
+// does, so the next retraining run picks it up too.
+func applyLabel(ctx context.Context, label Label) error {
+	if err := store.SaveLabel(ctx, label); err != nil {
+		return err
+	}
+	if label.Status == labelLabelled {
+		train(TrainingData{Query: label.Query, Answer: label.Answer})
+	}
+	return nil
+}
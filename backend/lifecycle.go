@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Timeouts applied unless overridden in ServerConfig.
+const (
+	defaultReadTimeout     = 15 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 20 * time.Second
+
+	// Bound on reading request headers, so slow clients cannot hold connections
+	readHeaderTimeout = 5 * time.Second
+)
+
+// Close code and reason sent to WebSocket clients when the server stops.
+const shutdownCloseReason = "server is shutting down"
+
+// scheduler runs background jobs until it is stopped.
+type scheduler struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newScheduler() *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{ctx: ctx, cancel: cancel}
+}
+
+// Every runs job every interval. A zero interval disables the job.
+func (s *scheduler) Every(interval time.Duration, job func()) {
+	if interval <= 0 {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				job()
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop prevents further runs and waits for running jobs to finish, or for
+// ctx to end.
+func (s *scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+	return waitContext(ctx, s.wg.Wait)
+}
+
+// wsClient is an open WebSocket connection tracked for shutdown.
+type wsClient struct {
+	conn     *websocket.Conn
+	writer   *connWriter
+	streams  *streamRegistry
+	draining atomic.Bool // Set once the server starts shutting down
+}
+
+// track registers an open connection. It returns false once the server
+// is draining, when the connection must be closed instead.
+func (s *Server) track(client *wsClient) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.clients[client] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+// untrack removes a connection whose handler is returning.
+func (s *Server) untrack(client *wsClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, client)
+	s.handlers.Done()
+}
+
+// drainWebSockets lets in-flight responses finish, sends every client a
+// close frame and waits for the clients to close their connections.
+// Connections still open when ctx ends are closed forcibly. No connection
+// is tracked once draining starts, so handlers.Add never races the Wait.
+func (s *Server) drainWebSockets(ctx context.Context) {
+	s.mu.Lock()
+	s.draining = true
+	clients := make([]*wsClient, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.Unlock()
+
+	for _, client := range clients {
+		client.draining.Store(true)
+		go func(client *wsClient) {
+			if err := waitContext(ctx, client.streams.Wait); err != nil {
+				client.streams.CancelAll()
+			}
+			client.writer.SendClose(websocket.CloseGoingAway, shutdownCloseReason)
+		}(client)
+	}
+
+	if err := waitContext(ctx, s.handlers.Wait); err != nil {
+		s.mu.Lock()
+		slog.Warn("Closing WebSocket connections that did not close in time", "count", len(s.clients))
+		for client := range s.clients {
+			client.conn.Close()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// serve runs httpServer until SIGINT or SIGTERM, then shuts down in order:
+// stop accepting requests, drain WebSockets, stop background jobs, flush
+// queued writes and close the store.
+func (s *Server) serve(httpServer *http.Server, jobs *scheduler) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-errs:
+		return err
+	case <-signals.Done():
+	}
+	stop() // A second signal kills the process immediately
+	slog.Info("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Server.ShutdownTimeout))
+	defer cancel()
+
+	// Hijacked WebSocket connections are not covered by Shutdown
+	drained := make(chan struct{})
+	httpServer.RegisterOnShutdown(func() {
+		s.drainWebSockets(ctx)
+		close(drained)
+	})
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Error("Error shutting down HTTP server", "err", err)
+	}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	if err := jobs.Stop(ctx); err != nil {
+		slog.Warn("Background jobs did not stop in time", "err", err)
+	}
+	// Writes that do not flush in time are spilled for the next start
+	if err := writeQueue.Close(ctx); err != nil {
+		slog.Error("Error flushing writes", "err", err)
+	}
+	if err := store.Close(); err != nil {
+		slog.Error("Error closing store", "err", err)
+	}
+	slog.Info("Shutdown complete")
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// waitContext runs wait and returns when it does, or with ctx.Err() when
+// ctx ends first.
+func waitContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats accepted by LogConfig.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Attribute keys used to correlate log lines.
+const (
+	logConn    = "conn"    // WebSocket connection
+	logSession = "session" // Conversation
+	logMessage = "msg"     // Client message id
+	logRequest = "request" // REST request
+	logTurn    = "turn"    // Stored turn
+)
+
+// parseLogLevel maps a level name to its slog.Level.
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// setupLogging installs the default logger described by c. Output from the
+// standard log package goes through it too.
+func setupLogging(c LogConfig, out io.Writer) {
+	level, _ := parseLogLevel(c.Level) // Validated with the rest of the config
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewTextHandler(out, options)
+	if c.Format == logFormatJSON {
+		handler = slog.NewJSONHandler(out, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newLogID returns a short random identifier for correlating log lines.
+func newLogID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// queryAttr logs user query text, or only its length and a fingerprint
+// when log.redact_queries is set. Equal queries share a fingerprint.
+func queryAttr(query string) slog.Attr {
+	if !config.Log.RedactQueries {
+		return slog.String("query", query)
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(query)))
+	return slog.String("query", fmt.Sprintf("[redacted len=%d sha256=%x]", len(query), sum[:4]))
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying logger.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger carried by ctx, or the default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -2,22 +2,33 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"math"
+	"net"
 	"net/http"
 	"os"
-	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var upgrader = websocket.Upgrader{}
-
 var discoveredIntents map[string][]string // Holds potential new intents and their associated phrases
 
-var programmingTerms = make(map[string][]string) // Dynamically defined programming terms
+var discoveredIntentsMu sync.Mutex // Guards discoveredIntents, which is updated by concurrent requests
+
+var programmingTerms = make(map[string]TermDefinition) // Programming terms mined from the keywords and corpus, by termKey
+
+var linker *entityLinker // Links the entities queries mention to keywords and sections
 
 // Intent struct for intent classification
 type Intent struct {
@@ -25,9 +36,8 @@ type Intent struct {
 	TrainingPhrases []string
 }
 
-const exampleThreshold = 3
-
-var intents = []Intent{
+// Intents every model starts with, before those learned from the corpus.
+var builtinIntents = []Intent{
 	{
 		Name:            "greeting",
 		TrainingPhrases: []string{"hello", "hi", "how are you", "good morning", "hey"},
@@ -43,6 +53,8 @@ var intents = []Intent{
 	// Add more intents as needed...
 }
 
+var intents []Intent // Intents of the installed model
+
 // Handle new training data
 type TrainingData struct {
 	Query  string `json:"query"`
@@ -50,17 +62,12 @@ type TrainingData struct {
 }
 
 type Feedback struct {
+	TurnID   string `json:"turn_id,omitempty"` // Turn being rated, if the client knows it
 	Query    string `json:"query"`
 	Response string `json:"response"`
 	Rating   int    `json:"rating"`
 }
 
-type KeywordEntity struct {
-	Name        string
-	Description string
-	Category    string
-}
-
 var (
 	corpus              []string
 	corpusKeywords      map[string]float64
@@ -69,249 +76,185 @@ var (
 )
 
 func initialize() {
-	// Initialize discovered intents
-	discoveredIntents = make(map[string][]string)
-
 	connectDatabase()
 
-	// Load programming keywords
-	err := loadProgrammingKeywords("Go_keyword_entities.txt")
-	if err != nil {
-		log.Fatal("Error loading programming keywords:", err)
-	}
-
-	// Load any existing discovered intents from the database
-	loadDiscoveredIntents()
-
-	// Load programming concepts from the corpus
-	err = loadCorpusConcepts("go_corpus.md")
-	if err != nil {
-		log.Fatal("Error loading corpus concepts:", err)
-	}
-
-	// Load the existing corpus of training phrases
-	corpus, err = LoadCorpus("go_corpus.md")
-	if err != nil {
-		log.Fatal("Error loading corpus:", err)
-	}
-
-	// Create the TF-IDF model
-	tfidf = NewTFIDF(corpus)
-
-	// Declare a map to store the TF-IDF vectors
-	vectors := make(map[string]float64)
-
-	// Calculate and store TF-IDF vectors for each document
-	for _, doc := range corpus {
-		vector := tfidf.CalculateVector(doc)
-
-		// Store the vectors in the map
-		for word, value := range vector {
-			vectors[word] = value // Store each word and its corresponding TF-IDF value
-		}
-	}
-
-	// Extract keywords from the corpus
-	corpusKeywords = tfidf.ExtractKeywords(corpus, 20) // Adjust top N as necessary
-
-	// Dynamically initialize programming terms from the corpus
-	initializeProgrammingTerms(corpus)
-
-	// Extract new intents from phrases in the corpus
-	extractNewIntentsFromCorpus(corpus)
-}
-
-func loadProgrammingKeywords(filename string) error {
-	programmingKeywords = make(map[string]KeywordEntity)
-	file, err := os.Open(filename)
+	// Build the model on the intents discovered so far, as stored
+	model, err := loadModel(config.Model, storedDiscoveredIntents())
 	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	currentCategory := ""
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Ignore empty lines
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		// Check for keyword categories
-		if strings.HasPrefix(line, "Control Flow Keywords") ||
-			strings.HasPrefix(line, "Function and Variable Keywords") ||
-			strings.HasPrefix(line, "Data Structure Keywords") {
-			currentCategory = line
-			continue
-		}
-
-		// Each keyword line format: keyword - description
-		if strings.Contains(line, "-") {
-			parts := strings.SplitN(line, "-", 2)
-			if len(parts) == 2 {
-				keyword := strings.TrimSpace(parts[0])
-				description := strings.TrimSpace(parts[1])
-				programmingKeywords[keyword] = KeywordEntity{
-					Name:        keyword,
-					Description: description,
-					Category:    currentCategory,
-				}
-			}
-		}
+		fatal("Error loading model", "err", err)
 	}
-
-	return scanner.Err()
+	model.install()
+	slog.Info("Model loaded", "version", modelVersion)
+	publishModel(modelVersion, intents)
 }
 
 // Server holds all lobbies.
 type Server struct {
 	upgrader websocket.Upgrader
+	auth     Authenticator // nil when no credentials are configured
+	limits   *limiters
+
+	mu       sync.Mutex
+	clients  map[*wsClient]struct{} // Open WebSocket connections
+	handlers sync.WaitGroup         // Running WebSocket handlers
+	draining bool                   // Set once shutdown starts; no more connections are tracked
 }
 
 // Initialize a new server.
 func newServer() *Server {
 	return &Server{
+		clients: make(map[*wsClient]struct{}),
+		auth:    newAuthenticator(config.Auth),
+		limits:  newLimiters(config.Limits),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all connections for simplicity
-			},
+			CheckOrigin:     checkOrigin(config.Server.AllowedOrigins),
 		},
 	}
 }
 func main() {
 
-	// init the corpus and supporting data
-	initialize()
-
-	// Start the validation loop for new intents
-	// go func() {
-	// 	for range time.Tick(time.Minute) { // Check every minute
-	// 		validateNewIntents()
-	// 	}
-	// }()
-
-	// Set up a Go routine that runs at defined intervals to retrain based on feedback
-	// go func() {
-	// 	for range time.Tick(time.Hour) { // Adjust to your preferred interval
-	// 		retrainModelBasedOnFeedback()
-	// 	}
-	// }()
-
-	server := newServer()
-
-	// Handle WebSocket connections
-	http.HandleFunc("/ws", server.handleWebSocket)
-
-	// Serve static files
-	http.Handle("/", http.FileServer(http.Dir("../frontend")))
+	// Resolve the configuration from defaults, config file, environment and flags
+	var args []string
+	var err error
+	config, args, err = loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		// Logging is not set up yet; list the problems one per line
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	setupLogging(config.Log, os.Stderr)
 
-	// Handle training requests
-	http.HandleFunc("/train", server.handleTraining) // Use HandleFunc for POST method checking
+	// "migrate up|down|status" manages the database schema without starting the server
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(args[1:]); err != nil {
+			fatal("Migration failed", "err", err)
+		}
+		return
+	}
 
-	log.Println("Server started on :8080")
-	err := http.ListenAndServe(":8080", nil) // Start listening on port 8080
-	if err != nil {
-		log.Fatal("ListenAndServe: ", err) // Log any errors starting the server
+	// "eval golden.jsonl" scores the answer pipeline against a golden set
+	if len(args) > 0 && args[0] == "eval" {
+		if err := runEvalCommand(args[1:]); err != nil {
+			fatal("Evaluation failed", "err", err)
+		}
+		return
 	}
-}
 
-func extractNewIntentsFromCorpus(corpus []string) {
-	for _, line := range corpus {
-		for keyword, entity := range programmingKeywords {
-			if strings.Contains(line, keyword) {
-				// Create new intents for each keyword found in the line
-				discoveredIntents[entity.Name] = append(discoveredIntents[entity.Name], line)
-			}
+	// "replay" answers logged queries with the current and a candidate model
+	if len(args) > 0 && args[0] == "replay" {
+		if err := runReplayCommand(args[1:]); err != nil {
+			fatal("Replay failed", "err", err)
 		}
+		return
 	}
 
-	// Adding discovered intents to intents array if they meet the threshold
-	for intentName, phrases := range discoveredIntents {
-		if len(phrases) >= exampleThreshold { // Only create intents with enough training data
-			intents = append(intents, Intent{Name: intentName, TrainingPhrases: phrases})
+	// "keywords convert Go_Keyword_Entities.txt" turns the legacy text format into YAML
+	if len(args) > 0 && args[0] == "keywords" {
+		if err := runKeywordsCommand(args[1:]); err != nil {
+			fatal("Keywords command failed", "err", err)
 		}
+		return
 	}
-}
 
-// Function to initialize programming terms dynamically from the corpus
-func initializeProgrammingTerms(corpus []string) {
-	for _, line := range corpus {
-		// Extract potential programming terms using regex heuristic
-		terms := extractProgrammingTerms(line)
-		for _, term := range terms {
-			// Add the term to the dictionary with a placeholder description
-			programmingTerms[term] = []string{"No description available yet."} // Placeholder
+	// "token -subject name -role trainer" prints a signed token for clients
+	if len(args) > 0 && args[0] == "token" {
+		if err := runTokenCommand(args[1:]); err != nil {
+			fatal("Issuing token failed", "err", err)
 		}
+		return
 	}
-}
 
-// Extract potential programming terms from a line of text
-func extractProgrammingTerms(text string) []string {
-	var terms []string
+	// init the corpus and supporting data, watching its files from before they are read
+	watcher := newModelWatcher(config.Model)
+	initialize()
 
-	// Use regex to find capitalized words or common programming patterns
-	re := regexp.MustCompile(`\b([A-Z][a-zA-Z0-9]*)\b`) // Match capitalized words (likely class names, etc.)
-	matches := re.FindAllString(text, -1)
+	jobs := newScheduler()
 
-	// Add regex matches to terms
-	terms = append(terms, matches...)
+	// Reload the model when its files change
+	jobs.Every(time.Duration(config.Scheduler.ReloadInterval), watcher.check)
 
-	// Add programming keywords from the map
-	for keyword := range programmingKeywords {
-		if strings.Contains(text, keyword) {
-			terms = append(terms, keyword) // Add keyword if found in text
-		}
-	}
+	// Start the validation loop for new intents
+	jobs.Every(time.Duration(config.Scheduler.ValidateIntentsInterval), validateNewIntents)
 
-	return terms
-}
+	// Retrain at defined intervals based on feedback
+	jobs.Every(time.Duration(config.Scheduler.RetrainInterval), retrainModelBasedOnFeedback)
 
-// Load programming concepts from the corpus
-func loadCorpusConcepts(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
+	server := newServer()
+	if server.auth == nil {
+		slog.Warn("No API keys or token secret are configured; /train and /admin will refuse every request")
 	}
-	defer file.Close()
 
-	var currentSection string
+	// Handle WebSocket connections
+	http.HandleFunc("/ws", server.requireChat(server.handleWebSocket))
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
+	// Serve static files
+	http.Handle("/", http.FileServer(http.Dir(config.Server.FrontendDir)))
 
-		if strings.HasPrefix(line, "##") {
-			if currentSection != "" {
-				programmingTerms[currentSection] = []string{"Definition or description not explicitly detailed."}
-			}
-			currentSection = strings.TrimSpace(line[2:])
-		}
-	}
-	if currentSection != "" {
-		programmingTerms[currentSection] = []string{"Definition or description not explicitly detailed."}
+	// Handle training requests
+	http.HandleFunc("/train", server.requireRole(roleTrainer, limitRate(server.limits.train, limitTrain, server.handleTraining))) // Use HandleFunc for POST method checking
+
+	// Prometheus metrics
+	http.Handle("/metrics", promhttp.Handler())
+
+	// REST API sharing the WebSocket answer pipeline
+	server.registerAPIRoutes(http.DefaultServeMux)
+	server.registerAdminRoutes(http.DefaultServeMux)
+
+	httpServer := &http.Server{
+		Addr:              config.Server.Addr,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       time.Duration(config.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(config.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(config.Server.IdleTimeout),
 	}
 
-	return scanner.Err()
+	// Serve until SIGINT or SIGTERM, then drain connections and flush writes
+	slog.Info("Server started", "addr", config.Server.Addr)
+	err = server.serve(httpServer, jobs)
+	if err != nil {
+		fatal("Server failed", "err", err) // Log any errors starting the server
+	}
 }
 
-// Extract entities based on dictionary lookup
-func extractEntitiesAdvanced(query string) []string {
-	entities := make([]string, 0)
-	words := strings.Fields(strings.ToLower(query))
+// extractNewIntentsFromCorpus adds the corpus lines that mention each
+// keyword to the discovered intent named after it, and returns intents
+// with the discovered intents that have enough phrases appended. Keywords
+// and intents are taken in sorted order, so the same files always give the
+// same intents in the same order.
+func extractNewIntentsFromCorpus(corpus []string, keywords map[string]KeywordEntity, discovered map[string][]string, intents []Intent) []Intent {
+	names := make([]string, 0, len(keywords))
+	for keyword := range keywords {
+		names = append(names, keyword)
+	}
+	slices.Sort(names)
+	for _, line := range corpus {
+		for _, keyword := range names {
+			if strings.Contains(line, keyword) {
+				// Create new intents for each keyword found in the line
+				name := keywords[keyword].Name
+				discovered[name] = append(discovered[name], line)
+			}
+		}
+	}
 
-	// Check each word in the query against the dynamically defined programming terms
-	for _, word := range words {
-		if relatedEntities, exists := programmingTerms[word]; exists {
-			entities = append(entities, word)               // Add the key term
-			entities = append(entities, relatedEntities...) // Add related terms
+	// Adding discovered intents to intents array if they meet the threshold
+	names = names[:0]
+	for intentName := range discovered {
+		names = append(names, intentName)
+	}
+	slices.Sort(names)
+	for _, intentName := range names {
+		phrases := discovered[intentName]
+		if len(phrases) >= config.Intents.MinExamples { // Only create intents with enough training data
+			intents = append(intents, Intent{Name: intentName, TrainingPhrases: phrases})
 		}
 	}
-	return entities
+	return intents
 }
 
 // Function to extract noun phrases
@@ -320,6 +263,7 @@ func extractNounPhrases(query string) []string {
 	nounPhrases := make([]string, 0)
 
 	for _, word := range words {
+		word = strings.Trim(word, ".,;:!?\"'()")
 		if isProgrammingTerm(word) {
 			nounPhrases = append(nounPhrases, word)
 		}
@@ -328,24 +272,24 @@ func extractNounPhrases(query string) []string {
 	return nounPhrases
 }
 
-// Function to check if a word is a recognized programming term
+// Function to check if a word is a defined programming term
 func isProgrammingTerm(word string) bool {
-	// Check against dynamically loaded programmingTerms map
-	if _, exists := programmingTerms[word]; exists {
-		return true
-	}
-	return false
+	_, exists := findTerm(word)
+	return exists
 }
 
-// Function to generate responses based on extracted noun phrases
+// Function to generate responses based on extracted noun phrases. Only
+// terms with a definition are described, each once.
 func generateResponseFromNounPhrases(nounPhrases []string) string {
 	var responses []string
+	described := make(map[string]bool)
 	for _, nounPhrase := range nounPhrases {
-		if len(programmingTerms[nounPhrase]) > 0 {
-			responses = append(responses, programmingTerms[nounPhrase][0]) // Access the first description for that term
-		} else {
-			responses = append(responses, "I'm sorry, but I do not have information on: "+nounPhrase)
+		definition, ok := findTerm(nounPhrase)
+		if !ok || described[termKey(definition.Term)] {
+			continue
 		}
+		described[termKey(definition.Term)] = true
+		responses = append(responses, definition.Term+": "+definition.Definition)
 	}
 	if len(responses) > 0 {
 		return strings.Join(responses, "\n") // Join the responses for clarity
@@ -354,83 +298,71 @@ func generateResponseFromNounPhrases(nounPhrases []string) string {
 }
 
 func retrainModelBasedOnFeedback() {
-	log.Println("Retraining model based on collected feedback and interactions.")
+	slog.Info("Retraining model based on collected feedback and interactions")
+	defer func(start time.Time) { retrainDuration.Observe(time.Since(start).Seconds()) }(time.Now())
 
 	// Fetch interaction logs from the database
-	rows, err := db.Query("SELECT query, response FROM interactions")
+	interactions, err := store.Interactions(context.Background())
 	if err != nil {
-		log.Println("Error fetching interaction logs from database:", err)
+		dbErrors.WithLabelValues("interactions").Inc()
+		slog.Error("Error fetching interaction logs from database", "err", err)
 		return
 	}
-	defer rows.Close()
 
 	var feedbackCorpus []string
-	for rows.Next() {
-		var query, response string
-		if err := rows.Scan(&query, &response); err != nil {
-			log.Println("Error scanning interaction log:", err)
-			continue
-		}
+	for _, interaction := range interactions {
 		// Prepare the feedback corpus
-		feedbackCorpus = append(feedbackCorpus, query, response)
+		feedbackCorpus = append(feedbackCorpus, interaction.Query, interaction.Response)
 	}
 
-	// Load the existing corpus of training phrases
-	corpus, err := LoadCorpus("go_corpus.md")
-	if err != nil {
-		log.Fatal("Error loading corpus:", err)
-	}
-
-	// Combine the feedback corpus with the existing corpus
-	corpus = append(corpus, feedbackCorpus...)
+	// Combine the feedback corpus with the installed corpus
+	modelMu.RLock()
+	corpus := append(slices.Clip(installedModel().corpus), feedbackCorpus...)
+	modelMu.RUnlock()
 
 	// Create a new TF-IDF model based on the updated corpus
 	tfidf := NewTFIDF(corpus)
 
 	// Recalculate TF-IDF vectors for the dataset
-	for i := range dataset {
-		dataset[i].Vector = tfidf.CalculateVector(dataset[i].Answer) // Recalculate vectors for each existing dataset entry
-	}
+	revectorizeDataset(tfidf)
 
-	log.Println("Model retraining completed successfully.")
+	slog.Info("Model retraining completed", "documents", len(corpus))
 }
 
-// Load existing discovered intents from the database
-func loadDiscoveredIntents() {
-	rows, err := db.Query("SELECT intent_name, training_phrases FROM discovered_intents")
+// storedDiscoveredIntents loads the discovered intents from the database.
+// A model is still built without them if they cannot be read.
+func storedDiscoveredIntents() map[string][]string {
+	stored, err := store.DiscoveredIntents(context.Background())
 	if err != nil {
-		log.Println("Error loading discovered intents from database:", err)
-		return
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var intentName string
-		var trainingPhrases string
-
-		if err := rows.Scan(&intentName, &trainingPhrases); err != nil {
-			log.Println("Error scanning discovered intent:", err)
-			continue
-		}
-
-		// Split training phrases and assign to the discovered intents map
-		phrases := strings.Split(trainingPhrases, ";") // Assuming semicolon separation
-		discoveredIntents[intentName] = phrases
+		dbErrors.WithLabelValues("discovered_intents").Inc()
+		slog.Error("Error loading discovered intents from database", "err", err)
+		return map[string][]string{}
 	}
+	return stored
 }
 
-func handleUserInput(query string) string {
-
-	// Initialize on the first user input if not already done
-	if corpus == nil {
-		initialize()
-	}
+// handleUserInput answers query from the KNN dataset, listing the corpus
+// keywords it mentions. It also returns how close the runner-up answer came,
+// as reported by KNN.
+func handleUserInput(query string) (string, float64) {
+	start := time.Now()
+	words := tokenize(query)
+	observeStage(stageTokenise, start)
 
-	queryVec := tfidf.CalculateVector(query)
+	start = time.Now()
+	queryVec := tfidf.Vectorize(words)
+	observeStage(stageVectorise, start)
 
 	// TODO: this data set is empty I think we need to calulate vectors for the corpus
 	// Get response using KNN
-	response := KNN(queryVec, dataset, 3) // Adjust k as needed
+	start = time.Now()
+	response, runnerUp := KNN(queryVec, dataset, config.Retrieval.K, config.Retrieval.KNNMaxDistance)
+	observeStage(stageKNN, start)
+	if response == "" {
+		// Keywords alone are no answer; the caller falls back to the sections
+		return "", 0
+	}
+
 	// Check if the query contains any extracted keywords
 	var relatedKeywords []string
 	for term := range corpusKeywords {
@@ -444,7 +376,7 @@ func handleUserInput(query string) string {
 		response += "\n\nRelated Keywords: " + strings.Join(relatedKeywords, ", ")
 	}
 
-	return response // Return the final response
+	return response, runnerUp // Return the final response
 }
 
 // LoadCorpus loads the corpus from a text file and returns a slice of strings.
@@ -476,101 +408,177 @@ func (s *Server) handleTraining(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var data TrainingData
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	train(data)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// train adds a query/answer pair to the KNN dataset and stores it for the
+// next retraining run.
+func train(data TrainingData) {
 	// Add it to dataset and retrain
-	dataset = append(dataset, DataPoint{Vector: nil, Answer: data.Answer})
+	modelMu.Lock()
+	dataset = append(slices.Clip(dataset), DataPoint{Query: data.Query, Answer: data.Answer})
+	modelMu.Unlock()
 	retrainTFIDFModel()        // Function implementation needed
 	saveTrainingDataToDB(data) // Persist to DB if needed
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
 // Handle the websocket interaction for user queries
-func (s Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error during connection upgrade:", err)
+		slog.Warn("Error during connection upgrade", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	websocketConnections.Inc()
+	defer websocketConnections.Dec()
+
+	// Each connection carries its own conversational context
+	session := newSession()
+	logger := slog.With(logConn, newLogID(), logSession, session.ID)
+	if principal, ok := principalFrom(r.Context()); ok {
+		logger = logger.With("principal", principal.Subject)
+	}
+	logger.Debug("WebSocket connected", "remote", r.RemoteAddr)
+
+	// All writes go through a single writer goroutine so responses can stream concurrently
+	writer := newConnWriter(conn, logger, time.Duration(config.Server.WriteTimeout))
+
+	// Clients are limited by API key or token subject when known, else by IP
+	client := clientKey(r)
+	if !s.limits.connections.Acquire(client) {
+		limitViolations.WithLabelValues(limitConnections).Inc()
+		logger.Warn("Too many connections", "client", client)
+		writer.Send(newErrorMessage(&ProtocolError{Code: errTooManyConnections,
+			Detail: fmt.Sprintf("at most %d connections are allowed per client", config.Limits.MaxConnectionsPerClient)}))
+		writer.SendClose(websocket.ClosePolicyViolation, "too many connections")
+		writer.Close()
+		conn.Close()
 		return
 	}
-	defer conn.Close()
+	defer s.limits.connections.Release(client)
+	streams := newStreamRegistry()
+	tracked := &wsClient{conn: conn, writer: writer, streams: streams}
+	if !s.track(tracked) {
+		// Upgraded while the server started shutting down
+		writer.SendClose(websocket.CloseGoingAway, shutdownCloseReason)
+		writer.Close()
+		conn.Close()
+		return
+	}
+	defer func() {
+		streams.CancelAll()
+		conn.Close() // Unblocks the writer if the client stopped reading
+		streams.Wait()
+		writer.Close()
+		s.untrack(tracked)
+	}()
+
+	saveSession(session, transportWebSocket)
+	idleTimeout := time.Duration(config.Server.SessionIdleTimeout)
+
+	maxSize := config.Limits.MaxMessageSize
+	conn.SetReadLimit(int64(maxSize) * hardReadLimitFactor)
+	wsIdle := time.Duration(config.Limits.WebSocketIdleTimeout)
 
 	for {
-		var msg map[string]interface{}
-		err := conn.ReadJSON(&msg)
-		if err != nil {
-			log.Println("Error on read:", err)
+		conn.SetReadDeadline(time.Now().Add(wsIdle))
+		data, err := readMessage(conn, maxSize)
+		if errors.Is(err, errMessageTooBig) {
+			limitViolations.WithLabelValues(limitMessageSize).Inc()
+			logger.Warn("Rejected message", "code", errMessageTooLarge, "limit", maxSize)
+			writer.Send(newErrorMessage(&ProtocolError{Code: errMessageTooLarge, Detail: fmt.Sprintf("messages must not exceed %d bytes", maxSize)}))
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			limitViolations.WithLabelValues(limitIdle).Inc()
+			logger.Debug("Closing idle WebSocket", "timeout", wsIdle)
+			writer.Send(newErrorMessage(&ProtocolError{Code: errIdleTimeout, Detail: fmt.Sprintf("no message received for %s", wsIdle)}))
+			writer.SendClose(websocket.CloseNormalClosure, "idle timeout")
+			streams.CancelAll()
+			writer.Close() // Flush before the connection is closed below
 			break
 		}
-
-		switch msg["type"] {
-		case "query":
-			query := msg["query"].(string)
-
-			// Extract noun phrases and advanced entities from the query
-			nounPhrases := extractNounPhrases(query)
-			entities := extractEntitiesAdvanced(query)
-
-			// Use KNN to get relevant responses
-			knnResponse := handleUserInput(query) // Get response from KNN
-
-			// Prepare the final response
-			var finalResponse string
-
-			// Combine KNN response with recognized entities
-			if knnResponse != "" {
-				finalResponse = knnResponse
-				if len(entities) > 0 {
-					finalResponse += "\n\nRelated Topics: " + strings.Join(entities, ", ")
-				}
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Warn("Error on read", "err", err)
 			} else {
-				// If no KNN responses, generate responses based on noun phrases
-				finalResponse = generateResponseFromNounPhrases(nounPhrases)
+				logger.Debug("WebSocket closed", "err", err)
 			}
+			break
+		}
 
-			// Classify user intent
-			intent := classifyIntent(query)
+		// Malformed messages are reported back to the client without closing the connection
+		decoded, perr := decodeClientMessage(data)
+		if perr != nil {
+			websocketMessages.WithLabelValues("invalid").Inc()
+			logger.Warn("Rejected message", logMessage, perr.ID, "code", perr.Code, "detail", perr.Detail)
+			writer.Send(newErrorMessage(perr))
+			continue
+		}
 
-			if intent == "" { // Intent is not recognized
-				// Add the new query to discovered intents
-				aggregateDiscoveredIntents(query)
+		switch msg := decoded.(type) {
+		case *QueryMessage:
+			websocketMessages.WithLabelValues(typeQuery).Inc()
+			if tracked.draining.Load() {
+				writer.Send(newErrorMessage(&ProtocolError{ID: msg.ID, Code: errShuttingDown, Detail: "the server is shutting down; reconnect to continue"}))
+				continue
 			}
-
-			var response string
-
-			// Respond based on classified intent
-			switch intent {
-			case "greeting":
-				response = "Bot: Hello! How can I assist you today?"
-			case "farewell":
-				response = "Bot: Goodbye! Have a great day!"
-			case "help":
-				// Get response using KNN for help queries
-				response = finalResponse // Get response from KNN
-			default:
-				response = finalResponse // General response fallback
+			if ok, wait := s.limits.requests.Allow(client); !ok {
+				limitViolations.WithLabelValues(limitRequests).Inc()
+				writer.Send(newErrorMessage(rateLimited(msg.ID, wait)))
+				continue
+			}
+			if session.Expired(time.Now(), idleTimeout) {
+				logger.Info("Session expired after inactivity")
+				session.Reset()
 			}
 
-			// Send the response back to the client
-			err = conn.WriteJSON(map[string]string{"type": "response", "response": response})
-			if err != nil {
-				log.Println("Error on write:", err)
+			// The id is claimed before answering, so a duplicate leaves the
+			// session and the discovered intents untouched
+			ctx := withLogger(context.Background(), logger.With(logMessage, msg.ID))
+			result, ok := streams.Start(msg.ID, writer, func() Result {
+				return Answer(ctx, Query{Text: msg.Query, Session: session})
+			})
+			if !ok {
+				writer.Send(newErrorMessage(&ProtocolError{ID: msg.ID, Code: errDuplicateID, Detail: "a response with this id is already streaming"}))
+				continue
 			}
 
-			// Log the interaction after generating a response.
-			saveInteraction(query, response) // Log the interaction with query and response.
+			// Log the turn after generating a response.
+			saveTurn(session.ID, msg.Query, result)
+			session.Record(msg.Query, result.Answer, result.Entities)
+
+		case *CancelMessage:
+			websocketMessages.WithLabelValues(typeCancel).Inc()
+			logger.Debug("Cancel requested", logMessage, msg.ID, "target", msg.Target)
+			if !streams.Cancel(msg.Target) {
+				writer.Send(newErrorMessage(&ProtocolError{ID: msg.ID, Code: errNotInFlight, Detail: "no response with id " + msg.Target + " is streaming"}))
+			}
 
-		case "feedback":
-			// TODO: there is a type bug here with float 64
+		case *FeedbackMessage:
+			websocketMessages.WithLabelValues(typeFeedback).Inc()
+			if ok, wait := s.limits.requests.Allow(client); !ok {
+				limitViolations.WithLabelValues(limitRequests).Inc()
+				writer.Send(newErrorMessage(rateLimited(msg.ID, wait)))
+				continue
+			}
 			feedback := Feedback{
-				Query:    msg["query"].(string),
-				Response: msg["response"].(string),
-				Rating:   int(msg["rating"].(float64)),
+				TurnID:   msg.TurnID,
+				Query:    msg.Query,
+				Response: msg.Response,
+				Rating:   msg.Rating,
 			}
-			saveFeedbackToDB(feedback)                                         // Persist to feedback table
-			logInteraction(feedback.Query, feedback.Response, feedback.Rating) // Log interaction
+			logger.Debug("Feedback received", logMessage, msg.ID, logTurn, msg.TurnID, "rating", msg.Rating)
+			saveFeedbackToDB(feedback) // Persist to feedback table
+			writer.Send(FeedbackAckMessage{Envelope: newEnvelope(msg.ID, typeFeedbackAck)})
 		}
 	}
 }
@@ -583,10 +591,12 @@ func aggregateDiscoveredIntents(query string) {
 	clusterKey := findClusterKey(processedQuery)
 
 	// Add the query to the corresponding discovered intent cluster.
+	discoveredIntentsMu.Lock()
 	if _, exists := discoveredIntents[clusterKey]; !exists {
 		discoveredIntents[clusterKey] = []string{} // Initialize if doesn't exists
 	}
 	discoveredIntents[clusterKey] = append(discoveredIntents[clusterKey], processedQuery) // Add the new query
+	discoveredIntentsMu.Unlock()
 
 	// Persisting the new intent to the database
 	persistDiscoveredIntent(clusterKey, processedQuery)
@@ -602,12 +612,10 @@ func findClusterKey(query string) string {
 	return query // If no words, return the query itself
 }
 
-// Function to persist discovered intents into the database
-func persistDiscoveredIntent(intentName string, phrase string) {
-	_, err := db.Exec("INSERT INTO discovered_intents (intent_name, training_phrases) VALUES (?, ?) ON DUPLICATE KEY UPDATE training_phrases = CONCAT(training_phrases, ';', ?)", intentName, phrase, phrase)
-	if err != nil {
-		log.Println("Error saving discovered intent to database:", err)
-	}
+// persistDiscoveredIntent queues phrases of a discovered intent for the
+// database. It never waits on the store, as it runs while answering.
+func persistDiscoveredIntent(intentName string, phrases string) {
+	writeQueue.Enqueue(WriteOp{Kind: writeIntent, Intent: &IntentPhrases{Name: intentName, Phrases: phrases}})
 }
 
 // Helper function to return the minimum of two integers
@@ -620,9 +628,14 @@ func min(a, b int) int {
 
 // Validate new intents periodically
 func validateNewIntents() {
+	modelMu.Lock() // Promoted intents join the installed model
+	defer modelMu.Unlock()
+	discoveredIntentsMu.Lock()
+	defer discoveredIntentsMu.Unlock()
+
 	for intentKey, queries := range discoveredIntents {
-		if len(queries) >= exampleThreshold { // Set a threshold for how many examples define a new intent
-			//log.Println("New Intent Discovered:", intentKey, "with queries:", queries)
+		if len(queries) >= config.Intents.MinExamples { // Set a threshold for how many examples define a new intent
+			slog.Info("New intent discovered", "intent", intentKey, "examples", len(queries))
 
 			// Automatically create the new intent with existing phrases
 			newIntent := Intent{Name: intentKey, TrainingPhrases: queries}
@@ -637,15 +650,23 @@ func validateNewIntents() {
 	}
 }
 
+// saveFeedbackToDB queues a rating for the feedback table.
 func saveFeedbackToDB(feedback Feedback) {
-	_, err := db.Exec("INSERT INTO feedback(query, response, rating) VALUES(?, ?, ?)", feedback.Query, feedback.Response, feedback.Rating)
-	if err != nil {
-		log.Println("Error saving feedback:", err)
-	}
+	feedbackRatings.WithLabelValues(strconv.Itoa(feedback.Rating)).Inc()
+	writeQueue.Enqueue(WriteOp{Kind: writeFeedback, Feedback: &feedback})
 }
 
 // Function for intent classification
 func classifyIntent(query string) string {
+	intent, _ := classifyIntentWithConfidence(query)
+	return intent
+}
+
+// classifyIntentWithConfidence returns the best matching intent and its
+// cosine similarity to the query. It returns "" when no training phrase
+// shares a term with the query.
+func classifyIntentWithConfidence(query string) (string, float64) {
+	defer observeStage(stageClassify, time.Now())
 	preprocessedQuery := preprocessInput(query)
 
 	// Create a corpus from the intents' training phrases
@@ -673,7 +694,10 @@ func classifyIntent(query string) string {
 		}
 	}
 
-	return bestIntent
+	if highestSimilarity <= 0 {
+		return "", 0
+	}
+	return bestIntent, highestSimilarity
 }
 
 // Preprocess the user input (lowercase, etc.)
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Pipeline stages timed by stageDuration.
+const (
+	stageTokenise  = "tokenise"
+	stageVectorise = "vectorise"
+	stageKNN       = "knn"
+	stageClassify  = "classify"
+)
+
+// Metrics served at /metrics. All names share the gocodebot_ prefix.
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gocodebot_queries_total",
+		Help: "Answered queries by classified intent and whether the answer was a fallback.",
+	}, []string{"intent", "fallback"})
+
+	answerDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gocodebot_answer_duration_seconds",
+		Help:    "Time taken to answer a query through the whole pipeline.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gocodebot_stage_duration_seconds",
+		Help:    "Time spent in each retrieval stage.",
+		Buckets: prometheus.ExponentialBuckets(0.00005, 2, 16),
+	}, []string{"stage"})
+
+	websocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gocodebot_websocket_connections",
+		Help: "Open WebSocket connections.",
+	})
+
+	websocketMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gocodebot_websocket_messages_total",
+		Help: "WebSocket messages received, by message type; rejected messages are counted as \"invalid\".",
+	}, []string{"type"})
+
+	feedbackRatings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gocodebot_feedback_ratings_total",
+		Help: "Feedback received, by star rating.",
+	}, []string{"rating"})
+
+	dbErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gocodebot_db_errors_total",
+		Help: "Failed store operations, by operation.",
+	}, []string{"operation"})
+
+	limitViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gocodebot_limit_violations_total",
+		Help: "Requests and connections refused or closed by a limit, by limit.",
+	}, []string{"limit"})
+
+	retrainDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gocodebot_retrain_duration_seconds",
+		Help:    "Time taken to retrain the model from feedback.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+
+	modelReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gocodebot_model_reloads_total",
+		Help: "Reloads of the model after its files changed, by result: ok or error.",
+	}, []string{"result"})
+
+	modelInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gocodebot_model_info",
+		Help: "Always 1; the version label identifies the loaded model.",
+	}, []string{"version"})
+)
+
+func init() {
+	// The write-behind queue keeps its own counters; expose them as they are
+	writeCounter := func(name, help string, value func(WriteStats) uint64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			if writeQueue == nil {
+				return 0
+			}
+			return float64(value(writeQueue.Stats()))
+		})
+	}
+	writeCounter("gocodebot_writes_enqueued_total", "Writes accepted into the write-behind queue.", func(s WriteStats) uint64 { return s.Enqueued })
+	writeCounter("gocodebot_writes_blocked_total", "Enqueues that waited for room in the write-behind queue.", func(s WriteStats) uint64 { return s.Blocked })
+	writeCounter("gocodebot_writes_dropped_total", "Writes dropped because the write-behind queue stayed full.", func(s WriteStats) uint64 { return s.Dropped })
+	writeCounter("gocodebot_writes_committed_total", "Writes committed to the store.", func(s WriteStats) uint64 { return s.Written })
+	writeCounter("gocodebot_write_retries_total", "Batch retries after a store error.", func(s WriteStats) uint64 { return s.Retries })
+	writeCounter("gocodebot_writes_spilled_total", "Writes appended to the spill file.", func(s WriteStats) uint64 { return s.Spilled })
+	writeCounter("gocodebot_writes_quarantined_total", "Writes the store rejected on their own, appended to the quarantine file.", func(s WriteStats) uint64 { return s.Quarantined })
+	writeCounter("gocodebot_writes_lost_total", "Writes that could not be stored or spilled.", func(s WriteStats) uint64 { return s.Lost })
+}
+
+// observeStage records the time since start against a pipeline stage.
+func observeStage(stage string, start time.Time) {
+	stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// Intents known when the model was loaded. Intents discovered later are
+// reported as "other" so user queries cannot grow the label set unbounded.
+var metricIntents = map[string]bool{}
+
+// observeAnswer counts an answered query and records its latency.
+func observeAnswer(result Result) {
+	intent := result.Intent
+	switch {
+	case intent == "":
+		intent = "none"
+	case !metricIntents[intent]:
+		intent = "other"
+	}
+	queriesTotal.WithLabelValues(intent, strconv.FormatBool(result.Fallback)).Inc()
+	answerDuration.Observe(result.Latency.Seconds())
+}
+
+// publishModel publishes the loaded model version and its intents.
+func publishModel(version string, loaded []Intent) {
+	modelInfo.Reset()
+	modelInfo.WithLabelValues(version).Set(1)
+
+	metricIntents = make(map[string]bool, len(loaded))
+	for _, intent := range loaded {
+		metricIntents[intent.Name] = true
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sqliteSchema returns the DDL of every table and index but the
+// bookkeeping ones, by name.
+func sqliteSchema(t *testing.T, s *sqlStore) map[string]string {
+	t.Helper()
+	rows, err := s.db.Query(`SELECT name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT IN ('sqlite_sequence', 'schema_migrations')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	schema := make(map[string]string)
+	for rows.Next() {
+		var name, ddl string
+		if err := rows.Scan(&name, &ddl); err != nil {
+			t.Fatal(err)
+		}
+		schema[name] = ddl
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestSQLiteMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s, err := openSQLStore(storeSQLite, filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	migrations, err := loadMigrations(storeSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := s.db.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	count := func(query string) int {
+		t.Helper()
+		var n int
+		if err := s.db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+
+	if done, err := s.MigrateUp(ctx); err != nil || len(done) != len(migrations) {
+		t.Fatalf("first MigrateUp applied %d of %d: %v", len(done), len(migrations), err)
+	}
+	latest := sqliteSchema(t, s)
+	if _, ok := latest["turns"]; !ok {
+		t.Fatalf("no turns table after migrating: %v", latest)
+	}
+	if done, err := s.MigrateUp(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("MigrateUp of a current schema applied %d: %v", len(done), err)
+	}
+
+	// Down to 0001, where ratings were also logged in interaction_logs
+	exec("INSERT INTO feedback (query, response, rating, turn_id) VALUES ('what is a slice', 'A slice is ...', 2, 't1')")
+	if done, err := s.MigrateDown(ctx, len(migrations)-1); err != nil || len(done) != len(migrations)-1 {
+		t.Fatalf("MigrateDown reverted %d of %d: %v", len(done), len(migrations)-1, err)
+	}
+	schema := sqliteSchema(t, s)
+	if _, ok := schema["interaction_logs"]; !ok {
+		t.Fatal("reverting 0002 did not rebuild interaction_logs")
+	}
+	for _, name := range []string{"sessions", "turns", "labels", "feedback_turn"} {
+		if _, ok := schema[name]; ok {
+			t.Errorf("%s is left after reverting to 0001", name)
+		}
+	}
+	if n := count("SELECT COUNT(*) FROM interaction_logs WHERE query = 'what is a slice' AND feedback_rating = 2"); n != 1 {
+		t.Errorf("interaction_logs holds %d copies of the rating, want 1", n)
+	}
+	if n := count("SELECT COUNT(*) FROM pragma_table_info('feedback') WHERE name = 'turn_id'"); n != 0 {
+		t.Error("feedback kept turn_id after reverting 0002")
+	}
+
+	// Up again: a rating only interaction_logs holds moves to feedback, once
+	exec("INSERT INTO interaction_logs (query, response, feedback_rating) VALUES ('what is a map', 'A map is ...', 1)")
+	exec("INSERT INTO interaction_logs (query, response) VALUES ('unrated', '...')")
+	if done, err := s.MigrateUp(ctx); err != nil || len(done) != len(migrations)-1 {
+		t.Fatalf("second MigrateUp applied %d of %d: %v", len(done), len(migrations)-1, err)
+	}
+	if got := sqliteSchema(t, s); !reflect.DeepEqual(got, latest) {
+		t.Errorf("schema after down and up differs:\n got %v\nwant %v", got, latest)
+	}
+	if n := count("SELECT COUNT(*) FROM feedback"); n != 2 {
+		t.Errorf("feedback holds %d rows, want the slice rating and the map rating", n)
+	}
+
+	// All the way down and up
+	if _, err := s.MigrateDown(ctx, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if schema := sqliteSchema(t, s); len(schema) != 0 {
+		t.Errorf("tables left after reverting every migration: %v", schema)
+	}
+	if _, err := s.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqliteSchema(t, s); !reflect.DeepEqual(got, latest) {
+		t.Errorf("schema after a full down and up differs:\n got %v\nwant %v", got, latest)
+	}
+}
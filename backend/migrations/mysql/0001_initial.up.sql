@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS interactions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS feedback (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    rating INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS interaction_logs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    feedback_rating INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- persistDiscoveredIntent relies on the UNIQUE key to append phrases to an existing intent
+CREATE TABLE IF NOT EXISTS discovered_intents (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    intent_name VARCHAR(255) NOT NULL UNIQUE,
+    training_phrases TEXT NOT NULL
+);
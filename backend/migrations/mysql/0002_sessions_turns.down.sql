@@ -0,0 +1,18 @@
+CREATE TABLE interaction_logs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    feedback_rating INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO interaction_logs (query, response, feedback_rating, created_at)
+SELECT query, response, rating, created_at FROM feedback;
+
+DROP INDEX feedback_turn ON feedback;
+
+ALTER TABLE feedback DROP COLUMN turn_id;
+
+DROP TABLE turns;
+
+DROP TABLE sessions;
@@ -0,0 +1,2 @@
+-- The retrieval variant a session was assigned for A/B tests; empty for turns stored before.
+ALTER TABLE turns ADD COLUMN variant VARCHAR(64) NOT NULL DEFAULT '';
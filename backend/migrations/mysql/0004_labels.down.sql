@@ -0,0 +1,3 @@
+DROP TABLE labels;
+
+ALTER TABLE turns DROP COLUMN runner_up;
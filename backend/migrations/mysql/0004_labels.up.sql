@@ -0,0 +1,13 @@
+-- How close the runner-up came to the answer, for spotting near ties worth labelling.
+ALTER TABLE turns ADD COLUMN runner_up DOUBLE NOT NULL DEFAULT 0;
+
+-- Trainer decisions on queued queries: the right section or answer, or a dismissal.
+CREATE TABLE labels (
+    query VARCHAR(255) PRIMARY KEY,
+    turn_id VARCHAR(32),
+    section VARCHAR(255) NOT NULL DEFAULT '',
+    answer TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    labeller VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
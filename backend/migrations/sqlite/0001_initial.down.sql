@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS discovered_intents;
+DROP TABLE IF EXISTS interaction_logs;
+DROP TABLE IF EXISTS feedback;
+DROP TABLE IF EXISTS interactions;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS interactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS feedback (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    rating INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS interaction_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    feedback_rating INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- persistDiscoveredIntent relies on the UNIQUE key to append phrases to an existing intent
+CREATE TABLE IF NOT EXISTS discovered_intents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    intent_name VARCHAR(255) NOT NULL UNIQUE,
+    training_phrases TEXT NOT NULL
+);
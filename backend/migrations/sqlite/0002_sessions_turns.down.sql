@@ -0,0 +1,18 @@
+CREATE TABLE interaction_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    feedback_rating INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO interaction_logs (query, response, feedback_rating, created_at)
+SELECT query, response, rating, created_at FROM feedback;
+
+DROP INDEX feedback_turn;
+
+ALTER TABLE feedback DROP COLUMN turn_id;
+
+DROP TABLE turns;
+
+DROP TABLE sessions;
@@ -0,0 +1,40 @@
+-- Conversations are stored as sessions and turns; feedback points at the turn it rates.
+-- No foreign keys: write-behind batches may commit a turn before its session, or drop rows under load.
+CREATE TABLE sessions (
+    id VARCHAR(32) PRIMARY KEY,
+    transport VARCHAR(16) NOT NULL,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE turns (
+    id VARCHAR(32) PRIMARY KEY,
+    session_id VARCHAR(32),
+    query VARCHAR(255) NOT NULL,
+    response TEXT NOT NULL,
+    intent VARCHAR(255) NOT NULL DEFAULT '',
+    confidence DOUBLE NOT NULL DEFAULT 0,
+    section VARCHAR(255) NOT NULL DEFAULT '',
+    score DOUBLE NOT NULL DEFAULT 0,
+    fallback BOOLEAN NOT NULL DEFAULT FALSE,
+    model_version VARCHAR(64) NOT NULL,
+    latency_ms INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX turns_session ON turns (session_id);
+
+ALTER TABLE feedback ADD COLUMN turn_id VARCHAR(32);
+
+CREATE INDEX feedback_turn ON feedback (turn_id);
+
+-- interaction_logs only ever duplicated feedback; keep any rows feedback lacks, then drop it
+INSERT INTO feedback (query, response, rating, created_at)
+SELECT l.query, l.response, l.feedback_rating, l.created_at
+FROM interaction_logs l
+WHERE l.feedback_rating IS NOT NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM feedback f
+    WHERE f.query = l.query AND f.response = l.response AND f.rating = l.feedback_rating
+  );
+
+DROP TABLE interaction_logs;
@@ -0,0 +1 @@
+ALTER TABLE turns DROP COLUMN variant;
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Model is the part of the bot built from the model files: the corpus,
+// its TF-IDF model and sections, the keywords and the intents. The answer
+// pipeline reads the installed model through package variables.
+type Model struct {
+	Version string
+	Files   ModelConfig
+
+	corpus         []string
+	corpusKeywords map[string]float64
+	tfidf          *TFIDF
+	keywords       map[string]KeywordEntity
+	terms          map[string]TermDefinition
+	linker         *entityLinker
+	intents        []Intent
+	sections       []Section
+	sectionIDF     map[string]float64
+	discovered     map[string][]string // Discovered intents, including corpus phrases
+}
+
+// modelMu guards the package variables of the installed model. Answering
+// holds it for reading and installing a model for writing, so queries
+// never see a model half installed. Models are built without it.
+var modelMu sync.RWMutex
+
+// installedModel captures the model the pipeline is currently using. The
+// caller holds modelMu.
+func installedModel() *Model {
+	discoveredIntentsMu.Lock()
+	defer discoveredIntentsMu.Unlock()
+	return &Model{
+		Version:        modelVersion,
+		Files:          config.Model,
+		corpus:         corpus,
+		corpusKeywords: corpusKeywords,
+		tfidf:          tfidf,
+		keywords:       programmingKeywords,
+		terms:          programmingTerms,
+		linker:         linker,
+		intents:        intents,
+		sections:       corpusSections,
+		sectionIDF:     sectionIDF,
+		discovered:     discoveredIntents,
+	}
+}
+
+// install makes m the model the pipeline answers with.
+func (m *Model) install() {
+	modelMu.Lock()
+	defer modelMu.Unlock()
+	m.set()
+}
+
+// set assigns the package variables from m. The caller holds modelMu.
+func (m *Model) set() {
+	discoveredIntentsMu.Lock()
+	defer discoveredIntentsMu.Unlock()
+	modelVersion = m.Version
+	corpus = m.corpus
+	corpusKeywords = m.corpusKeywords
+	tfidf = m.tfidf
+	programmingKeywords = m.keywords
+	programmingTerms = m.terms
+	linker = m.linker
+	intents = m.intents
+	corpusSections = m.sections
+	sectionIDF = m.sectionIDF
+	discoveredIntents = m.discovered
+}
+
+// loadModel builds a model from files, adding the corpus phrases to the
+// intents discovered from queries. It does not touch the installed model,
+// which keeps answering until the caller installs the new one.
+func loadModel(files ModelConfig, discovered map[string][]string) (*Model, error) {
+	// Load programming keywords
+	keywords, err := loadProgrammingKeywords(files.KeywordsFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading programming keywords from %s: %w", files.KeywordsFile, err)
+	}
+
+	// Read every corpus source into one document store
+	docs, err := loadDocuments(files.corpusSources())
+	if err != nil {
+		return nil, fmt.Errorf("loading corpus: %w", err)
+	}
+	if docs.Duplicates > 0 {
+		slog.Info("Dropped duplicate corpus documents", "duplicates", docs.Duplicates)
+	}
+
+	model := &Model{
+		Files:      files,
+		corpus:     docs.Lines,
+		keywords:   keywords,
+		sections:   docs.Sections, // The documents are the sections for topic-level retrieval
+		discovered: make(map[string][]string, len(discovered)),
+	}
+	for name, phrases := range discovered {
+		model.discovered[name] = append([]string(nil), phrases...)
+	}
+
+	// Create the TF-IDF model. The intents' training phrases are part of its
+	// vocabulary so that queries such as "hello" can be classified.
+	vocabulary := append([]string{}, model.corpus...)
+	for _, intent := range builtinIntents {
+		vocabulary = append(vocabulary, intent.TrainingPhrases...)
+	}
+	model.tfidf = NewTFIDF(vocabulary)
+	model.sectionIDF = vectorizeSections(model.sections)
+
+	// Link query entities to the keywords and section titles
+	model.linker = newEntityLinker(keywords, model.sections)
+
+	// Extract keywords from the corpus
+	model.corpusKeywords = model.tfidf.ExtractKeywords(model.corpus, 20) // Adjust top N as necessary
+
+	// Define programming terms from the keywords and the corpus
+	model.terms = mineDefinitions(keywords, files.KeywordsFile, model.sections)
+
+	// Extract new intents from phrases in the corpus
+	model.intents = extractNewIntentsFromCorpus(model.corpus, keywords, model.discovered, append([]Intent(nil), builtinIntents...))
+
+	// Fingerprint the model so every stored turn records what answered it
+	model.Version = computeModelVersion(model.intents, append(docs.Files, files.KeywordsFile)...)
+	return model, nil
+}
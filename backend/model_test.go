@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLoadModelVersionIsStable(t *testing.T) {
+	config = defaultConfig()
+	files := ModelConfig{CorpusFile: "go_corpus.md", KeywordsFile: "Go_Keyword_Entities.yaml"}
+	first, err := loadModel(files, nil)
+	if err != nil {
+		t.Fatalf("loading model: %v", err)
+	}
+	// A reload builds the next model while the first one is installed
+	first.install()
+	second, err := loadModel(files, nil)
+	if err != nil {
+		t.Fatalf("reloading model: %v", err)
+	}
+	if first.Version != second.Version {
+		t.Errorf("versions of the same files differ: %s at startup, %s on reload", first.Version, second.Version)
+	}
+
+	var phrases []string
+	for i := 0; i < config.Intents.MinExamples; i++ {
+		phrases = append(phrases, fmt.Sprintf("type parameters %d", i))
+	}
+	discovered, err := loadModel(files, map[string][]string{"generics": phrases})
+	if err != nil {
+		t.Fatalf("loading model: %v", err)
+	}
+	if discovered.Version == first.Version {
+		t.Errorf("a new discovered intent left the version at %s", first.Version)
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"log/slog"
 	"math"
 	"math/rand"
 )
@@ -273,11 +273,11 @@ func performKFoldCrossValidation(nn *NeuralNetwork, inputs [][]float64, targets
 		}
 		validationLoss /= float64(len(validationInputs))
 		totalValidationLoss += validationLoss
-		fmt.Printf("Validation Loss for fold %d: %.6f\n", i+1, validationLoss)
+		slog.Info("Cross-validation fold", "fold", i+1, "validation_loss", validationLoss)
 	}
 
 	averageValidationLoss := totalValidationLoss / float64(k)
-	fmt.Printf("Average Validation Loss across all folds: %.6f\n", averageValidationLoss)
+	slog.Info("Cross-validation complete", "folds", k, "average_validation_loss", averageValidationLoss)
 }
 
 // Predict using the neural network
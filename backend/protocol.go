@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// protocolVersion is the version of the WebSocket message protocol spoken by
+// this server. The schema lives in protocol.schema.json.
+const protocolVersion = 1
+
+// Limits enforced on incoming messages.
+const (
+	maxMessageIDLength = 64
+	maxQueryLength     = 255 // Matches the query column of the interactions table
+	minRating          = 1
+	maxRating          = 5
+)
+
+// Client message types.
+const (
+	typeQuery    = "query"
+	typeCancel   = "cancel"
+	typeFeedback = "feedback"
+)
+
+// Server message types.
+const (
+	typeResponseStart = "response.start"
+	typeResponseDelta = "response.delta"
+	typeResponseEnd   = "response.end"
+	typeFeedbackAck   = "feedback.ack"
+	typeError         = "error"
+)
+
+// Error codes sent in error messages.
+const (
+	errInvalidJSON        = "invalid_json"
+	errUnsupportedVersion = "unsupported_version"
+	errUnknownType        = "unknown_type"
+	errInvalidMessage     = "invalid_message"
+	errDuplicateID        = "duplicate_id"
+	errNotInFlight        = "not_in_flight"
+	errShuttingDown       = "shutting_down"
+	errRateLimited        = "rate_limited"
+	errMessageTooLarge    = "message_too_large"
+	errTooManyConnections = "too_many_connections"
+	errIdleTimeout        = "idle_timeout"
+)
+
+// Envelope holds the fields shared by every message in either direction.
+type Envelope struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+}
+
+// QueryMessage asks the bot a question.
+type QueryMessage struct {
+	Envelope
+	Query string `json:"query"`
+}
+
+// CancelMessage stops the response to the query whose ID is Target.
+type CancelMessage struct {
+	Envelope
+	Target string `json:"target"`
+}
+
+// FeedbackMessage rates an earlier response.
+type FeedbackMessage struct {
+	Envelope
+	TurnID   string `json:"turn_id,omitempty"` // Turn id from the response.end being rated
+	Query    string `json:"query"`
+	Response string `json:"response"`
+	Rating   int    `json:"rating"`
+}
+
+// ResponseStartMessage opens the streamed answer to a query. The ID of
+// every frame of the answer is the ID of the query.
+type ResponseStartMessage struct {
+	Envelope
+}
+
+// ResponseDeltaMessage carries the next chunk of an answer. Concatenating
+// the deltas in order yields the full answer.
+type ResponseDeltaMessage struct {
+	Envelope
+	Delta string `json:"delta"`
+}
+
+// ResponseEndMessage closes a streamed answer and describes how it was found.
+type ResponseEndMessage struct {
+	Envelope
+	TurnID    string       `json:"turn_id"` // Identifies the turn in feedback messages
+	Cancelled bool         `json:"cancelled"`
+	Section   string       `json:"section,omitempty"`
+	Score     float64      `json:"score"`
+	Entities  []string     `json:"entities"`
+	Links     []EntityLink `json:"links"` // Where the query mentions the entities
+}
+
+// FeedbackAckMessage confirms that feedback was received.
+type FeedbackAckMessage struct {
+	Envelope
+}
+
+// ErrorMessage reports a message the server could not handle. Its ID is the
+// ID of the offending message when it could be read.
+type ErrorMessage struct {
+	Envelope
+	Code       string  `json:"code"`
+	Detail     string  `json:"detail"`
+	RetryAfter float64 `json:"retry_after,omitempty"` // Seconds to wait before retrying, for rate_limited
+}
+
+// ProtocolError describes why a client message was rejected.
+type ProtocolError struct {
+	ID         string
+	Code       string
+	Detail     string
+	RetryAfter time.Duration
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Detail
+}
+
+// decodeClientMessage parses and validates a raw client message. It returns
+// a *QueryMessage, *CancelMessage or *FeedbackMessage.
+func decodeClientMessage(data []byte) (interface{}, *ProtocolError) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &ProtocolError{Code: errInvalidMessage, Detail: err.Error()}
+		}
+		return nil, &ProtocolError{Code: errInvalidJSON, Detail: err.Error()}
+	}
+	if env.Version != protocolVersion {
+		return nil, &ProtocolError{ID: env.ID, Code: errUnsupportedVersion,
+			Detail: fmt.Sprintf("version %d is not supported, use %d", env.Version, protocolVersion)}
+	}
+	if env.ID == "" || len(env.ID) > maxMessageIDLength {
+		return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage,
+			Detail: fmt.Sprintf("id must be 1-%d characters", maxMessageIDLength)}
+	}
+
+	switch env.Type {
+	case typeQuery:
+		var msg QueryMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage, Detail: err.Error()}
+		}
+		if err := validateText("query", msg.Query); err != "" {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage, Detail: err}
+		}
+		return &msg, nil
+
+	case typeCancel:
+		var msg CancelMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage, Detail: err.Error()}
+		}
+		if msg.Target == "" || len(msg.Target) > maxMessageIDLength {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage,
+				Detail: fmt.Sprintf("target must be 1-%d characters", maxMessageIDLength)}
+		}
+		return &msg, nil
+
+	case typeFeedback:
+		var msg FeedbackMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage, Detail: err.Error()}
+		}
+		if err := validateText("query", msg.Query); err != "" {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage, Detail: err}
+		}
+		if msg.TurnID != "" && !turnIDPattern.MatchString(msg.TurnID) {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage, Detail: "turn_id must be a turn id from a response.end message"}
+		}
+		if msg.Response == "" {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage, Detail: "response is required"}
+		}
+		if msg.Rating < minRating || msg.Rating > maxRating {
+			return nil, &ProtocolError{ID: env.ID, Code: errInvalidMessage,
+				Detail: fmt.Sprintf("rating must be an integer from %d to %d", minRating, maxRating)}
+		}
+		return &msg, nil
+	}
+
+	return nil, &ProtocolError{ID: env.ID, Code: errUnknownType, Detail: fmt.Sprintf("unknown message type %q", env.Type)}
+}
+
+// validateText checks a required free-text field and returns a description
+// of the problem, or "" if the value is valid.
+func validateText(field, value string) string {
+	switch {
+	case value == "":
+		return field + " is required"
+	case !utf8.ValidString(value):
+		return field + " must be valid UTF-8"
+	case utf8.RuneCountInString(value) > maxQueryLength:
+		return fmt.Sprintf("%s must be at most %d characters", field, maxQueryLength)
+	}
+	return ""
+}
+
+// newEnvelope returns the envelope of a server message replying to id.
+func newEnvelope(id, messageType string) Envelope {
+	return Envelope{Version: protocolVersion, ID: id, Type: messageType}
+}
+
+// newErrorMessage converts a ProtocolError into the message sent to the client.
+func newErrorMessage(perr *ProtocolError) ErrorMessage {
+	return ErrorMessage{Envelope: newEnvelope(perr.ID, typeError), Code: perr.Code, Detail: perr.Detail, RetryAfter: perr.RetryAfter.Seconds()}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMessageRoundTrip(t *testing.T) {
+	turnID := newTurnID()
+	tests := []interface{}{
+		&QueryMessage{Envelope: Envelope{Version: protocolVersion, ID: "q1", Type: typeQuery}, Query: "what is a goroutine"},
+		&QueryMessage{Envelope: Envelope{Version: protocolVersion, ID: strings.Repeat("i", maxMessageIDLength), Type: typeQuery}, Query: strings.Repeat("é", maxQueryLength)},
+		&CancelMessage{Envelope: Envelope{Version: protocolVersion, ID: "c1", Type: typeCancel}, Target: "q1"},
+		&FeedbackMessage{Envelope: Envelope{Version: protocolVersion, ID: "f1", Type: typeFeedback}, TurnID: turnID, Query: "what is a goroutine", Response: "A goroutine is ...", Rating: maxRating},
+		&FeedbackMessage{Envelope: Envelope{Version: protocolVersion, ID: "f2", Type: typeFeedback}, Query: "hello", Response: "Hello!", Rating: minRating},
+	}
+	for _, want := range tests {
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("encoding %T: %v", want, err)
+		}
+		got, perr := decodeClientMessage(data)
+		if perr != nil {
+			t.Errorf("decodeClientMessage(%s): %v", data, perr)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decodeClientMessage(%s) = %#v, want %#v", data, got, want)
+		}
+	}
+}
+
+func TestServerMessageRoundTrip(t *testing.T) {
+	errorMessage := newErrorMessage(&ProtocolError{ID: "q3", Code: errRateLimited, Detail: "slow down", RetryAfter: 1500 * time.Millisecond})
+	links := []EntityLink{{ID: "section:buffered-channels", Kind: entitySection, Name: "Buffered Channels", Text: "buffered channels", Start: 8, End: 25, Confidence: 1}}
+	tests := []struct {
+		msg      interface{}
+		wantType string
+	}{
+		{&ResponseStartMessage{Envelope: newEnvelope("q1", typeResponseStart)}, typeResponseStart},
+		{&ResponseDeltaMessage{Envelope: newEnvelope("q1", typeResponseDelta), Delta: "Buffered channels can store "}, typeResponseDelta},
+		{&ResponseEndMessage{Envelope: newEnvelope("q1", typeResponseEnd), TurnID: newTurnID(), Section: "Buffered Channels", Score: 0.42, Entities: []string{"Buffered Channels"}, Links: links}, typeResponseEnd},
+		{&ResponseEndMessage{Envelope: newEnvelope("q2", typeResponseEnd), TurnID: newTurnID(), Cancelled: true, Entities: []string{}, Links: []EntityLink{}}, typeResponseEnd},
+		{&FeedbackAckMessage{Envelope: newEnvelope("f1", typeFeedbackAck)}, typeFeedbackAck},
+		{&errorMessage, typeError},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.msg)
+		if err != nil {
+			t.Fatalf("encoding %T: %v", tt.msg, err)
+		}
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("decoding the envelope of %s: %v", data, err)
+		}
+		if env.Version != protocolVersion || env.Type != tt.wantType {
+			t.Errorf("%s: version %d, type %q; want %d, %q", data, env.Version, env.Type, protocolVersion, tt.wantType)
+		}
+		got := reflect.New(reflect.TypeOf(tt.msg).Elem()).Interface()
+		if err := json.Unmarshal(data, got); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		if !reflect.DeepEqual(got, tt.msg) {
+			t.Errorf("round trip of %s = %#v, want %#v", data, got, tt.msg)
+		}
+	}
+}
+
+func TestErrorMessageRetryAfter(t *testing.T) {
+	msg := newErrorMessage(&ProtocolError{ID: "q1", Code: errRateLimited, RetryAfter: 1500 * time.Millisecond})
+	if msg.RetryAfter != 1.5 {
+		t.Errorf("RetryAfter = %v, want 1.5 seconds", msg.RetryAfter)
+	}
+	data, _ := json.Marshal(newErrorMessage(&ProtocolError{ID: "q1", Code: errInvalidJSON}))
+	if strings.Contains(string(data), "retry_after") {
+		t.Errorf("%s: retry_after is set without a delay", data)
+	}
+}
+
+func TestDecodeClientMessageErrors(t *testing.T) {
+	longID := strings.Repeat("i", maxMessageIDLength+1)
+	tests := []struct {
+		name     string
+		data     string
+		wantCode string
+		wantID   string
+	}{
+		{"not JSON", `hello`, errInvalidJSON, ""},
+		{"truncated", `{"version":1,"id":"q1","type":"query"`, errInvalidJSON, ""},
+		{"empty", ``, errInvalidJSON, ""},
+		{"version of the wrong type", `{"version":"1","id":"q1","type":"query","query":"hi"}`, errInvalidMessage, ""},
+		{"no version", `{"id":"q1","type":"query","query":"hi"}`, errUnsupportedVersion, "q1"},
+		{"future version", `{"version":2,"id":"q1","type":"query","query":"hi"}`, errUnsupportedVersion, "q1"},
+		{"no id", `{"version":1,"type":"query","query":"hi"}`, errInvalidMessage, ""},
+		{"id too long", `{"version":1,"id":"` + longID + `","type":"query","query":"hi"}`, errInvalidMessage, longID},
+		{"unknown type", `{"version":1,"id":"x1","type":"subscribe"}`, errUnknownType, "x1"},
+		{"no type", `{"version":1,"id":"x1"}`, errUnknownType, "x1"},
+		{"empty query", `{"version":1,"id":"q1","type":"query","query":""}`, errInvalidMessage, "q1"},
+		{"query too long", `{"version":1,"id":"q1","type":"query","query":"` + strings.Repeat("a", maxQueryLength+1) + `"}`, errInvalidMessage, "q1"},
+		{"query of the wrong type", `{"version":1,"id":"q1","type":"query","query":42}`, errInvalidMessage, "q1"},
+		{"cancel without target", `{"version":1,"id":"c1","type":"cancel"}`, errInvalidMessage, "c1"},
+		{"target too long", `{"version":1,"id":"c1","type":"cancel","target":"` + longID + `"}`, errInvalidMessage, "c1"},
+		{"feedback without query", `{"version":1,"id":"f1","type":"feedback","response":"r","rating":3}`, errInvalidMessage, "f1"},
+		{"feedback without response", `{"version":1,"id":"f1","type":"feedback","query":"q","rating":3}`, errInvalidMessage, "f1"},
+		{"rating too low", `{"version":1,"id":"f1","type":"feedback","query":"q","response":"r","rating":0}`, errInvalidMessage, "f1"},
+		{"rating too high", `{"version":1,"id":"f1","type":"feedback","query":"q","response":"r","rating":6}`, errInvalidMessage, "f1"},
+		{"fractional rating", `{"version":1,"id":"f1","type":"feedback","query":"q","response":"r","rating":2.5}`, errInvalidMessage, "f1"},
+		{"bad turn id", `{"version":1,"id":"f1","type":"feedback","turn_id":"../../etc","query":"q","response":"r","rating":3}`, errInvalidMessage, "f1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, perr := decodeClientMessage([]byte(tt.data))
+			if perr == nil {
+				t.Fatalf("decodeClientMessage(%s) = %#v, want %s", tt.data, msg, tt.wantCode)
+			}
+			if perr.Code != tt.wantCode || perr.ID != tt.wantID {
+				t.Errorf("decodeClientMessage(%s): code %q, id %q; want %q, %q (%s)", tt.data, perr.Code, perr.ID, tt.wantCode, tt.wantID, perr.Detail)
+			}
+			if perr.Detail == "" {
+				t.Errorf("decodeClientMessage(%s): no detail", tt.data)
+			}
+		})
+	}
+}
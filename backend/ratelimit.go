@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Limits applied unless overridden in LimitsConfig.
+const (
+	defaultRequestsPerMinute    = 60
+	defaultRequestBurst         = 10
+	defaultTrainPerMinute       = 6
+	defaultTrainBurst           = 3
+	defaultMaxMessageSize       = maxRequestBodySize
+	defaultMaxConnections       = 5
+	defaultWebSocketIdleTimeout = 10 * time.Minute
+)
+
+// How often idle buckets are forgotten.
+const limiterSweepInterval = time.Minute
+
+// Names of the limits, as reported in metrics.
+const (
+	limitRequests    = "requests"
+	limitTrain       = "train"
+	limitConnections = "connections"
+	limitMessageSize = "message_size"
+	limitIdle        = "idle"
+)
+
+// bucket is a token bucket. Tokens refill continuously up to the burst.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+	rate  float64 // Tokens added per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter allows each client perMinute events a minute, and up to
+// burst at once.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from client's bucket. When the bucket is empty it
+// returns false and how long until the next token.
+func (l *rateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= limiterSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets buckets that have refilled, since a new bucket is full too.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// connLimiter caps the open WebSocket connections of each client.
+type connLimiter struct {
+	max int
+
+	mu   sync.Mutex
+	open map[string]int
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{max: max, open: make(map[string]int)}
+}
+
+// Acquire counts a new connection for client. It returns false, counting
+// nothing, when the client already has the maximum open.
+func (l *connLimiter) Acquire(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[client] >= l.max {
+		return false
+	}
+	l.open[client]++
+	return true
+}
+
+// Release uncounts a connection taken by Acquire.
+func (l *connLimiter) Release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[client]--; l.open[client] <= 0 {
+		delete(l.open, client)
+	}
+}
+
+// A WebSocket message this many times over limits.max_message_size closes
+// the connection instead of being read and discarded.
+const hardReadLimitFactor = 4
+
+// errMessageTooBig reports a WebSocket message over the size limit.
+var errMessageTooBig = errors.New("message too large")
+
+// readMessage reads the next message from conn. A message longer than max
+// bytes is discarded and reported as errMessageTooBig, leaving the
+// connection usable.
+func readMessage(conn *websocket.Conn, max int) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
+		}
+		return nil, errMessageTooBig
+	}
+	return data, nil
+}
+
+// limiters holds the limits shared by all of a server's handlers.
+type limiters struct {
+	requests    *rateLimiter // Queries and feedback, over WebSocket and REST
+	train       *rateLimiter
+	connections *connLimiter
+}
+
+func newLimiters(c LimitsConfig) *limiters {
+	return &limiters{
+		requests:    newRateLimiter(c.RequestsPerMinute, c.RequestBurst),
+		train:       newRateLimiter(c.TrainPerMinute, c.TrainBurst),
+		connections: newConnLimiter(c.MaxConnectionsPerClient),
+	}
+}
+
+// clientKey identifies whoever sent r for rate limiting: the authenticated
+// API key or token subject, otherwise the remote IP address. Proxies are
+// not trusted, so behind one every client shares the proxy's address.
+func clientKey(r *http.Request) string {
+	if principal, ok := principalFrom(r.Context()); ok {
+		return "principal:" + principal.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimited is the protocol error sent to a client that has run out of
+// tokens.
+func rateLimited(id string, wait time.Duration) *ProtocolError {
+	return &ProtocolError{
+		ID:         id,
+		Code:       errRateLimited,
+		Detail:     fmt.Sprintf("too many requests; retry in %s", wait.Round(time.Millisecond)),
+		RetryAfter: wait,
+	}
+}
+
+// limitRate wraps next so that each client may call it only as often as
+// limiter allows. Callers over the limit get 429 with a Retry-After header.
+func limitRate(limiter *rateLimiter, limit string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.Allow(clientKey(r)); !ok {
+			limitViolations.WithLabelValues(limit).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeAPIError(w, http.StatusTooManyRequests, errRateLimited, rateLimited("", wait).Detail)
+			return
+		}
+		next(w, r)
+	}
+}
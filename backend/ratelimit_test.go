@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestRateLimiter(perMinute, burst int) (*rateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newRateLimiter(perMinute, burst)
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	l, clock := newTestRateLimiter(60, 3) // A token a second
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != time.Second {
+		t.Fatalf("request over the burst = %v, %v; want refused for 1s", ok, wait)
+	}
+
+	clock.Advance(400 * time.Millisecond)
+	if ok, wait := l.Allow("a"); ok || wait != 600*time.Millisecond {
+		t.Errorf("request 0.4s later = %v, %v; want refused for 0.6s", ok, wait)
+	}
+	clock.Advance(600 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("request after a token refilled was refused")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("a refilled token was spent twice")
+	}
+
+	// A long idle time refills to the burst, no further
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d after idling refused", i+1)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("idling refilled beyond the burst")
+	}
+}
+
+func TestRateLimiterIsolatesClients(t *testing.T) {
+	l, _ := newTestRateLimiter(60, 1)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first request of a refused")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("second request of a allowed")
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("b was refused because a ran out")
+	}
+}
+
+func TestRateLimiterForgetsIdleClients(t *testing.T) {
+	l, clock := newTestRateLimiter(1, 10) // A token a minute
+	l.Allow("idle")
+	for i := 0; i < 10; i++ {
+		l.Allow("busy")
+	}
+
+	// A sweep interval later, idle has refilled and busy has not
+	clock.Advance(limiterSweepInterval)
+	l.Allow("other")
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("the refilled bucket of an idle client was kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("the bucket of a client still short of tokens was forgotten")
+	}
+
+	// A forgotten client starts again with a full bucket
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow("idle"); !ok {
+			t.Fatalf("request %d of a forgotten client refused", i+1)
+		}
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(2)
+	if !l.Acquire("a") || !l.Acquire("a") {
+		t.Fatal("connections up to the maximum refused")
+	}
+	if l.Acquire("a") {
+		t.Fatal("connection over the maximum allowed")
+	}
+	if !l.Acquire("b") {
+		t.Error("b was refused because a is at the maximum")
+	}
+	l.Release("a")
+	if !l.Acquire("a") {
+		t.Error("connection after a release refused")
+	}
+	l.Release("a")
+	l.Release("a")
+	l.Release("b")
+	if len(l.open) != 0 {
+		t.Errorf("open = %v after every release, want empty", l.open)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	l, _ := newTestRateLimiter(30, 1) // A token every 2s
+	handler := limitRate(l, limitRequests, func(w http.ResponseWriter, r *http.Request) {})
+	request := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/query", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	if w := request("192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	w := request("192.0.2.1:5678") // Another port of the same client
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" || !strings.Contains(w.Body.String(), errRateLimited) {
+		t.Errorf("request over the limit: %d, Retry-After %q, %q; want 429 after 2s", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+	if w := request("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("request of another address: %d", w.Code)
+	}
+}
+
+func TestReadMessageSizeLimit(t *testing.T) {
+	server, client := wsPair(t)
+	for _, msg := range []string{strings.Repeat("x", 100), strings.Repeat("y", 101), "after"} {
+		if err := client.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := readMessage(server, 100); err != nil || len(data) != 100 {
+		t.Errorf("message at the limit = %d bytes, %v", len(data), err)
+	}
+	if _, err := readMessage(server, 100); !errors.Is(err, errMessageTooBig) {
+		t.Errorf("message over the limit: %v, want %v", err, errMessageTooBig)
+	}
+	if data, err := readMessage(server, 100); err != nil || string(data) != "after" {
+		t.Errorf("message after a discarded one = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// modelWatcher reloads the model when the files it is built from change.
+// It polls their sizes and modification times, which works the same on
+// every platform and for files on network mounts.
+type modelWatcher struct {
+	files   ModelConfig
+	built   string // Fingerprint of the files last built, whether or not that succeeded
+	pending string // Fingerprint of changed files waiting to settle
+}
+
+// newModelWatcher starts watching from the files as they are now. Create
+// it before the model is first loaded so that no edit is missed.
+func newModelWatcher(files ModelConfig) *modelWatcher {
+	return &modelWatcher{files: files, built: sourceFingerprint(files)}
+}
+
+// check reloads the model once its files have changed and then stayed
+// the same for a whole interval, so files still being written are not
+// read. A model that fails to build is not retried until the files change
+// again.
+func (w *modelWatcher) check() {
+	fingerprint := sourceFingerprint(w.files)
+	switch fingerprint {
+	case w.built:
+		w.pending = ""
+	case w.pending:
+		w.built, w.pending = fingerprint, ""
+		reloadModel(w.files)
+	default:
+		w.pending = fingerprint
+	}
+}
+
+// reloadModel builds a model from files and installs it. The running
+// model keeps answering while the files are read, and stays installed if
+// they cannot be.
+func reloadModel(files ModelConfig) {
+	start := time.Now()
+	model, err := loadModel(files, storedDiscoveredIntents())
+	if err != nil {
+		modelReloads.WithLabelValues("error").Inc()
+		slog.Error("Reloading the model failed; the running model is kept", "err", err)
+		return
+	}
+	modelMu.Lock()
+	model.set()
+	publishModel(model.Version, model.intents)
+	modelMu.Unlock()
+	modelReloads.WithLabelValues("ok").Inc()
+	slog.Info("Model reloaded", "version", model.Version, "sections", len(model.sections), "duration", time.Since(start))
+}
+
+// sourceFingerprint hashes the names, sizes and modification times of the
+// keyword file and of every file the corpus sources match, so edits,
+// additions and removals all change it without reading the files.
+func sourceFingerprint(files ModelConfig) string {
+	hash := sha256.New()
+	for _, name := range watchedFiles(files) {
+		info, err := os.Stat(name)
+		if err != nil {
+			fmt.Fprintf(hash, "%s missing\n", name)
+			continue
+		}
+		fmt.Fprintf(hash, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// watchedFiles lists the keyword file and the files the corpus sources
+// match now. A source that matches nothing is listed by its path, to be
+// reported missing.
+func watchedFiles(files ModelConfig) []string {
+	names := []string{files.KeywordsFile}
+	for _, source := range files.corpusSources() {
+		if source.Kind != sourceGo {
+			matched, err := sourceFiles(source)
+			if err != nil {
+				matched = []string{source.Path}
+			}
+			names = append(names, matched...)
+			continue
+		}
+		dirs, err := goPackageDirs(os.ExpandEnv(source.Path))
+		if err != nil {
+			names = append(names, source.Path)
+			continue
+		}
+		for _, dir := range dirs {
+			matched, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+			names = append(names, matched...)
+		}
+	}
+	return names
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Logged queries replayed unless -limit says otherwise.
+const defaultReplayLimit = 1000
+
+// Ratings at or above this count as liked, at or below replayDislikedRating
+// as disliked, when breaking down changed answers.
+const (
+	replayLikedRating    = 4
+	replayDislikedRating = 2
+)
+
+// replayCase is a logged query answered by both models.
+type replayCase struct {
+	LoggedQuery
+	Current   Result
+	Candidate Result
+}
+
+// Changed reports whether the two models gave different answers.
+func (c replayCase) Changed() bool {
+	return c.Current.Answer != c.Candidate.Answer
+}
+
+// readQueryLog reads logged queries from a JSONL file written by
+// replay export.
+func readQueryLog(r io.Reader) ([]LoggedQuery, error) {
+	var log []LoggedQuery
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20) // Responses can be long
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var q LoggedQuery
+		if err := json.Unmarshal(scanner.Bytes(), &q); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		log = append(log, q)
+	}
+	return log, scanner.Err()
+}
+
+// writeQueryLog writes log as JSONL.
+func writeQueryLog(w io.Writer, log []LoggedQuery) error {
+	encoder := json.NewEncoder(w)
+	for _, q := range log {
+		if err := encoder.Encode(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedQueryLog reads up to limit logged queries from the configured store.
+func storedQueryLog(limit int) ([]LoggedQuery, error) {
+	s, err := openStore(config.Storage.Driver, config.Storage.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.QueryLog(context.Background(), limit)
+}
+
+// dedupeQueries keeps the first, most recent, entry of each query and
+// pools the ratings of its repeats.
+func dedupeQueries(log []LoggedQuery) []LoggedQuery {
+	index := make(map[string]int)
+	var unique []LoggedQuery
+	for _, q := range log {
+		i, seen := index[q.Query]
+		if !seen {
+			index[q.Query] = len(unique)
+			unique = append(unique, q)
+			continue
+		}
+		first := &unique[i]
+		if ratings := first.Ratings + q.Ratings; ratings > 0 {
+			first.Rating = (first.Rating*float64(first.Ratings) + q.Rating*float64(q.Ratings)) / float64(ratings)
+			first.Ratings = ratings
+		}
+	}
+	return unique
+}
+
+// replay answers every query with both models, each in a fresh session.
+// It leaves current installed.
+func replay(log []LoggedQuery, current, candidate *Model) []replayCase {
+	cases := make([]replayCase, 0, len(log))
+	defer current.install()
+	for _, q := range log {
+		c := replayCase{LoggedQuery: q}
+		current.install()
+		c.Current = Answer(context.Background(), Query{Text: q.Query})
+		candidate.install()
+		c.Candidate = Answer(context.Background(), Query{Text: q.Query})
+		cases = append(cases, c)
+	}
+	return cases
+}
+
+// latencyStats returns the mean, median and 95th percentile of latencies.
+func latencyStats(latencies []time.Duration) (mean, p50, p95 time.Duration) {
+	if len(latencies) == 0 {
+		return 0, 0, 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+	percentile := func(p float64) time.Duration {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1] // Nearest rank
+	}
+	return total / time.Duration(len(sorted)), percentile(0.5), percentile(0.95)
+}
+
+// signed formats a latency delta with its sign.
+func signed(d time.Duration) string {
+	d = d.Round(time.Microsecond)
+	if d >= 0 {
+		return "+" + d.String()
+	}
+	return d.String()
+}
+
+// shorten cuts text to n runes on one line for the report.
+func shorten(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return text
+}
+
+// printReplayReport summarises cases and shows up to sample changed
+// answers, rated ones first, best rated first: those are the answers users
+// liked and the candidate would replace.
+func printReplayReport(w io.Writer, current, candidate *Model, logged int, cases []replayCase, sample int) error {
+	var changed, intentChanged, ratedChanged, likedChanged, dislikedChanged, rated int
+	var currentFallbacks, candidateFallbacks int
+	var currentLatency, candidateLatency, historicalLatency []time.Duration
+	var changes []replayCase
+	for _, c := range cases {
+		if c.Ratings > 0 {
+			rated++
+		}
+		if c.Changed() {
+			changed++
+			changes = append(changes, c)
+			if c.Ratings > 0 {
+				ratedChanged++
+				if c.Rating >= replayLikedRating {
+					likedChanged++
+				}
+				if c.Rating <= replayDislikedRating {
+					dislikedChanged++
+				}
+			}
+		}
+		if c.Current.Intent != c.Candidate.Intent {
+			intentChanged++
+		}
+		if c.Current.Fallback {
+			currentFallbacks++
+		}
+		if c.Candidate.Fallback {
+			candidateFallbacks++
+		}
+		currentLatency = append(currentLatency, c.Current.Latency)
+		candidateLatency = append(candidateLatency, c.Candidate.Latency)
+		if c.LatencyMS > 0 {
+			historicalLatency = append(historicalLatency, time.Duration(c.LatencyMS)*time.Millisecond)
+		}
+	}
+	percent := func(n, of int) string {
+		if of == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(of))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "current model\t%s\t%s, %s\n", current.Version, current.Files.CorpusFile, current.Files.KeywordsFile)
+	fmt.Fprintf(tw, "candidate model\t%s\t%s, %s\n", candidate.Version, candidate.Files.CorpusFile, candidate.Files.KeywordsFile)
+	fmt.Fprintf(tw, "queries\t%d\t%d logged, %d rated\n", len(cases), logged, rated)
+	fmt.Fprintf(tw, "answers changed\t%s\t%d\n", percent(changed, len(cases)), changed)
+	fmt.Fprintf(tw, "  of rated queries\t%s\t%d (%d rated %d+, %d rated %d or less)\n",
+		percent(ratedChanged, rated), ratedChanged, likedChanged, replayLikedRating, dislikedChanged, replayDislikedRating)
+	fmt.Fprintf(tw, "intents changed\t%s\t%d\n", percent(intentChanged, len(cases)), intentChanged)
+	fmt.Fprintf(tw, "fallback rate\t%s -> %s\t\n", percent(currentFallbacks, len(cases)), percent(candidateFallbacks, len(cases)))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "latency\tmean\tp50\tp95\t")
+	type latencyRow struct {
+		name      string
+		latencies []time.Duration
+	}
+	rows := []latencyRow{{"current", currentLatency}, {"candidate", candidateLatency}}
+	if len(historicalLatency) > 0 {
+		rows = append(rows, latencyRow{fmt.Sprintf("logged (%d)", len(historicalLatency)), historicalLatency})
+	}
+	for _, row := range rows {
+		mean, p50, p95 := latencyStats(row.latencies)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", row.name, mean.Round(time.Microsecond), p50.Round(time.Microsecond), p95.Round(time.Microsecond))
+	}
+	curMean, curP50, curP95 := latencyStats(currentLatency)
+	candMean, candP50, candP95 := latencyStats(candidateLatency)
+	fmt.Fprintf(tw, "delta\t%s\t%s\t%s\t\n", signed(candMean-curMean), signed(candP50-curP50), signed(candP95-curP95))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if (changes[i].Ratings > 0) != (changes[j].Ratings > 0) {
+			return changes[i].Ratings > 0
+		}
+		return changes[i].Rating > changes[j].Rating
+	})
+	if len(changes) > sample {
+		changes = changes[:sample]
+	}
+	if len(changes) > 0 {
+		fmt.Fprintf(w, "\nsample of changed answers (%d of %d):\n", len(changes), changed)
+	}
+	for _, c := range changes {
+		rating := "unrated"
+		if c.Ratings > 0 {
+			rating = fmt.Sprintf("rated %.1f by %d", c.Rating, c.Ratings)
+		}
+		fmt.Fprintf(w, "\n  %q (%s)\n", c.Query, rating)
+		if c.Response != "" && c.Response != c.Current.Answer {
+			fmt.Fprintf(w, "    logged:    %s\n", shorten(c.Response, 100))
+		}
+		fmt.Fprintf(w, "    current:   %s\n", shorten(c.Current.Answer, 100))
+		fmt.Fprintf(w, "    candidate: %s\n", shorten(c.Candidate.Answer, 100))
+	}
+	return nil
+}
+
+// runReplayCommand implements "replay", which answers logged queries with
+// the current and a candidate model and reports the differences, and
+// "replay export", which saves the query log as JSONL.
+func runReplayCommand(args []string) error {
+	if len(args) > 0 && args[0] == "export" {
+		return runReplayExport(args[1:])
+	}
+
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	from := fs.String("from", "", "JSONL file from replay export (default: the configured store)")
+	limit := fs.Int("limit", defaultReplayLimit, "most recent logged queries to replay")
+	sample := fs.Int("sample", 10, "changed answers to show")
+	corpusFile := fs.String("corpus", config.Model.CorpusFile, "corpus file of the candidate model")
+	keywordsFile := fs.String("keywords", config.Model.KeywordsFile, "keywords file of the candidate model")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *limit < 1 || *sample < 0 {
+		return errors.New("usage: replay [-from log.jsonl] [-limit n] [-sample n] [-corpus file] [-keywords file]")
+	}
+
+	var log []LoggedQuery
+	var err error
+	if *from != "" {
+		var f *os.File
+		if f, err = os.Open(*from); err != nil {
+			return err
+		}
+		log, err = readQueryLog(f)
+		f.Close()
+		if err == nil && len(log) > *limit {
+			log = log[:*limit]
+		}
+	} else {
+		log, err = storedQueryLog(*limit)
+	}
+	if err != nil {
+		return fmt.Errorf("reading query log: %w", err)
+	}
+	unique := dedupeQueries(log)
+	if len(unique) == 0 {
+		return errors.New("the query log is empty")
+	}
+
+	// Both models answer from their files alone; nothing is written to
+	// the configured store
+	useOfflineStore()
+	config.Retrieval.Variants = nil // Compare the models, not retrieval variants
+	initialize()
+	defer writeQueue.Close(context.Background())
+
+	current := installedModel()
+	files := config.Model
+	files.CorpusFile, files.KeywordsFile = *corpusFile, *keywordsFile
+	candidate, err := loadModel(files, storedDiscoveredIntents())
+	if err != nil {
+		return fmt.Errorf("loading candidate model: %w", err)
+	}
+
+	cases := replay(unique, current, candidate)
+	return printReplayReport(os.Stdout, current, candidate, len(log), cases, *sample)
+}
+
+// runReplayExport implements "replay export [-limit n] file.jsonl".
+func runReplayExport(args []string) error {
+	fs := flag.NewFlagSet("replay export", flag.ContinueOnError)
+	limit := fs.Int("limit", defaultReplayLimit, "most recent logged queries to export")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *limit < 1 {
+		return errors.New("usage: replay export [-limit n] log.jsonl")
+	}
+
+	log, err := storedQueryLog(*limit)
+	if err != nil {
+		return fmt.Errorf("reading query log: %w", err)
+	}
+	f, err := os.Create(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := writeQueryLog(f, log); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d queries to %s\n", len(log), fs.Arg(0))
+	return nil
+}
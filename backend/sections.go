@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Section is a headed block of the Markdown corpus. Sections are kept in
+// document order so that callers can walk through the corpus topic by topic.
+type Section struct {
+	Index  int                // Position of the section in the corpus
+	Level  int                // Heading level (number of leading '#')
+	Title  string             // Heading text without the leading '#'
+	Body   []string           // Lines between this heading and the next one
+	Vector map[string]float64 // TF-IDF vector of the title and body
+	BM25   map[string]float64 // BM25 weights of the title and body terms
+	Source DocSource          // Where the section came from
+}
+
+var corpusSections []Section
+
+// loadCorpusSections splits a Markdown file into sections on its headings.
+// Headings inside fenced code blocks are treated as body text.
+func loadCorpusSections(filename string) ([]Section, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sections []Section
+	var current *Section
+	inFence := false
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+		}
+
+		if !inFence && strings.HasPrefix(line, "#") {
+			level := len(line) - len(strings.TrimLeft(line, "#"))
+			sections = append(sections, Section{
+				Index:  len(sections),
+				Level:  level,
+				Title:  strings.TrimSpace(line[level:]),
+				Source: DocSource{Kind: docMarkdown, File: filename, Line: lineNo},
+			})
+			current = &sections[len(sections)-1]
+			continue
+		}
+
+		if current != nil {
+			current.Body = append(current.Body, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// Weight of heading terms relative to body terms in a section vector.
+const sectionTitleWeight = 3
+
+// BM25 term frequency saturation and length normalisation.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var (
+	sectionIDF  map[string]float64 // Inverse section frequency of each term
+	nonWordChar = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Question words that carry no topic and would otherwise match code samples
+// such as "for i := 0" or prose such as "Go does not".
+var questionWords = map[string]struct{}{
+	"how": {}, "what": {}, "why": {}, "when": {}, "where": {}, "which": {},
+	"who": {}, "do": {}, "does": {}, "i": {}, "can": {}, "me": {}, "my": {},
+	"tell": {}, "explain": {}, "about": {}, "should": {}, "there": {},
+}
+
+// sectionTerms lowercases text, splits it on anything that is not a letter
+// or digit and applies the same stemming used by the TF-IDF model.
+func sectionTerms(text string) []string {
+	var words []string
+	for _, word := range strings.Fields(nonWordChar.ReplaceAllString(strings.ToLower(text), " ")) {
+		if _, skip := questionWords[word]; !skip {
+			words = append(words, word)
+		}
+	}
+	return processWords(words)
+}
+
+// vectorizeSections computes the TF-IDF vector and BM25 weights of every
+// section, treating each section as a document and weighting heading terms
+// more heavily. It returns the IDF of the section terms.
+func vectorizeSections(sections []Section) map[string]float64 {
+	counts := make([]map[string]float64, len(sections))
+	lengths := make([]float64, len(sections))
+	docFreq := make(map[string]int)
+	totalLength := 0.0
+
+	for i, section := range sections {
+		counts[i] = make(map[string]float64)
+		for _, term := range sectionTerms(section.Title) {
+			counts[i][term] += sectionTitleWeight
+		}
+		for _, term := range sectionTerms(strings.Join(section.Body, " ")) {
+			counts[i][term]++
+		}
+		for term, count := range counts[i] {
+			docFreq[term]++
+			lengths[i] += count
+		}
+		totalLength += lengths[i]
+	}
+	averageLength := totalLength / math.Max(1, float64(len(sections)))
+
+	termIDF := make(map[string]float64)
+	for term, df := range docFreq {
+		termIDF[term] = math.Log(1 + float64(len(sections))/float64(df))
+	}
+
+	for i := range sections {
+		sections[i].Vector = make(map[string]float64)
+		sections[i].BM25 = make(map[string]float64)
+		norm := bm25K1 * (1 - bm25B + bm25B*lengths[i]/averageLength)
+		for term, count := range counts[i] {
+			sections[i].Vector[term] = count * termIDF[term]
+			df := float64(docFreq[term])
+			idf := math.Log(1 + (float64(len(sections))-df+0.5)/(df+0.5))
+			sections[i].BM25[term] = idf * count * (bm25K1 + 1) / (count + norm)
+		}
+	}
+	return termIDF
+}
+
+// sectionQueryVector weights the query terms for the variant. TF-IDF
+// weights them with the section IDF; BM25 counts them, as its section
+// weights already carry the IDF.
+func sectionQueryVector(query string, v Variant) map[string]float64 {
+	vector := make(map[string]float64)
+	for _, term := range sectionTerms(query) {
+		if idf, ok := sectionIDF[term]; ok {
+			if v.Weighting == weightingBM25 {
+				vector[term]++
+			} else {
+				vector[term] += idf
+			}
+		}
+	}
+	return vector
+}
+
+// scoreSection returns how similar a section is to the query vector under
+// the variant, or 0 when they share no terms.
+func scoreSection(queryVec map[string]float64, section Section, v Variant) float64 {
+	sectionVec := section.Vector
+	if v.Weighting == weightingBM25 {
+		sectionVec = section.BM25
+	}
+	if v.Similarity != similarityEuclidean {
+		return cosineSimilarity(queryVec, sectionVec)
+	}
+	for term := range queryVec {
+		if _, ok := sectionVec[term]; ok {
+			return 1 / (1 + EuclideanDistance(queryVec, sectionVec))
+		}
+	}
+	return 0
+}
+
+// titleInQuery reports whether the title appears in the query, ignoring
+// case, and is not part of a longer word or dotted name.
+func titleInQuery(query, title string) bool {
+	query, title = strings.ToLower(query), strings.ToLower(title)
+	for start := 0; title != ""; {
+		i := strings.Index(query[start:], title)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(title)
+		before := wordByte(query, i-1) || i > 0 && query[i-1] == '.' && wordByte(query, i-2)
+		after := wordByte(query, end) || end < len(query) && query[end] == '.' && wordByte(query, end+1)
+		if !before && !after {
+			return true
+		}
+		start = i + 1
+	}
+	return false
+}
+
+// wordByte reports whether s[i] is part of a word or identifier.
+func wordByte(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return c == '_' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// rankSections returns up to k sections that share terms with the query,
+// most similar first. Sections whose whole title is in the query come
+// before the rest, so "strings.Builder" ranks the type above its methods,
+// whose titles hold the same terms more often.
+func rankSections(query string, k int, v Variant) []Match {
+	queryVec := sectionQueryVector(query, v)
+
+	var distances []Distance
+	exact := make(map[int]bool)
+	for i, section := range corpusSections {
+		if score := scoreSection(queryVec, section, v); score > 0 {
+			// Sort by decreasing similarity using the KNN distance ordering
+			distances = append(distances, Distance{Index: i, Value: -score})
+			exact[i] = titleInQuery(query, section.Title)
+		}
+	}
+	sort.Stable(ByDistance(distances))
+	sort.SliceStable(distances, func(i, j int) bool {
+		return exact[distances[i].Index] && !exact[distances[j].Index]
+	})
+
+	matches := make([]Match, 0, k)
+	for i := 0; i < k && i < len(distances); i++ {
+		matches = append(matches, Match{Section: corpusSections[distances[i].Index].Title, Score: -distances[i].Value, Source: corpusSections[distances[i].Index].Source})
+	}
+	return matches
+}
+
+// findSection returns the index of the section most similar to the query
+// under the variant and its score, or -1 when nothing matches. As in
+// rankSections, a section whose whole title is in the query comes first.
+func findSection(query string, v Variant) (int, float64) {
+	queryVec := sectionQueryVector(query, v)
+
+	best, bestScore, bestExact := -1, 0.0, false
+	for i, section := range corpusSections {
+		score := scoreSection(queryVec, section, v)
+		if score <= 0 {
+			continue
+		}
+		exact := titleInQuery(query, section.Title)
+		if (exact && !bestExact) || (exact == bestExact && score > bestScore) {
+			best, bestScore, bestExact = i, score, exact
+		}
+	}
+	return best, bestScore
+}
+
+// Text returns the section body with surrounding blank lines removed.
+func (s Section) Text() string {
+	return strings.TrimSpace(strings.Join(s.Body, "\n"))
+}
+
+// Paragraphs splits the section body on blank lines, keeping fenced code
+// blocks together with the paragraph that introduces them.
+func (s Section) Paragraphs() []string {
+	var paragraphs []string
+	var current []string
+	inFence := false
+
+	for _, line := range s.Body {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				paragraphs = append(paragraphs, strings.Join(current, "\n"))
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		paragraphs = append(paragraphs, strings.Join(current, "\n"))
+	}
+	return paragraphs
+}
+
+// Example returns the first fenced code block in the section, or "" if the
+// section has none.
+func (s Section) Example() string {
+	var code []string
+	inFence := false
+
+	for _, line := range s.Body {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if inFence {
+				break
+			}
+			inFence = true
+			// Some fences carry code on the opening line instead of a language tag
+			fence := strings.Index(line, "`")
+			rest := strings.TrimLeft(line[fence:], "`")
+			if strings.ContainsAny(rest, " (\"") {
+				code = append(code, line[:fence]+rest)
+			}
+			continue
+		}
+		if inFence {
+			code = append(code, strings.TrimSuffix(line, "```"))
+		}
+	}
+	return dedent(code)
+}
+
+// dedent removes the indentation shared by all non-blank lines.
+func dedent(lines []string) string {
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// children returns the indexes of the sections nested directly or
+// indirectly under the section at idx.
+func children(sections []Section, idx int) []int {
+	var result []int
+	for i := idx + 1; i < len(sections) && sections[i].Level > sections[idx].Level; i++ {
+		result = append(result, i)
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTitleInQuery(t *testing.T) {
+	tests := []struct {
+		query, title string
+		want         bool
+	}{
+		{"what is strings.Builder", "strings.Builder", true},
+		{"What is STRINGS.BUILDER?", "strings.Builder", true},
+		{"strings.Builder.", "strings.Builder", true},
+		{"what is strings.Builder.String", "strings.Builder", false},
+		{"what is bytes.strings.Builder", "strings.Builder", false},
+		{"explain channels", "Channels", true},
+		{"explain channelsx", "Channels", false},
+		{"buffered channels or channels", "Channels", true},
+		{"anything", "", false},
+	}
+	for _, tt := range tests {
+		if got := titleInQuery(tt.query, tt.title); got != tt.want {
+			t.Errorf("titleInQuery(%q, %q) = %v, want %v", tt.query, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestRankSectionsPrefersExactTitle(t *testing.T) {
+	dir := t.TempDir()
+	src := `// Package strs builds strings.
+package strs
+
+// A Builder builds a string from the strings written to it.
+type Builder struct{}
+
+// String returns the string built so far, the string of strings.
+func (b *Builder) String() string { return "" }
+
+func (b *Builder) Reset() {}
+`
+	if err := os.WriteFile(filepath.Join(dir, "strs.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sections, err := documentGoPackage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, section := range sections {
+		if strings.Contains(strings.Join(section.Body, "\n"), "No documentation") {
+			t.Errorf("section %s has a placeholder body: %q", section.Title, section.Body)
+		}
+		if section.Title == "strs.Builder.Reset" && (len(section.Body) != 1 || !strings.HasPrefix(section.Body[0], "`func")) {
+			t.Errorf("undocumented method body = %q, want only its signature", section.Body)
+		}
+	}
+
+	saved, savedIDF := corpusSections, sectionIDF
+	defer func() { corpusSections, sectionIDF = saved, savedIDF }()
+	corpusSections = sections
+	sectionIDF = vectorizeSections(corpusSections)
+
+	for _, v := range []Variant{{}, {Weighting: weightingBM25}} {
+		matches := rankSections("what is strs.Builder", 3, v)
+		if len(matches) == 0 || matches[0].Section != "strs.Builder" {
+			t.Errorf("rankSections with %+v = %+v, want strs.Builder first", v, matches)
+		}
+		if i, _ := findSection("what is strs.Builder", v); i < 0 || corpusSections[i].Title != "strs.Builder" {
+			t.Errorf("findSection with %+v = %d, want strs.Builder", v, i)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Query is a question put to the bot by any transport.
+type Query struct {
+	Text    string
+	Session *Session // Conversation context; nil for stateless callers
+}
+
+// Match is a corpus section ranked against a query.
+type Match struct {
+	Section string    `json:"section"`
+	Score   float64   `json:"score"`
+	Source  DocSource `json:"source"` // Where the section came from
+}
+
+// Result is the bot's answer to a Query together with how it was found.
+type Result struct {
+	TurnID     string       `json:"turn_id"` // Identifies the turn when rating the answer
+	Answer     string       `json:"answer"`
+	Intent     string       `json:"intent"`            // Classified intent, "" if none matched
+	Confidence float64      `json:"confidence"`        // Similarity of the query to the intent
+	Entities   []string     `json:"entities"`          // Names of the entities the query mentions
+	Links      []EntityLink `json:"links"`             // Where the query mentions them
+	Matches    []Match      `json:"matches"`           // Best corpus sections, most similar first
+	Section    string       `json:"section,omitempty"` // Section the answer came from, if any
+	Score      float64      `json:"score"`             // Similarity of that section to the query
+	Fallback   bool         `json:"fallback"`          // True when no source could answer the query
+	RunnerUp   float64      `json:"-"`                 // Second-best candidate's score relative to the answer's; near 1 is a near tie
+
+	Variant      string        `json:"-"` // Retrieval variant of the session
+	ModelVersion string        `json:"-"` // Version of the model that answered
+	Latency      time.Duration `json:"-"` // Time taken to answer
+}
+
+// Answer runs a query through the full pipeline: guided flows, follow-up
+// resolution, KNN, section retrieval, entity linking and intent
+// classification. It is shared by the WebSocket and REST handlers, and logs
+// through the logger carried by ctx.
+func Answer(ctx context.Context, q Query) Result {
+	start := time.Now()
+	session := q.Session
+	if session == nil {
+		session = newSession()
+	}
+
+	modelMu.RLock() // Held until the answer is counted against the model's intents
+	defer modelMu.RUnlock()
+	result := answer(ctx, session, q.Text)
+	result.TurnID = newTurnID()
+	result.Variant = session.Variant.Name
+	result.ModelVersion = modelVersion
+	result.Latency = time.Since(start)
+	observeAnswer(result)
+	loggerFrom(ctx).Debug("Answered query", queryAttr(q.Text), logTurn, result.TurnID,
+		"intent", result.Intent, "confidence", result.Confidence, "section", result.Section,
+		"score", result.Score, "fallback", result.Fallback, "variant", result.Variant, "latency", result.Latency)
+	return result
+}
+
+// answer runs the pipeline for Answer.
+func answer(ctx context.Context, session *Session, query string) Result {
+	// Guided flows such as "debug my error" take over the conversation while active
+	if reply, ok := handleDialogue(session, query); ok {
+		return Result{Answer: reply, Entities: []string{}, Links: []EntityLink{}, Matches: []Match{}}
+	}
+
+	// Resolve follow-ups such as "more" or "what about buffered ones?" against the session
+	session.Relocate()
+	resolved, kind := session.Resolve(query)
+	if kind != followUpNone {
+		sectionIdx, score := -1, 0.0
+		if kind == followUpExample && resolved != query {
+			sectionIdx, score = findSection(resolved, session.Variant)
+		}
+		response := session.FollowUp(kind, sectionIdx)
+		return Result{Answer: response, Section: session.LastTopic, Score: score, Entities: []string{}, Links: []EntityLink{}, Matches: []Match{}}
+	}
+	asked := query
+	query = resolved
+
+	if ctx.Err() != nil {
+		return Result{Entities: []string{}, Links: []EntityLink{}, Matches: []Match{}}
+	}
+
+	// Extract noun phrases, and link the entities the query mentions. Links
+	// are found in the query as asked, so their offsets point into it
+	// rather than into the resolved follow-up.
+	nounPhrases := extractNounPhrases(query)
+	links := linker.Link(asked)
+	entities := linkedNames(links)
+
+	// Use KNN to get relevant responses
+	knnResponse, knnRunnerUp := handleUserInput(query) // Get response from KNN
+
+	result := Result{Entities: entities, Links: links, Matches: rankSections(query, config.Retrieval.TopMatches, session.Variant)}
+
+	// Combine KNN response with recognized entities
+	if knnResponse != "" {
+		result.Answer = knnResponse
+		result.RunnerUp = knnRunnerUp
+		if len(entities) > 0 {
+			result.Answer += "\n\nRelated Topics: " + strings.Join(entities, ", ")
+		}
+	} else if len(result.Matches) > 0 {
+		// Fall back to the corpus section that best matches the query
+		sectionIdx, score := findSection(query, session.Variant)
+		result.Answer = session.Present(sectionIdx)
+		result.Section, result.Score = corpusSections[sectionIdx].Title, score
+		if len(result.Matches) > 1 && score > 0 {
+			result.RunnerUp = result.Matches[1].Score / score
+		}
+	} else {
+		// If no KNN responses, generate responses based on noun phrases
+		result.Answer = generateResponseFromNounPhrases(nounPhrases)
+		result.Fallback = true
+	}
+
+	// Classify user intent
+	result.Intent, result.Confidence = classifyIntentWithConfidence(query)
+
+	if result.Intent == "" { // Intent is not recognized
+		// Add the new query to discovered intents
+		aggregateDiscoveredIntents(query)
+	}
+
+	// Respond based on classified intent. Greetings and farewells share
+	// words with questions ("how are you", "how do I ..."), so they only
+	// take over a confident match that no section answers well.
+	smallTalk := result.Confidence >= config.Intents.SmallTalkConfidence &&
+		(len(result.Matches) == 0 || result.Matches[0].Score < config.Intents.SmallTalkMaxScore)
+	if smallTalk {
+		switch result.Intent {
+		case "greeting":
+			result.Answer = "Bot: Hello! How can I assist you today?"
+			result.Section, result.Score, result.Fallback, result.RunnerUp = "", 0, false, 0
+		case "farewell":
+			result.Answer = "Bot: Goodbye! Have a great day!"
+			result.Section, result.Score, result.Fallback, result.RunnerUp = "", 0, false, 0
+		}
+	}
+
+	return result
+}
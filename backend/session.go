@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Default idle time after which a conversation forgets its context.
+const defaultSessionIdleTimeout = 30 * time.Minute
+
+// Maximum number of turns kept in a session's history.
+const maxSessionHistory = 20
+
+// Turn is a single query/response exchange within a session.
+type Turn struct {
+	Query    string
+	Response string
+	Time     time.Time
+}
+
+// Session holds the conversational context of a single WebSocket connection
+// so that follow-up queries can be resolved against earlier turns.
+type Session struct {
+	ID           string
+	History      []Turn    // Most recent turns, oldest first
+	LastTopic    string    // Title of the last section the bot talked about
+	LastEntities []string  // Entities recognised in the last query
+	LastSection  int       // Index into corpusSections, -1 if none
+	Variant      Variant   // Retrieval strategy, fixed for the session
+	shown        int       // Number of paragraphs of LastSection already sent
+	dialogue     *Dialogue // Guided flow in progress, nil if none
+	lastActive   time.Time
+}
+
+// followUp identifies the kind of follow-up a query asks for.
+type followUp int
+
+const (
+	followUpNone    followUp = iota
+	followUpMore             // "more", "tell me more"
+	followUpExample          // "show me an example"
+	followUpNext             // "next", "next topic"
+)
+
+var (
+	morePhrases = map[string]struct{}{
+		"more": {}, "tell me more": {}, "more please": {}, "go on": {},
+		"continue": {}, "keep going": {}, "and": {}, "what else": {},
+	}
+	nextPhrases = map[string]struct{}{
+		"next": {}, "next topic": {}, "next section": {}, "what's next": {},
+		"whats next": {}, "next one": {}, "move on": {},
+	}
+	exampleFillers = map[string]struct{}{
+		"show": {}, "me": {}, "an": {}, "a": {}, "example": {}, "examples": {},
+		"give": {}, "please": {}, "of": {}, "for": {}, "can": {}, "you": {},
+		"some": {}, "code": {}, "another": {},
+	}
+	// Only these open an ellipsis; "and how do maps work" is a new question
+	ellipsisPrefix = regexp.MustCompile(`^(what|how) about\s+`)
+	// Personal pronouns anywhere, demonstratives only where they end the
+	// question ("how do I close that?"), not in "is it true that ..."
+	pronounPattern = regexp.MustCompile(`(?i)\b(?:it|its|they|them)\b|\b(?:this|that|these|those)\s*[?!.]*\s*$`)
+	punctuation    = regexp.MustCompile(`[?!.,]+`)
+)
+
+// newSession creates an empty session with a random identifier.
+func newSession() *Session {
+	id := make([]byte, 8)
+	rand.Read(id)
+	sessionID := hex.EncodeToString(id)
+	return &Session{
+		ID:          sessionID,
+		LastSection: -1,
+		Variant:     assignVariant(sessionID, config.Retrieval.Variants),
+		lastActive:  time.Now(),
+	}
+}
+
+// Expired reports whether the session has been idle for longer than timeout.
+func (s *Session) Expired(now time.Time, timeout time.Duration) bool {
+	return now.Sub(s.lastActive) > timeout
+}
+
+// Reset drops the conversational context but keeps the session ID.
+func (s *Session) Reset() {
+	s.History = nil
+	s.LastTopic = ""
+	s.LastEntities = nil
+	s.LastSection = -1
+	s.shown = 0
+	s.dialogue = nil
+	s.lastActive = time.Now()
+}
+
+// Resolve rewrites a follow-up query against the session context. It returns
+// the query to run through the pipeline and, for "more", "example" and
+// "next" requests, the kind of follow-up that was asked for.
+func (s *Session) Resolve(query string) (string, followUp) {
+	normalized := strings.TrimSpace(punctuation.ReplaceAllString(strings.ToLower(query), ""))
+
+	if s.LastSection >= 0 {
+		if _, ok := morePhrases[normalized]; ok {
+			return query, followUpMore
+		}
+		if _, ok := nextPhrases[normalized]; ok {
+			return query, followUpNext
+		}
+	}
+
+	if strings.Contains(normalized, "example") {
+		var topic []string
+		for _, word := range strings.Fields(normalized) {
+			if _, filler := exampleFillers[word]; !filler {
+				topic = append(topic, word)
+			}
+		}
+		if len(topic) == 0 && s.LastSection >= 0 {
+			return query, followUpExample
+		}
+		if len(topic) > 0 {
+			return s.substitute(strings.Join(topic, " ")), followUpExample
+		}
+	}
+
+	if s.LastTopic == "" {
+		return query, followUpNone
+	}
+
+	// "what about buffered ones?" -> "buffered channels"
+	if ellipsisPrefix.MatchString(normalized) {
+		rest := ellipsisPrefix.ReplaceAllString(normalized, "")
+		words := strings.Fields(strings.ToLower(s.LastTopic))
+		head := words[len(words)-1]
+		if strings.Contains(" "+rest+" ", " ones ") || strings.Contains(" "+rest+" ", " one ") {
+			rest = strings.NewReplacer(" ones", " "+head, " one", " "+head).Replace(" " + rest)
+			return strings.TrimSpace(rest), followUpNone
+		}
+		return rest + " " + strings.ToLower(s.LastTopic), followUpNone
+	}
+
+	return s.substitute(query), followUpNone
+}
+
+// substitute replaces the first pronoun in the query with the current
+// topic, in the possessive for "its". A query without one is returned
+// unchanged.
+func (s *Session) substitute(query string) string {
+	topic := strings.ToLower(s.LastTopic)
+	if topic == "" && len(s.LastEntities) > 0 {
+		topic = s.LastEntities[0]
+	}
+	loc := pronounPattern.FindStringIndex(query)
+	if topic == "" || loc == nil {
+		return query
+	}
+	match := query[loc[0]:loc[1]]
+	trailing := match[len(strings.TrimRight(match, "?!. \t")):] // Keep the question mark of "close that?"
+	if strings.EqualFold(match, "its") {
+		topic = possessive(topic)
+	}
+	return query[:loc[0]] + topic + trailing + query[loc[1]:]
+}
+
+// possessive returns the possessive form of a topic: "goroutine's",
+// "channels'".
+func possessive(topic string) string {
+	if strings.HasSuffix(topic, "s") {
+		return topic + "'"
+	}
+	return topic + "'s"
+}
+
+// Relocate finds the current section again after the model was reloaded,
+// by its title. If the new corpus does not have it, only the topic is kept
+// for resolving pronouns.
+func (s *Session) Relocate() {
+	if s.LastSection < 0 || (s.LastSection < len(corpusSections) && corpusSections[s.LastSection].Title == s.LastTopic) {
+		return
+	}
+	s.LastSection = -1
+	if section, ok := sectionByTitle(s.LastTopic); ok {
+		s.LastSection = section.Index
+	}
+}
+
+// Present returns the opening paragraphs of a section and makes it the
+// current topic of the session.
+func (s *Session) Present(idx int) string {
+	s.LastSection = idx
+	s.LastTopic = corpusSections[idx].Title
+	s.shown = 0
+	if chunk := s.nextChunk(); chunk != "" {
+		return chunk
+	}
+
+	var titles []string
+	for _, i := range children(corpusSections, idx) {
+		titles = append(titles, corpusSections[i].Title)
+	}
+	if len(titles) == 0 {
+		return s.LastTopic
+	}
+	return s.LastTopic + " covers: " + strings.Join(titles, ", ")
+}
+
+// FollowUp answers a "more", "example" or "next" request about the current
+// section. For examples about a new topic, idx is the section to use.
+func (s *Session) FollowUp(kind followUp, idx int) string {
+	switch kind {
+	case followUpMore:
+		if response := s.nextChunk(); response != "" {
+			return response
+		}
+		if next := s.LastSection + 1; next < len(corpusSections) {
+			return "That's everything I have on " + s.LastTopic + ". Say \"next\" to continue with " + corpusSections[next].Title + "."
+		}
+		return "That's everything I have on " + s.LastTopic + "."
+
+	case followUpNext:
+		if next := s.LastSection + 1; next < len(corpusSections) {
+			return s.Present(next)
+		}
+		return "That was the last topic in the corpus."
+
+	case followUpExample:
+		if idx < 0 {
+			idx = s.LastSection
+		}
+		if idx < 0 {
+			return "Which topic would you like an example for?"
+		}
+		if idx != s.LastSection {
+			s.LastSection = idx
+			s.LastTopic = corpusSections[idx].Title
+			s.shown = 0
+		}
+		// Parent sections often keep their examples in their subsections
+		for _, i := range append([]int{idx}, children(corpusSections, idx)...) {
+			if example := corpusSections[i].Example(); example != "" {
+				return "Example for " + corpusSections[i].Title + ":\n" + example
+			}
+		}
+		return "I don't have an example for " + s.LastTopic + "."
+	}
+	return ""
+}
+
+// nextChunk returns the next unseen paragraph of the current section.
+func (s *Session) nextChunk() string {
+	paragraphs := corpusSections[s.LastSection].Paragraphs()
+	if s.shown >= len(paragraphs) {
+		return ""
+	}
+	chunk := paragraphs[s.shown]
+	if s.shown == 0 {
+		chunk = corpusSections[s.LastSection].Title + "\n" + chunk
+	}
+	s.shown++
+	return chunk
+}
+
+// Record appends a turn to the session history.
+func (s *Session) Record(query, response string, entities []string) {
+	s.History = append(s.History, Turn{Query: query, Response: response, Time: time.Now()})
+	if len(s.History) > maxSessionHistory {
+		s.History = s.History[len(s.History)-maxSessionHistory:]
+	}
+	if len(entities) > 0 {
+		s.LastEntities = entities
+	}
+	s.lastActive = time.Now()
+}
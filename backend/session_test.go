@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	config = defaultConfig()
+	tests := []struct {
+		query, topic string
+		want         string
+		wantFollowUp followUp
+	}{
+		{"what about buffered ones?", "Channels", "buffered channels", followUpNone},
+		{"how about maps", "Channels", "maps channels", followUpNone},
+		{"and how do maps work", "Channels", "and how do maps work", followUpNone},
+		{"and", "Channels", "and", followUpMore},
+		{"how do I close it?", "Channels", "how do I close channels?", followUpNone},
+		{"what is its capacity", "Buffered Channels", "what is buffered channels' capacity", followUpNone},
+		{"what is its zero value", "Goroutine", "what is goroutine's zero value", followUpNone},
+		{"Its length?", "Slice", "slice's length?", followUpNone},
+		{"how do I close that?", "Channels", "how do I close channels?", followUpNone},
+		{"what is a map", "Channels", "what is a map", followUpNone},
+		{"show me an example", "Channels", "show me an example", followUpExample},
+		{"example of it", "Channels", "channels", followUpExample},
+		{"how do I close it?", "", "how do I close it?", followUpNone},
+	}
+	for _, tt := range tests {
+		session := newSession()
+		session.LastTopic = tt.topic
+		if tt.topic != "" {
+			session.LastSection = 0
+		}
+		got, followUp := session.Resolve(tt.query)
+		if got != tt.want || followUp != tt.wantFollowUp {
+			t.Errorf("Resolve(%q) after %q = %q, %v; want %q, %v", tt.query, tt.topic, got, followUp, tt.want, tt.wantFollowUp)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Interaction is a stored query and the response the bot gave to it.
+type Interaction struct {
+	Query    string
+	Response string
+}
+
+// LoggedQuery is a stored query with what is known about the answer it
+// got, as replayed against candidate models.
+type LoggedQuery struct {
+	Query        string  `json:"query"`
+	Response     string  `json:"response"`
+	Rating       float64 `json:"rating,omitempty"`        // Mean feedback rating, 0 if never rated
+	Ratings      int     `json:"ratings,omitempty"`       // Number of ratings
+	LatencyMS    int64   `json:"latency_ms,omitempty"`    // 0 if not recorded
+	ModelVersion string  `json:"model_version,omitempty"` // Empty if not recorded
+}
+
+// VariantStats counts the turns answered by a retrieval variant and the
+// feedback they received.
+type VariantStats struct {
+	Variant       string
+	Turns         int
+	Fallbacks     int
+	Ratings       int
+	AverageRating float64 // 0 without ratings
+}
+
+// LabelCriteria selects the turns worth labelling.
+type LabelCriteria struct {
+	MinConfidence float64 // Matched intents below this are uncertain
+	TieRatio      float64 // Runner-ups at or above this are near ties
+	MaxRating     int     // Ratings at or below this are poor
+	Limit         int     // Most recent candidate turns returned
+}
+
+// LabelCandidate is a stored turn that meets LabelCriteria.
+type LabelCandidate struct {
+	Turn      TurnRecord
+	MinRating int // Lowest feedback rating of the turn, 0 if unrated
+}
+
+// Label is a trainer's decision on a queued query.
+type Label struct {
+	Query    string `json:"query"`
+	TurnID   string `json:"turn_id,omitempty"` // Turn the trainer looked at, if any
+	Section  string `json:"section,omitempty"` // Corpus section that answers the query
+	Answer   string `json:"answer,omitempty"`  // Text trained for the query
+	Status   string `json:"status"`            // labelled or dismissed
+	Labeller string `json:"labeller,omitempty"`
+}
+
+// Store persists everything the bot learns from its users. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	// WriteBatch records a batch of queued sessions, turns, feedback and
+	// discovered intent phrases, all or nothing.
+	WriteBatch(ctx context.Context, batch []WriteOp) error
+	// SaveTrainingData records a query/answer pair submitted for training.
+	SaveTrainingData(ctx context.Context, data TrainingData) error
+	// Interactions returns every stored interaction and conversation turn,
+	// including training data.
+	Interactions(ctx context.Context) ([]Interaction, error)
+	// QueryLog returns up to limit stored interactions and turns, most
+	// recent first, with their feedback ratings.
+	QueryLog(ctx context.Context, limit int) ([]LoggedQuery, error)
+	// VariantStats summarises the turns of each retrieval variant, ordered
+	// by variant. Turns stored without a variant are left out.
+	VariantStats(ctx context.Context) ([]VariantStats, error)
+	// LabelCandidates returns the most recent turns that meet c, skipping
+	// queries that already have a label.
+	LabelCandidates(ctx context.Context, c LabelCriteria) ([]LabelCandidate, error)
+	// SaveLabel records a label, replacing any earlier label of its query.
+	SaveLabel(ctx context.Context, label Label) error
+	// DiscoveredIntents returns every discovered intent and its phrases.
+	DiscoveredIntents(ctx context.Context) (map[string][]string, error)
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// Storage backends accepted by openStore.
+const (
+	storeMySQL  = "mysql"
+	storeSQLite = "sqlite"
+	storeMemory = "memory"
+)
+
+// openStore opens the storage backend named by driver. dsn is the
+// driver-specific data source name and is ignored by the memory store.
+func openStore(driver, dsn string) (Store, error) {
+	switch driver {
+	case storeMySQL, storeSQLite:
+		return openSQLStore(driver, dsn)
+	case storeMemory:
+		return newMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown storage driver %q (want %s, %s or %s)", driver, storeMySQL, storeSQLite, storeMemory)
+}
+
+// memoryStore keeps everything in process memory. It is meant for
+// development and tests; nothing survives a restart.
+type memoryStore struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	sessions     []SessionRecord
+	turns        []TurnRecord
+	feedback     []Feedback
+	intents      map[string][]string
+	labels       map[string]Label // By query
+	ids          map[string]bool  // Stored session and turn ids, by kind
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{intents: make(map[string][]string), labels: make(map[string]Label), ids: make(map[string]bool)}
+}
+
+func (m *memoryStore) WriteBatch(ctx context.Context, batch []WriteOp) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check the whole batch first, so it is written all or nothing. Ids are
+	// unique, as the primary keys of the SQL stores.
+	ids := make(map[string]bool)
+	for _, op := range batch {
+		var id string
+		switch op.Kind {
+		case writeSession:
+			id = op.Session.ID
+		case writeTurn:
+			id = op.Turn.ID
+		case writeFeedback, writeIntent:
+			continue
+		default:
+			return fmt.Errorf("unknown write kind %q", op.Kind)
+		}
+		key := op.Kind + ":" + id
+		if ids[key] || m.ids[key] {
+			return fmt.Errorf("duplicate %s id %q", op.Kind, id)
+		}
+		ids[key] = true
+	}
+
+	for _, op := range batch {
+		switch op.Kind {
+		case writeSession:
+			m.sessions = append(m.sessions, *op.Session)
+		case writeTurn:
+			m.turns = append(m.turns, *op.Turn)
+		case writeFeedback:
+			m.feedback = append(m.feedback, *op.Feedback)
+		case writeIntent:
+			// Phrases are stored ';'-separated, as in the SQL stores
+			m.intents[op.Intent.Name] = append(m.intents[op.Intent.Name], strings.Split(op.Intent.Phrases, ";")...)
+		}
+	}
+	for id := range ids {
+		m.ids[id] = true
+	}
+	return nil
+}
+
+func (m *memoryStore) SaveTrainingData(ctx context.Context, data TrainingData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interactions = append(m.interactions, Interaction{Query: data.Query, Response: data.Answer})
+	return nil
+}
+
+func (m *memoryStore) Interactions(ctx context.Context) ([]Interaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	interactions := append([]Interaction(nil), m.interactions...)
+	for _, turn := range m.turns {
+		interactions = append(interactions, Interaction{Query: turn.Query, Response: turn.Response})
+	}
+	return interactions, nil
+}
+
+// QueryLog lists turns before interactions, since the memory store keeps
+// no timestamps to interleave them by.
+func (m *memoryStore) QueryLog(ctx context.Context, limit int) ([]LoggedQuery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var log []LoggedQuery
+	rate := func(q *LoggedQuery, match func(f Feedback) bool) {
+		total := 0
+		for _, f := range m.feedback {
+			if match(f) {
+				total += f.Rating
+				q.Ratings++
+			}
+		}
+		if q.Ratings > 0 {
+			q.Rating = float64(total) / float64(q.Ratings)
+		}
+	}
+	for i := len(m.turns) - 1; i >= 0 && len(log) < limit; i-- {
+		turn := m.turns[i]
+		q := LoggedQuery{Query: turn.Query, Response: turn.Response, LatencyMS: turn.LatencyMS, ModelVersion: turn.ModelVersion}
+		rate(&q, func(f Feedback) bool { return f.TurnID == turn.ID })
+		log = append(log, q)
+	}
+	for i := len(m.interactions) - 1; i >= 0 && len(log) < limit; i-- {
+		interaction := m.interactions[i]
+		q := LoggedQuery{Query: interaction.Query, Response: interaction.Response}
+		rate(&q, func(f Feedback) bool {
+			return f.TurnID == "" && f.Query == interaction.Query && f.Response == interaction.Response
+		})
+		log = append(log, q)
+	}
+	return log, nil
+}
+
+func (m *memoryStore) VariantStats(ctx context.Context) ([]VariantStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byVariant := make(map[string]*VariantStats)
+	variantOf := make(map[string]string) // Turn id to variant
+	for _, turn := range m.turns {
+		if turn.Variant == "" {
+			continue
+		}
+		s, ok := byVariant[turn.Variant]
+		if !ok {
+			s = &VariantStats{Variant: turn.Variant}
+			byVariant[turn.Variant] = s
+		}
+		s.Turns++
+		if turn.Fallback {
+			s.Fallbacks++
+		}
+		variantOf[turn.ID] = turn.Variant
+	}
+	for _, f := range m.feedback {
+		if s, ok := byVariant[variantOf[f.TurnID]]; ok {
+			s.AverageRating += float64(f.Rating) // Summed here, averaged below
+			s.Ratings++
+		}
+	}
+
+	stats := make([]VariantStats, 0, len(byVariant))
+	for _, s := range byVariant {
+		if s.Ratings > 0 {
+			s.AverageRating /= float64(s.Ratings)
+		}
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Variant < stats[j].Variant })
+	return stats, nil
+}
+
+func (m *memoryStore) LabelCandidates(ctx context.Context, c LabelCriteria) ([]LabelCandidate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	minRatings := make(map[string]int)
+	for _, f := range m.feedback {
+		if r, ok := minRatings[f.TurnID]; f.TurnID != "" && (!ok || f.Rating < r) {
+			minRatings[f.TurnID] = f.Rating
+		}
+	}
+	var candidates []LabelCandidate
+	for i := len(m.turns) - 1; i >= 0 && len(candidates) < c.Limit; i-- {
+		turn := m.turns[i]
+		if _, labelled := m.labels[turn.Query]; labelled {
+			continue
+		}
+		rating := minRatings[turn.ID]
+		if turn.Fallback || (turn.Intent != "" && turn.Confidence < c.MinConfidence) ||
+			turn.RunnerUp >= c.TieRatio || (rating > 0 && rating <= c.MaxRating) {
+			candidates = append(candidates, LabelCandidate{Turn: turn, MinRating: rating})
+		}
+	}
+	return candidates, nil
+}
+
+func (m *memoryStore) SaveLabel(ctx context.Context, label Label) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.labels[label.Query] = label
+	return nil
+}
+
+func (m *memoryStore) DiscoveredIntents(ctx context.Context) (map[string][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	intents := make(map[string][]string, len(m.intents))
+	for name, phrases := range m.intents {
+		intents[name] = append([]string(nil), phrases...)
+	}
+	return intents, nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
+)
+
+// sqlStore implements Store on top of database/sql. Statements that differ
+// between SQL dialects are chosen from the driver name.
+type sqlStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// openSQLStore connects to a MySQL or SQLite database. The schema is
+// managed by the migrations in migrate.go.
+func openSQLStore(driver, dsn string) (*sqlStore, error) {
+	if driver == storeSQLite && !strings.Contains(dsn, "_time_format=") {
+		// Write timestamps in SQLite's own format, matching CURRENT_TIMESTAMP
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "_time_format=sqlite"
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if driver == storeSQLite {
+		// SQLite allows a single writer; serialise access instead of failing with SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+	return &sqlStore{db: db, driver: driver}, nil
+}
+
+// batchInsert describes the multi-row INSERT for one kind of queued write.
+// WriteBatch repeats the placeholder group once per row.
+type batchInsert struct {
+	prefix, row string
+	args        func(op WriteOp) []interface{}
+	upsert      map[string]string // Clause appended for each driver, if any
+}
+
+// Inserts for each kind of queued write, in the order WriteBatch runs them.
+var batchInserts = []struct {
+	kind   string
+	insert batchInsert
+}{
+	{writeSession, batchInsert{
+		"INSERT INTO sessions(id, transport, started_at) VALUES ", "(?, ?, ?)",
+		func(op WriteOp) []interface{} {
+			return []interface{}{op.Session.ID, op.Session.Transport, op.Session.Started.UTC()}
+		},
+		nil,
+	}},
+	{writeTurn, batchInsert{
+		"INSERT INTO turns(id, session_id, query, response, intent, confidence, section, score, fallback, runner_up, model_version, variant, latency_ms, created_at) VALUES ",
+		"(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		func(op WriteOp) []interface{} {
+			t := op.Turn
+			return []interface{}{t.ID, nullString(t.SessionID), t.Query, t.Response, t.Intent, t.Confidence,
+				t.Section, t.Score, t.Fallback, t.RunnerUp, t.ModelVersion, t.Variant, t.LatencyMS, op.Time.UTC()}
+		},
+		nil,
+	}},
+	{writeFeedback, batchInsert{
+		"INSERT INTO feedback(query, response, rating, turn_id, created_at) VALUES ", "(?, ?, ?, ?, ?)",
+		func(op WriteOp) []interface{} {
+			f := op.Feedback
+			return []interface{}{f.Query, f.Response, f.Rating, nullString(f.TurnID), op.Time.UTC()}
+		},
+		nil,
+	}},
+	{writeIntent, batchInsert{
+		"INSERT INTO discovered_intents (intent_name, training_phrases) VALUES ", "(?, ?)",
+		func(op WriteOp) []interface{} {
+			return []interface{}{op.Intent.Name, op.Intent.Phrases}
+		},
+		// The UNIQUE key on intent_name turns a known intent into an append
+		map[string]string{
+			storeMySQL:  " ON DUPLICATE KEY UPDATE training_phrases = CONCAT(training_phrases, ';', VALUES(training_phrases))",
+			storeSQLite: " ON CONFLICT(intent_name) DO UPDATE SET training_phrases = training_phrases || ';' || excluded.training_phrases",
+		},
+	}},
+}
+
+func (s *sqlStore) WriteBatch(ctx context.Context, batch []WriteOp) error {
+	// Group the rows by table so each table gets one multi-row INSERT
+	groups := make(map[string][]WriteOp)
+	for _, op := range batch {
+		groups[op.Kind] = append(groups[op.Kind], op)
+	}
+	for kind := range groups {
+		if !knownWriteKind(kind) {
+			return fmt.Errorf("unknown write kind %q", kind)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, entry := range batchInserts {
+		ops := groups[entry.kind]
+		if len(ops) == 0 {
+			continue
+		}
+		var query strings.Builder
+		query.WriteString(entry.insert.prefix)
+		var args []interface{}
+		for i, op := range ops {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString(entry.insert.row)
+			args = append(args, entry.insert.args(op)...)
+		}
+		query.WriteString(entry.insert.upsert[s.driver])
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func knownWriteKind(kind string) bool {
+	for _, entry := range batchInserts {
+		if entry.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// nullString stores an empty string as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *sqlStore) SaveTrainingData(ctx context.Context, data TrainingData) error {
+	// Training data lives alongside real interactions so retraining picks it up
+	_, err := s.db.ExecContext(ctx, "INSERT INTO interactions(query, response) VALUES(?, ?)", data.Query, data.Answer)
+	return err
+}
+
+func (s *sqlStore) Interactions(ctx context.Context) ([]Interaction, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT query, response FROM interactions UNION ALL SELECT query, response FROM turns")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var interactions []Interaction
+	for rows.Next() {
+		var i Interaction
+		if err := rows.Scan(&i.Query, &i.Response); err != nil {
+			return nil, err
+		}
+		interactions = append(interactions, i)
+	}
+	return interactions, rows.Err()
+}
+
+// queryLogQuery lists turns and legacy interactions with their ratings.
+// Turns are rated through feedback.turn_id, interactions through feedback
+// rows that repeat their query and response.
+const queryLogQuery = `SELECT query, response, rating, ratings, latency_ms, model_version FROM (
+	SELECT t.query, t.response,
+		(SELECT AVG(f.rating) FROM feedback f WHERE f.turn_id = t.id) AS rating,
+		(SELECT COUNT(*) FROM feedback f WHERE f.turn_id = t.id) AS ratings,
+		t.latency_ms, t.model_version, t.created_at
+	FROM turns t
+	UNION ALL
+	SELECT i.query, i.response,
+		(SELECT AVG(f.rating) FROM feedback f WHERE f.turn_id IS NULL AND f.query = i.query AND f.response = i.response),
+		(SELECT COUNT(*) FROM feedback f WHERE f.turn_id IS NULL AND f.query = i.query AND f.response = i.response),
+		0, '', i.created_at
+	FROM interactions i
+) logged ORDER BY created_at DESC LIMIT ?`
+
+func (s *sqlStore) QueryLog(ctx context.Context, limit int) ([]LoggedQuery, error) {
+	rows, err := s.db.QueryContext(ctx, queryLogQuery, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var log []LoggedQuery
+	for rows.Next() {
+		var q LoggedQuery
+		var rating sql.NullFloat64
+		if err := rows.Scan(&q.Query, &q.Response, &rating, &q.Ratings, &q.LatencyMS, &q.ModelVersion); err != nil {
+			return nil, err
+		}
+		q.Rating = rating.Float64
+		log = append(log, q)
+	}
+	return log, rows.Err()
+}
+
+// variantStatsQuery counts turns and fallbacks per variant, joined with
+// the ratings given to those turns.
+const variantStatsQuery = `SELECT t.variant, COUNT(*), SUM(CASE WHEN t.fallback THEN 1 ELSE 0 END),
+	COALESCE(MAX(r.ratings), 0), MAX(r.rating)
+FROM turns t
+LEFT JOIN (
+	SELECT rt.variant, COUNT(*) AS ratings, AVG(f.rating) AS rating
+	FROM feedback f JOIN turns rt ON rt.id = f.turn_id
+	GROUP BY rt.variant
+) r ON r.variant = t.variant
+WHERE t.variant <> ''
+GROUP BY t.variant
+ORDER BY t.variant`
+
+func (s *sqlStore) VariantStats(ctx context.Context) ([]VariantStats, error) {
+	rows, err := s.db.QueryContext(ctx, variantStatsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []VariantStats
+	for rows.Next() {
+		var v VariantStats
+		var rating sql.NullFloat64
+		if err := rows.Scan(&v.Variant, &v.Turns, &v.Fallbacks, &v.Ratings, &rating); err != nil {
+			return nil, err
+		}
+		v.AverageRating = rating.Float64
+		stats = append(stats, v)
+	}
+	return stats, rows.Err()
+}
+
+// labelCandidatesQuery lists recent turns that fell back, matched an intent
+// with low confidence, nearly tied or were rated poorly, skipping labelled
+// queries.
+const labelCandidatesQuery = `SELECT t.id, t.query, t.response, t.intent, t.confidence, t.section, t.score,
+	t.fallback, t.runner_up, t.model_version, t.variant,
+	COALESCE((SELECT MIN(f.rating) FROM feedback f WHERE f.turn_id = t.id), 0)
+FROM turns t
+WHERE NOT EXISTS (SELECT 1 FROM labels l WHERE l.query = t.query)
+	AND (t.fallback <> 0
+		OR (t.intent <> '' AND t.confidence < ?)
+		OR t.runner_up >= ?
+		OR EXISTS (SELECT 1 FROM feedback f WHERE f.turn_id = t.id AND f.rating <= ?))
+ORDER BY t.created_at DESC LIMIT ?`
+
+func (s *sqlStore) LabelCandidates(ctx context.Context, c LabelCriteria) ([]LabelCandidate, error) {
+	rows, err := s.db.QueryContext(ctx, labelCandidatesQuery, c.MinConfidence, c.TieRatio, c.MaxRating, c.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []LabelCandidate
+	for rows.Next() {
+		var lc LabelCandidate
+		t := &lc.Turn
+		if err := rows.Scan(&t.ID, &t.Query, &t.Response, &t.Intent, &t.Confidence, &t.Section, &t.Score,
+			&t.Fallback, &t.RunnerUp, &t.ModelVersion, &t.Variant, &lc.MinRating); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, lc)
+	}
+	return candidates, rows.Err()
+}
+
+func (s *sqlStore) SaveLabel(ctx context.Context, label Label) error {
+	// REPLACE is understood by both MySQL and SQLite
+	_, err := s.db.ExecContext(ctx,
+		"REPLACE INTO labels(query, turn_id, section, answer, status, labeller) VALUES (?, ?, ?, ?, ?, ?)",
+		label.Query, nullString(label.TurnID), label.Section, label.Answer, label.Status, label.Labeller)
+	return err
+}
+
+func (s *sqlStore) DiscoveredIntents(ctx context.Context) (map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT intent_name, training_phrases FROM discovered_intents")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	intents := make(map[string][]string)
+	for rows.Next() {
+		var name, phrases string
+		if err := rows.Scan(&name, &phrases); err != nil {
+			return nil, err
+		}
+		intents[name] = strings.Split(phrases, ";") // Phrases are stored ';'-separated
+	}
+	return intents, rows.Err()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Set STORE_TEST_MYSQL_DSN to run the store tests against MySQL as well,
+// e.g. "gobot:secret@tcp(127.0.0.1:3306)/gobot_test". The database is
+// migrated and every table is emptied before each test.
+const mysqlTestDSN = "STORE_TEST_MYSQL_DSN"
+
+// storeFactories open an empty store of each backend under test.
+func storeFactories(t *testing.T) map[string]func(t *testing.T) Store {
+	factories := map[string]func(t *testing.T) Store{
+		storeMemory: func(t *testing.T) Store { return newMemoryStore() },
+		storeSQLite: func(t *testing.T) Store { return openTestSQLStore(t, storeSQLite, "file::memory:") },
+	}
+	if dsn := os.Getenv(mysqlTestDSN); dsn != "" {
+		factories[storeMySQL] = func(t *testing.T) Store {
+			s := openTestSQLStore(t, storeMySQL, dsn)
+			for _, table := range []string{"feedback", "turns", "sessions", "labels", "interactions", "discovered_intents"} {
+				if _, err := s.db.Exec("DELETE FROM " + table); err != nil {
+					t.Fatalf("emptying %s: %v", table, err)
+				}
+			}
+			return s
+		}
+	} else {
+		t.Logf("%s is not set; skipping MySQL", mysqlTestDSN)
+	}
+	return factories
+}
+
+func openTestSQLStore(t *testing.T, driver, dsn string) *sqlStore {
+	t.Helper()
+	s, err := openSQLStore(driver, dsn)
+	if err != nil {
+		t.Fatalf("opening %s store: %v", driver, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if _, err := s.MigrateUp(context.Background()); err != nil {
+		t.Fatalf("migrating %s store: %v", driver, err)
+	}
+	return s
+}
+
+// storeFixture is a store under test. It writes one second apart, oldest
+// first, so every store lists turns in the same order.
+type storeFixture struct {
+	t     *testing.T
+	store Store
+	now   time.Time
+}
+
+func (f *storeFixture) write(ops ...WriteOp) {
+	f.t.Helper()
+	for i := range ops {
+		f.now = f.now.Add(time.Second)
+		ops[i].Time = f.now
+	}
+	if err := f.store.WriteBatch(context.Background(), ops); err != nil {
+		f.t.Fatalf("WriteBatch: %v", err)
+	}
+}
+
+func turnOp(turn TurnRecord) WriteOp {
+	return WriteOp{Kind: writeTurn, Turn: &turn}
+}
+
+func feedbackOp(turnID string, rating int) WriteOp {
+	return WriteOp{Kind: writeFeedback, Feedback: &Feedback{TurnID: turnID, Query: "q", Response: "r", Rating: rating}}
+}
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, f *storeFixture)
+	}{
+		{"interactions", testStoreInteractions},
+		{"failed batch", testStoreFailedBatch},
+		{"query log", testStoreQueryLog},
+		{"variant stats", testStoreVariantStats},
+		{"label candidates", testStoreLabelCandidates},
+		{"discovered intents", testStoreDiscoveredIntents},
+	}
+	for driver, open := range storeFactories(t) {
+		t.Run(driver, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, &storeFixture{t: t, store: open(t), now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})
+				})
+			}
+		})
+	}
+}
+
+// Interactions, training data and turns are all listed as interactions.
+func testStoreInteractions(t *testing.T, f *storeFixture) {
+	ctx := context.Background()
+	if err := f.store.SaveTrainingData(ctx, TrainingData{Query: "what is a slice", Answer: "A slice is ..."}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.SaveTrainingData(ctx, TrainingData{Query: "what is a map", Answer: "A map is ..."}); err != nil {
+		t.Fatal(err)
+	}
+	f.write(
+		WriteOp{Kind: writeSession, Session: &SessionRecord{ID: "s1", Transport: "websocket", Started: f.now}},
+		turnOp(TurnRecord{ID: "0000000000000001", SessionID: "s1", Query: "what is a channel", Response: "A channel is ..."}),
+	)
+
+	got, err := f.store.Interactions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Query < got[j].Query })
+	want := []Interaction{
+		{Query: "what is a channel", Response: "A channel is ..."},
+		{Query: "what is a map", Response: "A map is ..."},
+		{Query: "what is a slice", Response: "A slice is ..."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Interactions = %+v, want %+v", got, want)
+	}
+}
+
+// A batch with a write the store cannot make is not written at all,
+// whether the store rejects it up front or a statement fails part way.
+func testStoreFailedBatch(t *testing.T, f *storeFixture) {
+	ctx := context.Background()
+	batch := []WriteOp{
+		turnOp(TurnRecord{ID: "0000000000000001", Query: "what is a channel", Response: "A channel is ..."}),
+		{Kind: "bogus", Time: f.now},
+	}
+	if err := f.store.WriteBatch(ctx, batch); err == nil {
+		t.Fatal("WriteBatch accepted an unknown write kind")
+	}
+	if got, err := f.store.Interactions(ctx); err != nil || len(got) != 0 {
+		t.Errorf("Interactions after a failed batch = %+v, %v; want none", got, err)
+	}
+
+	// The session is written before the turns, whose duplicate id fails
+	f.write(turnOp(TurnRecord{ID: "0000000000000001", Query: "first", Response: "r1"}))
+	session := WriteOp{Kind: writeSession, Session: &SessionRecord{ID: "s1", Transport: "rest", Started: f.now}}
+	second := turnOp(TurnRecord{ID: "0000000000000002", SessionID: "s1", Query: "second", Response: "r2"})
+	batch = []WriteOp{session, second, turnOp(TurnRecord{ID: "0000000000000001", Query: "again", Response: "r3"})}
+	if err := f.store.WriteBatch(ctx, batch); err == nil {
+		t.Fatal("WriteBatch accepted a duplicate turn id")
+	}
+	if got, err := f.store.Interactions(ctx); err != nil || len(got) != 1 || got[0].Query != "first" {
+		t.Errorf("Interactions after a rolled back batch = %+v, %v; want the first turn only", got, err)
+	}
+	// Writing the session again succeeds only if the failed batch left none
+	f.write(session, second)
+	if got, err := f.store.Interactions(ctx); err != nil || len(got) != 2 {
+		t.Errorf("Interactions after the retry = %+v, %v; want two turns", got, err)
+	}
+}
+
+// The query log lists turns most recent first, rated by their feedback,
+// and interactions rated by feedback repeating their query and response.
+func testStoreQueryLog(t *testing.T, f *storeFixture) {
+	ctx := context.Background()
+	f.write(
+		turnOp(TurnRecord{ID: "0000000000000001", Query: "older", Response: "r1", LatencyMS: 12, ModelVersion: "v1"}),
+		turnOp(TurnRecord{ID: "0000000000000002", Query: "newer", Response: "r2", LatencyMS: 34, ModelVersion: "v2"}),
+	)
+	f.write(feedbackOp("0000000000000002", 2), feedbackOp("0000000000000002", 5))
+
+	log, err := f.store.QueryLog(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LoggedQuery{
+		{Query: "newer", Response: "r2", Rating: 3.5, Ratings: 2, LatencyMS: 34, ModelVersion: "v2"},
+		{Query: "older", Response: "r1", LatencyMS: 12, ModelVersion: "v1"},
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("QueryLog = %+v, want %+v", log, want)
+	}
+	if log, err := f.store.QueryLog(ctx, 1); err != nil || len(log) != 1 || log[0].Query != "newer" {
+		t.Errorf("QueryLog(1) = %+v, %v; want the newer turn only", log, err)
+	}
+
+	// Feedback without a turn rates interactions by their query and response
+	if err := f.store.SaveTrainingData(ctx, TrainingData{Query: "legacy", Answer: "answer"}); err != nil {
+		t.Fatal(err)
+	}
+	legacy := func(rating int) WriteOp {
+		return WriteOp{Kind: writeFeedback, Feedback: &Feedback{Query: "legacy", Response: "answer", Rating: rating}}
+	}
+	f.write(legacy(1), legacy(4))
+	log, err = f.store.QueryLog(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, q := range log {
+		if q.Query == "legacy" {
+			found = true
+			if q.Response != "answer" || q.Rating != 2.5 || q.Ratings != 2 {
+				t.Errorf("legacy interaction logged as %+v, want rating 2.5 from 2 ratings", q)
+			}
+		}
+	}
+	if !found || len(log) != 3 {
+		t.Errorf("QueryLog = %+v, want both turns and the legacy interaction", log)
+	}
+}
+
+// Variant stats count turns, fallbacks and ratings per variant, in order
+// of variant, without turns stored before variants.
+func testStoreVariantStats(t *testing.T, f *storeFixture) {
+	f.write(
+		turnOp(TurnRecord{ID: "0000000000000001", Query: "q1", Variant: "euclidean"}),
+		turnOp(TurnRecord{ID: "0000000000000002", Query: "q2", Variant: "control", Fallback: true}),
+		turnOp(TurnRecord{ID: "0000000000000003", Query: "q3", Variant: "control"}),
+		turnOp(TurnRecord{ID: "0000000000000004", Query: "q4"}),
+	)
+	f.write(feedbackOp("0000000000000002", 1), feedbackOp("0000000000000003", 4), feedbackOp("0000000000000004", 5))
+
+	got, err := f.store.VariantStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VariantStats{
+		{Variant: "control", Turns: 2, Fallbacks: 1, Ratings: 2, AverageRating: 2.5},
+		{Variant: "euclidean", Turns: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VariantStats = %+v, want %+v", got, want)
+	}
+}
+
+// Label candidates are the turns that fell back, matched an intent with
+// low confidence, nearly tied or were rated poorly, most recent first,
+// until their query is labelled.
+func testStoreLabelCandidates(t *testing.T, f *storeFixture) {
+	ctx := context.Background()
+	f.write(
+		turnOp(TurnRecord{ID: "0000000000000001", Query: "fallback", Fallback: true}),
+		turnOp(TurnRecord{ID: "0000000000000002", Query: "unsure", Intent: "greeting", Confidence: 0.1}),
+		turnOp(TurnRecord{ID: "0000000000000003", Query: "tie", RunnerUp: 0.95}),
+		turnOp(TurnRecord{ID: "0000000000000004", Query: "rated poorly", Intent: "help", Confidence: 0.9}),
+		turnOp(TurnRecord{ID: "0000000000000005", Query: "fine", Intent: "help", Confidence: 0.9, RunnerUp: 0.5}),
+		turnOp(TurnRecord{ID: "0000000000000006", Query: "unclassified", Confidence: 0}),
+	)
+	f.write(feedbackOp("0000000000000004", 2), feedbackOp("0000000000000004", 5), feedbackOp("0000000000000005", 4))
+
+	criteria := LabelCriteria{MinConfidence: 0.3, TieRatio: 0.9, MaxRating: 2, Limit: 10}
+	queries := func() []string {
+		t.Helper()
+		candidates, err := f.store.LabelCandidates(ctx, criteria)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var queries []string
+		for _, c := range candidates {
+			queries = append(queries, c.Turn.Query)
+			if c.Turn.Query == "rated poorly" && c.MinRating != 2 {
+				t.Errorf("%q has MinRating %d, want 2", c.Turn.Query, c.MinRating)
+			}
+		}
+		return queries
+	}
+	if got, want := queries(), []string{"rated poorly", "tie", "unsure", "fallback"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LabelCandidates = %q, want %q", got, want)
+	}
+
+	criteria.Limit = 2
+	if got, want := queries(), []string{"rated poorly", "tie"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LabelCandidates with limit 2 = %q, want %q", got, want)
+	}
+
+	criteria.Limit = 10
+	for _, label := range []Label{
+		{Query: "tie", Status: "dismissed"},
+		{Query: "tie", TurnID: "0000000000000003", Section: "Channels", Answer: "A channel is ...", Status: "labelled", Labeller: "ops"},
+		{Query: "fallback", Status: "dismissed", Labeller: "ops"},
+	} {
+		if err := f.store.SaveLabel(ctx, label); err != nil {
+			t.Fatalf("SaveLabel(%+v): %v", label, err)
+		}
+	}
+	if got, want := queries(), []string{"rated poorly", "unsure"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LabelCandidates after labelling = %q, want %q", got, want)
+	}
+}
+
+// Discovered intents collect their phrases, which are stored
+// ';'-separated, including phrases for the same intent in one batch.
+func testStoreDiscoveredIntents(t *testing.T, f *storeFixture) {
+	intent := func(name, phrases string) WriteOp {
+		return WriteOp{Kind: writeIntent, Intent: &IntentPhrases{Name: name, Phrases: phrases}}
+	}
+	f.write(intent("cluster_1", "close a channel"), intent("cluster_2", "read a file"))
+	f.write(intent("cluster_1", "channel closing;closed channel"), intent("cluster_1", "channel closed"))
+
+	got, err := f.store.DiscoveredIntents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"cluster_1": {"close a channel", "channel closing", "closed channel", "channel closed"},
+		"cluster_2": {"read a file"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiscoveredIntents = %q, want %q", got, want)
+	}
+}
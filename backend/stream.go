@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Default approximate size in bytes of each streamed response chunk.
+const defaultResponseChunkSize = 200
+
+// Number of outgoing frames buffered per connection before senders block.
+const writerBufferSize = 16
+
+// connWriter serialises all writes to a WebSocket connection on a single
+// goroutine, since the connection supports only one concurrent writer.
+// Each write must finish within the timeout, so a client that stops
+// reading fails its writes instead of blocking senders.
+type connWriter struct {
+	conn    *websocket.Conn
+	logger  *slog.Logger
+	timeout time.Duration
+	out     chan interface{}
+	done    chan struct{}
+
+	mu     sync.RWMutex // Held for writing once out is closed
+	closed bool
+}
+
+// awaitedMessage is a message whose sender waits for the writer to be done
+// with it.
+type awaitedMessage struct {
+	msg  interface{}
+	done chan struct{} // Closed once the message is written or dropped
+}
+
+// closeFrame asks the writer to send a WebSocket close frame.
+type closeFrame struct {
+	code int
+	text string
+}
+
+// newConnWriter starts the writer goroutine for conn. Write errors are
+// reported to logger.
+func newConnWriter(conn *websocket.Conn, logger *slog.Logger, timeout time.Duration) *connWriter {
+	w := &connWriter{
+		conn:    conn,
+		logger:  logger,
+		timeout: timeout,
+		out:     make(chan interface{}, writerBufferSize),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *connWriter) run() {
+	defer close(w.done)
+	for msg := range w.out {
+		var done chan struct{}
+		if awaited, ok := msg.(awaitedMessage); ok {
+			msg, done = awaited.msg, awaited.done
+		}
+		_, isClose := msg.(closeFrame)
+		err := w.write(msg)
+		if done != nil {
+			close(done)
+		}
+		if err != nil || isClose {
+			if err != nil {
+				w.logger.Warn("Error on write", "err", err)
+			}
+			// Keep draining so senders never block on a dead or closing connection
+			for msg := range w.out {
+				if awaited, ok := msg.(awaitedMessage); ok {
+					close(awaited.done)
+				}
+			}
+			return
+		}
+	}
+}
+
+// write sends a message or close frame within the write timeout.
+func (w *connWriter) write(msg interface{}) error {
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return err
+	}
+	if frame, ok := msg.(closeFrame); ok {
+		return w.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(frame.code, frame.text))
+	}
+	return w.conn.WriteJSON(msg)
+}
+
+// Send queues a message for the client. Messages sent after Close or after
+// a close frame are dropped.
+func (w *connWriter) Send(msg interface{}) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if !w.closed {
+		w.out <- msg
+	}
+}
+
+// SendWait queues a message and waits until the writer is done with it, or
+// until ctx ends. A message sent after Close is dropped at once.
+func (w *connWriter) SendWait(ctx context.Context, msg interface{}) {
+	done := make(chan struct{})
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return
+	}
+	w.out <- awaitedMessage{msg: msg, done: done}
+	w.mu.RUnlock()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// SendClose queues a close frame after the messages already queued.
+func (w *connWriter) SendClose(code int, text string) {
+	w.Send(closeFrame{code: code, text: text})
+}
+
+// Close flushes queued messages and stops the writer goroutine.
+func (w *connWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.out)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// streamRegistry tracks the responses of a connection that are still being
+// streamed so that they can be cancelled by query ID.
+type streamRegistry struct {
+	mu       sync.Mutex
+	inFlight map[string]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{inFlight: make(map[string]context.CancelFunc)}
+}
+
+// Start claims id, computes the answer and streams it to the client. It
+// returns false, without calling answer, if a response with the same id is
+// already streaming. answer runs on the caller's goroutine and the stream
+// on its own.
+func (r *streamRegistry) Start(id string, w *connWriter, answer func() Result) (Result, bool) {
+	r.mu.Lock()
+	if _, exists := r.inFlight[id]; exists {
+		r.mu.Unlock()
+		return Result{}, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.inFlight[id] = cancel
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	result := answer()
+	go func() {
+		defer r.wg.Done()
+		defer r.finish(id)
+		streamResponse(ctx, w, id, result)
+	}()
+	return result, true
+}
+
+// Cancel stops the response to query id. It returns false if no such
+// response is streaming.
+func (r *streamRegistry) Cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, exists := r.inFlight[id]
+	if exists {
+		cancel()
+	}
+	return exists
+}
+
+// CancelAll stops every in-flight response.
+func (r *streamRegistry) CancelAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cancel := range r.inFlight {
+		cancel()
+	}
+}
+
+// Wait blocks until every streaming goroutine has returned.
+func (r *streamRegistry) Wait() {
+	r.wg.Wait()
+}
+
+func (r *streamRegistry) finish(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, exists := r.inFlight[id]; exists {
+		cancel()
+		delete(r.inFlight, id)
+	}
+}
+
+// streamResponse sends result as a response.start frame, one
+// response.delta frame per chunk and a closing response.end frame carrying
+// the metadata. It stops early, marking the end frame cancelled, when ctx is
+// cancelled.
+func streamResponse(ctx context.Context, w *connWriter, id string, result Result) {
+	w.Send(ResponseStartMessage{Envelope: newEnvelope(id, typeResponseStart)})
+
+	end := ResponseEndMessage{
+		Envelope: newEnvelope(id, typeResponseEnd),
+		TurnID:   result.TurnID,
+		Section:  result.Section,
+		Score:    result.Score,
+		Entities: result.Entities,
+		Links:    result.Links,
+	}
+	if end.Entities == nil {
+		end.Entities = []string{}
+	}
+	if end.Links == nil {
+		end.Links = []EntityLink{}
+	}
+
+	// Each chunk is cut once the previous one is written, so the stream
+	// goes at the pace of the client and a cancel stops it within a chunk
+	for text := result.Answer; text != ""; {
+		if ctx.Err() != nil {
+			end.Cancelled = true
+			w.Send(end)
+			return
+		}
+		var chunk string
+		chunk, text = cutChunk(text, config.Server.ResponseChunkSize)
+		w.SendWait(ctx, ResponseDeltaMessage{Envelope: newEnvelope(id, typeResponseDelta), Delta: chunk})
+	}
+	w.Send(end)
+}
+
+// cutChunk cuts the first chunk of roughly size bytes off text, breaking
+// after a newline where possible so code samples are not cut mid-line.
+func cutChunk(text string, size int) (chunk, rest string) {
+	if len(text) <= size {
+		return text, ""
+	}
+	cut := strings.LastIndex(text[:size], "\n") + 1
+	if cut == 0 {
+		cut = strings.LastIndex(text[:size], " ") + 1
+	}
+	if cut == 0 {
+		cut = size
+		// Do not split a multi-byte character
+		for cut < len(text) && text[cut]&0xC0 == 0x80 {
+			cut++
+		}
+	}
+	return text[:cut], text[cut:]
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPair connects a client to a server-side connection in this process.
+func wsPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	server = <-conns
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return server, client
+}
+
+// readFrame reads the next server message into its envelope and fields.
+func readFrame(t *testing.T, conn *websocket.Conn) (Envelope, map[string]interface{}) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	var env Envelope
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	json.Unmarshal(data, &fields)
+	return env, fields
+}
+
+func TestStreamCancelAndDuplicateID(t *testing.T) {
+	config = defaultConfig()
+	server, client := wsPair(t)
+	writer := newConnWriter(server, slog.Default(), time.Minute)
+	defer writer.Close()
+	streams := newStreamRegistry()
+	defer streams.Wait()
+
+	// Far more than the socket buffers hold, so the stream waits on the client
+	answer := strings.Repeat("chunk of an answer that is long enough to stream ", 1<<16)
+	chunks := (len(answer) + config.Server.ResponseChunkSize - 1) / config.Server.ResponseChunkSize
+	if _, ok := streams.Start("q1", writer, func() Result { return Result{Answer: answer} }); !ok {
+		t.Fatal("Start refused a new id")
+	}
+	if env, _ := readFrame(t, client); env.Type != typeResponseStart {
+		t.Fatalf("first frame is %q, want %q", env.Type, typeResponseStart)
+	}
+
+	answered := false
+	if _, ok := streams.Start("q1", writer, func() Result { answered = true; return Result{} }); ok || answered {
+		t.Errorf("Start of a streaming id = %v, answered %v; want it refused before answering", ok, answered)
+	}
+
+	if !streams.Cancel("q1") {
+		t.Fatal("Cancel did not find the streaming response")
+	}
+	deltas := 0
+	for {
+		env, fields := readFrame(t, client)
+		if env.Type == typeResponseDelta {
+			deltas++
+			continue
+		}
+		if env.Type != typeResponseEnd || fields["cancelled"] != true {
+			t.Fatalf("stream ended with %v, want a cancelled %q", fields, typeResponseEnd)
+		}
+		break
+	}
+	if deltas >= chunks {
+		t.Errorf("all %d chunks were sent despite the cancel", chunks)
+	}
+
+	// The id is free again once its response has ended
+	streams.Wait()
+	if _, ok := streams.Start("q1", writer, func() Result { return Result{Answer: "done"} }); !ok {
+		t.Error("Start refused an id whose response had ended")
+	}
+	if streams.Cancel("q2") {
+		t.Error("Cancel found a response that was never started")
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"math"
 	"sort"
 	"strings"
@@ -59,9 +60,18 @@ func countDocumentsContainingTerm(corpus []string, term string) int {
 
 // CalculateVector computes the TF-IDF vector for a given document.
 func (tfidf *TFIDF) CalculateVector(doc string) map[string]float64 {
-	words := strings.Fields(doc)          // Split the document into individual words
-	processedWords := processWords(words) // Apply enhanced NLP processing
+	return tfidf.Vectorize(tokenize(doc))
+}
+
+// tokenize splits a document into words and applies the NLP processing
+// used by the TF-IDF model.
+func tokenize(doc string) []string {
+	words := strings.Fields(doc) // Split the document into individual words
+	return processWords(words)   // Apply enhanced NLP processing
+}
 
+// Vectorize calculates the TF-IDF vector of already processed words.
+func (tfidf *TFIDF) Vectorize(processedWords []string) map[string]float64 {
 	vector := make(map[string]float64)         // Initialize map to hold the TF-IDF vector
 	totalWords := float64(len(processedWords)) // Get total number of processed words
 
@@ -79,26 +89,41 @@ func (tfidf *TFIDF) CalculateVector(doc string) map[string]float64 {
 // saveTrainingDataToDB stores training data in the database.
 func saveTrainingDataToDB(data TrainingData) {
 	// Insert the user query and corresponding answer into the interactions table
-	_, err := db.Exec("INSERT INTO interactions(query, response) VALUES(?, ?)", data.Query, data.Answer)
+	err := store.SaveTrainingData(context.Background(), data)
 	if err != nil {
-		log.Println("Error saving training data:", err) // Log any errors encountered during the database operation
+		dbErrors.WithLabelValues("save_training_data").Inc()
+		slog.Error("Error saving training data", "err", err) // Log any errors encountered during the database operation
 	}
 }
 
 // retrainTFIDFModel recalculates the TF-IDF values for the entire dataset, refreshing the model based on new data.
 func retrainTFIDFModel() {
-	// Reload the latest dataset to recalculate TF-IDF values
-	// Load the expanded corpus
-	corpus, err := LoadCorpus("go_corpus.md")
-	if err != nil {
-		log.Fatal("Error loading corpus:", err)
-	}
+	// Recalculate TF-IDF values against the installed corpus
+	modelMu.RLock()
+	corpus := installedModel().corpus
+	modelMu.RUnlock()
 	// Assuming dataset is loaded/predefined
 	// Create the TF-IDF model and calculate the query vector
 	tfidf := NewTFIDF(corpus) // Implement loadCorpus to retrieve your documents
-	for i := range dataset {
-		dataset[i].Vector = tfidf.CalculateVector(dataset[i].Answer) // Recalculate vectors for each existing dataset entry
+	revectorizeDataset(tfidf)
+}
+
+// revectorizeDataset recalculates the vector of every data point with
+// tfidf: from its query when it has one, else from its answer. The new
+// dataset is swapped in under modelMu, so answering never sees it change.
+func revectorizeDataset(tfidf *TFIDF) {
+	modelMu.Lock()
+	defer modelMu.Unlock()
+	points := make([]DataPoint, len(dataset))
+	for i, point := range dataset {
+		text := point.Query
+		if text == "" {
+			text = point.Answer
+		}
+		point.Vector = tfidf.CalculateVector(text)
+		points[i] = point
 	}
+	dataset = points
 }
 
 // advancedStem applies a more sophisticated stemming process with programming-specific rules.
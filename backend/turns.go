@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Transports a session can arrive on.
+const (
+	transportWebSocket = "websocket"
+	transportREST      = "rest"
+)
+
+// SessionRecord is a persisted conversation.
+type SessionRecord struct {
+	ID        string    `json:"id"`
+	Transport string    `json:"transport"`
+	Started   time.Time `json:"started"`
+}
+
+// TurnRecord is a persisted query, the answer given to it and how the
+// answer was found.
+type TurnRecord struct {
+	ID           string  `json:"id"`
+	SessionID    string  `json:"session_id,omitempty"` // Empty for stateless REST queries
+	Query        string  `json:"query"`
+	Response     string  `json:"response"`
+	Intent       string  `json:"intent"`
+	Confidence   float64 `json:"confidence"`
+	Section      string  `json:"section"`
+	Score        float64 `json:"score"`
+	Fallback     bool    `json:"fallback"`
+	RunnerUp     float64 `json:"runner_up"` // Second-best candidate relative to the answer, 1 for a tie
+	ModelVersion string  `json:"model_version"`
+	Variant      string  `json:"variant"` // Retrieval variant that answered
+	LatencyMS    int64   `json:"latency_ms"`
+}
+
+// Turn ids are 16 lowercase hex digits.
+var turnIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// newTurnID returns a random turn identifier.
+func newTurnID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Version of the loaded model, recorded with every turn so answers can be
+// traced back to the data that produced them.
+var modelVersion string
+
+// computeModelVersion fingerprints the files a model is built from and
+// the intents it holds. Unreadable files are skipped.
+func computeModelVersion(intents []Intent, files ...string) string {
+	hash := sha256.New()
+	for _, name := range files {
+		if data, err := os.ReadFile(name); err == nil {
+			hash.Write(data)
+		}
+	}
+	// Intents discovered from the corpus arrive in map order; sort them so
+	// the same files always give the same version
+	sorted := append([]Intent(nil), intents...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	if data, err := json.Marshal(sorted); err == nil {
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// saveSession queues a new conversation for the sessions table.
+func saveSession(session *Session, transport string) {
+	writeQueue.Enqueue(WriteOp{Kind: writeSession, Session: &SessionRecord{
+		ID:        session.ID,
+		Transport: transport,
+		Started:   time.Now(),
+	}})
+}
+
+// saveTurn queues an answered query for the turns table. sessionID is
+// empty for stateless queries.
+func saveTurn(sessionID, query string, result Result) {
+	writeQueue.Enqueue(WriteOp{Kind: writeTurn, Turn: &TurnRecord{
+		ID:           result.TurnID,
+		SessionID:    sessionID,
+		Query:        query,
+		Response:     result.Answer,
+		Intent:       result.Intent,
+		Confidence:   result.Confidence,
+		Section:      result.Section,
+		Score:        result.Score,
+		Fallback:     result.Fallback,
+		RunnerUp:     result.RunnerUp,
+		ModelVersion: result.ModelVersion,
+		Variant:      result.Variant,
+		LatencyMS:    result.Latency.Milliseconds(),
+	}})
+}
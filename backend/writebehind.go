@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Defaults for the write-behind pipeline, overridable through StorageConfig.
+const (
+	defaultWriteQueueSize      = 1024
+	defaultWriteBatchSize      = 100
+	defaultWriteFlushInterval  = time.Second
+	defaultWriteSpillFile      = "write_spill.jsonl"
+	defaultWriteQuarantineFile = "write_quarantine.jsonl"
+
+	// How long Enqueue blocks on a full queue before dropping the write
+	writeEnqueueTimeout = 50 * time.Millisecond
+	// Retries of a failed batch before it is written row by row, with
+	// exponential backoff from the retry delay
+	writeMaxRetries        = 3
+	defaultWriteRetryDelay = 100 * time.Millisecond
+)
+
+// Kinds of write carried by the pipeline.
+const (
+	writeSession  = "session"
+	writeTurn     = "turn"
+	writeFeedback = "feedback"
+	writeIntent   = "intent"
+)
+
+// WriteOp is a single row queued for persistence. Exactly one of Session,
+// Turn, Feedback and Intent is set, matching Kind.
+type WriteOp struct {
+	Kind     string         `json:"kind"`
+	Session  *SessionRecord `json:"session,omitempty"`
+	Turn     *TurnRecord    `json:"turn,omitempty"`
+	Feedback *Feedback      `json:"feedback,omitempty"`
+	Intent   *IntentPhrases `json:"intent,omitempty"`
+	Time     time.Time      `json:"time"`
+}
+
+// IntentPhrases are phrases to append to a discovered intent, created if
+// needed. Several phrases are ';'-separated, as they are stored.
+type IntentPhrases struct {
+	Name    string `json:"name"`
+	Phrases string `json:"phrases"`
+}
+
+// WriteStats counts what happened to queued writes.
+type WriteStats struct {
+	Enqueued    uint64 // Writes accepted into the queue
+	Blocked     uint64 // Enqueues that had to wait for room in the queue
+	Dropped     uint64 // Writes dropped because the queue stayed full
+	Written     uint64 // Writes committed to the store
+	Retries     uint64 // Batch retries after a store error
+	Spilled     uint64 // Writes appended to the spill file after retries ran out
+	Quarantined uint64 // Writes the store rejected on their own, appended to the quarantine file
+	Lost        uint64 // Writes that could not even be spilled or quarantined
+}
+
+// writeBehindConfig tunes a writeBehind pipeline.
+type writeBehindConfig struct {
+	QueueSize      int
+	BatchSize      int
+	FlushInterval  time.Duration
+	SpillFile      string
+	QuarantineFile string
+	RetryDelay     time.Duration // Before the first retry of a failed batch
+}
+
+// writeBehind persists writes off the request path. Writes are queued on a
+// bounded channel and a single goroutine commits them to the store in
+// batches, whenever a batch fills up or the flush interval passes.
+type writeBehind struct {
+	store  Store
+	config writeBehindConfig
+	queue  chan WriteOp
+	done   chan struct{}
+
+	// Context of the store calls, cancelled when Close runs out of time so
+	// that what is still queued is spilled instead of written
+	ctx   context.Context
+	abort context.CancelFunc
+
+	closeOnce sync.Once
+	mu        sync.RWMutex // Held for writing once the queue is closed
+	closed    bool
+
+	stats struct {
+		enqueued, blocked, dropped, written, retries, spilled, quarantined, lost atomic.Uint64
+	}
+}
+
+// Pipeline shared by all handlers.
+var writeQueue *writeBehind
+
+func newWriteBehind(store Store, config writeBehindConfig) *writeBehind {
+	w := &writeBehind{
+		store:  store,
+		config: config,
+		queue:  make(chan WriteOp, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	w.ctx, w.abort = context.WithCancel(context.Background())
+	go w.run()
+	return w
+}
+
+// Enqueue queues op for persistence. When the queue is full it applies
+// backpressure for up to writeEnqueueTimeout, then drops the write.
+func (w *writeBehind) Enqueue(op WriteOp) bool {
+	if op.Time.IsZero() {
+		op.Time = time.Now()
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.drop(op)
+		return false
+	}
+
+	select {
+	case w.queue <- op:
+		w.stats.enqueued.Add(1)
+		return true
+	default:
+	}
+
+	w.stats.blocked.Add(1)
+	timer := time.NewTimer(writeEnqueueTimeout)
+	defer timer.Stop()
+	select {
+	case w.queue <- op:
+		w.stats.enqueued.Add(1)
+		return true
+	case <-timer.C:
+		w.drop(op)
+		return false
+	}
+}
+
+func (w *writeBehind) drop(op WriteOp) {
+	// Log the first drop and then every hundredth, not every one
+	if n := w.stats.dropped.Add(1); n == 1 || n%100 == 0 {
+		slog.Warn("Write queue full; dropping writes", "dropped", n, "kind", op.Kind)
+	}
+}
+
+// Stats returns a snapshot of the pipeline counters.
+func (w *writeBehind) Stats() WriteStats {
+	return WriteStats{
+		Enqueued:    w.stats.enqueued.Load(),
+		Blocked:     w.stats.blocked.Load(),
+		Dropped:     w.stats.dropped.Load(),
+		Written:     w.stats.written.Load(),
+		Retries:     w.stats.retries.Load(),
+		Spilled:     w.stats.spilled.Load(),
+		Quarantined: w.stats.quarantined.Load(),
+		Lost:        w.stats.lost.Load(),
+	}
+}
+
+// Close stops accepting writes and flushes everything still queued. If ctx
+// ends first, the batch being written and the rest of the queue are
+// spilled to disk for the next start, and Close returns ctx.Err() once
+// they are. Either way the writer is done with the store when Close
+// returns.
+func (w *writeBehind) Close(ctx context.Context) error {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.queue)
+		w.mu.Unlock()
+	})
+
+	select {
+	case <-w.done:
+		stats := w.Stats()
+		slog.Info("Write queue flushed", "written", stats.Written, "dropped", stats.Dropped,
+			"spilled", stats.Spilled, "quarantined", stats.Quarantined, "lost", stats.Lost)
+		return nil
+	case <-ctx.Done():
+	}
+	slog.Warn("Write queue did not flush in time; spilling what is left", "queued", len(w.queue), "file", w.config.SpillFile)
+	w.abort()
+	<-w.done
+	stats := w.Stats()
+	slog.Info("Write queue spilled", "written", stats.Written, "spilled", stats.Spilled, "lost", stats.Lost)
+	return ctx.Err()
+}
+
+func (w *writeBehind) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]WriteOp, 0, w.config.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			w.commit(batch)
+			batch = make([]WriteOp, 0, w.config.BatchSize)
+		}
+	}
+
+	for {
+		select {
+		case op, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, op)
+			if len(batch) >= w.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// commit writes a batch, retrying with exponential backoff. If every
+// attempt fails, the rows are written one at a time: rows the store still
+// rejects while it accepts others are quarantined, and if it accepts none
+// they are spilled to disk. So are the rows left when the connection to
+// the store fails part way. Once Close has given up on the store, batches
+// are spilled straight away.
+func (w *writeBehind) commit(batch []WriteOp) {
+	delay := w.config.RetryDelay
+	var err error
+	for attempt := 0; attempt <= writeMaxRetries && w.ctx.Err() == nil; attempt++ {
+		if attempt > 0 {
+			w.stats.retries.Add(1)
+			if !sleepContext(w.ctx, delay) {
+				break
+			}
+			delay *= 2
+		}
+		if err = w.store.WriteBatch(w.ctx, batch); err == nil {
+			w.stats.written.Add(uint64(len(batch)))
+			return
+		}
+		dbErrors.WithLabelValues("write_batch").Inc()
+	}
+	if w.ctx.Err() != nil {
+		w.save(w.config.SpillFile, batch, &w.stats.spilled)
+		return
+	}
+
+	slog.Warn("Error writing batch; writing its rows one at a time", "size", len(batch), "retries", writeMaxRetries, "err", err)
+	rejected, unwritten, written, err := writeRows(w.ctx, w.store, batch)
+	w.stats.written.Add(uint64(written))
+	if written == 0 {
+		// Nothing got through, so the store is taken to be down
+		rejected, unwritten = nil, append(rejected, unwritten...)
+	}
+	if len(rejected) > 0 {
+		slog.Error("Store rejected rows; quarantining them", "count", len(rejected), "file", w.config.QuarantineFile, "err", err)
+		w.save(w.config.QuarantineFile, rejected, &w.stats.quarantined)
+	}
+	if len(unwritten) > 0 {
+		slog.Error("Error writing rows; spilling them", "count", len(unwritten), "file", w.config.SpillFile, "err", err)
+		w.save(w.config.SpillFile, unwritten, &w.stats.spilled)
+	}
+}
+
+// sleepContext sleeps for d, returning false early if ctx ends first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// save appends rows to a spill or quarantine file and counts them, or
+// counts them lost if the file cannot be written.
+func (w *writeBehind) save(path string, rows []WriteOp, saved *atomic.Uint64) {
+	if err := appendSpill(path, rows); err != nil {
+		slog.Error("Error writing spill file", "file", path, "err", err)
+		w.stats.lost.Add(uint64(len(rows)))
+		return
+	}
+	saved.Add(uint64(len(rows)))
+}
+
+// writeRows writes each row of a batch on its own until the connection to
+// the store fails. It returns the rows the store rejected, the rows left
+// unwritten by a connection failure, how many rows it wrote and the last
+// error.
+func writeRows(ctx context.Context, store Store, batch []WriteOp) (rejected, unwritten []WriteOp, written int, err error) {
+	for i, op := range batch {
+		rowErr := store.WriteBatch(ctx, []WriteOp{op})
+		if rowErr == nil {
+			written++
+			continue
+		}
+		dbErrors.WithLabelValues("write_row").Inc()
+		err = rowErr
+		if connectionError(rowErr) {
+			return rejected, batch[i:], written, err
+		}
+		rejected = append(rejected, op)
+	}
+	return rejected, nil, written, err
+}
+
+// connectionError reports whether err means the store could not be
+// reached, rather than that it refused a row.
+func connectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysql.ErrInvalidConn) || errors.As(err, &netErr)
+}
+
+// appendSpill appends a batch to the spill file, one JSON object per line.
+func appendSpill(path string, batch []WriteOp) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, op := range batch {
+		if err := encoder.Encode(op); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// replaySpill writes the contents of a spill file left by an earlier run
+// to the store and removes the file. If the store rejects the batch, the
+// rows are written one at a time and those it still rejects are appended
+// to the quarantine file. The file is only kept, for the next start, when
+// the store accepts none of its rows, or with the rows left when the
+// connection to the store fails part way.
+func replaySpill(ctx context.Context, store Store, path, quarantinePath string) (written, quarantined int, err error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var batch []WriteOp
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var op WriteOp
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			file.Close()
+			return 0, 0, err
+		}
+		batch = append(batch, op)
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if len(batch) > 0 {
+		if err := store.WriteBatch(ctx, batch); err != nil {
+			rejected, unwritten, n, err := writeRows(ctx, store, batch)
+			if n == 0 {
+				return 0, 0, err
+			}
+			if len(rejected) > 0 {
+				if err := appendSpill(quarantinePath, rejected); err != nil {
+					return n, 0, err
+				}
+			}
+			if len(unwritten) > 0 {
+				// Keep the rows the connection failure left for the next start
+				if replaceErr := replaceSpill(path, unwritten); replaceErr != nil {
+					err = replaceErr
+				}
+				return n, len(rejected), err
+			}
+			return n, len(rejected), os.Remove(path)
+		}
+	}
+	return len(batch), 0, os.Remove(path)
+}
+
+// replaceSpill replaces the contents of a spill file with rows.
+func replaceSpill(path string, rows []WriteOp) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := appendSpill(tmp, rows); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
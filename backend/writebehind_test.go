@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rejectingStore is a memory store that rejects every batch holding a turn
+// for the query "bad", or every batch while it is down. With dropAfter set,
+// the connection fails after that many calls.
+type rejectingStore struct {
+	*memoryStore
+	down      bool
+	dropAfter int
+	calls     int
+}
+
+func (s *rejectingStore) WriteBatch(ctx context.Context, batch []WriteOp) error {
+	if s.calls++; s.dropAfter > 0 && s.calls > s.dropAfter {
+		return driver.ErrBadConn
+	}
+	for _, op := range batch {
+		if s.down || (op.Kind == writeTurn && op.Turn.Query == "bad") {
+			return errors.New("rejected")
+		}
+	}
+	return s.memoryStore.WriteBatch(ctx, batch)
+}
+
+func testBatch() []WriteOp {
+	return []WriteOp{
+		turnOp(TurnRecord{ID: "0000000000000001", Query: "good"}),
+		turnOp(TurnRecord{ID: "0000000000000002", Query: "bad"}),
+		turnOp(TurnRecord{ID: "0000000000000003", Query: "also good"}),
+	}
+}
+
+// lines counts the rows of a spill or quarantine file, 0 if it is missing.
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, b := range data {
+		if b == '\n' {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCommitQuarantinesRejectedRows(t *testing.T) {
+	dir := t.TempDir()
+	store := &rejectingStore{memoryStore: newMemoryStore()}
+	w := &writeBehind{store: store, ctx: context.Background(), config: writeBehindConfig{
+		SpillFile:      filepath.Join(dir, "spill.jsonl"),
+		QuarantineFile: filepath.Join(dir, "quarantine.jsonl"),
+		RetryDelay:     time.Microsecond,
+	}}
+
+	w.commit(testBatch())
+	if stats := w.Stats(); stats.Written != 2 || stats.Quarantined != 1 || stats.Spilled != 0 {
+		t.Errorf("stats = %+v, want 2 written and 1 quarantined", stats)
+	}
+	if n := lines(t, w.config.QuarantineFile); n != 1 {
+		t.Errorf("quarantine file has %d rows, want 1", n)
+	}
+
+	store.down = true
+	w.commit(testBatch())
+	if stats := w.Stats(); stats.Spilled != 3 || stats.Quarantined != 1 {
+		t.Errorf("stats with the store down = %+v, want all 3 rows spilled", stats)
+	}
+	if n := lines(t, w.config.SpillFile); n != 3 {
+		t.Errorf("spill file has %d rows, want 3", n)
+	}
+}
+
+func TestCommitSpillsRowsAfterTheConnectionFails(t *testing.T) {
+	dir := t.TempDir()
+	batch := append(testBatch(), turnOp(TurnRecord{ID: "0000000000000004", Query: "last"}))
+	// After the batch and its 3 retries, the good first row is written and
+	// the bad one rejected, then the connection fails
+	store := &rejectingStore{memoryStore: newMemoryStore(), dropAfter: 6}
+	w := &writeBehind{store: store, ctx: context.Background(), config: writeBehindConfig{
+		SpillFile:      filepath.Join(dir, "spill.jsonl"),
+		QuarantineFile: filepath.Join(dir, "quarantine.jsonl"),
+		RetryDelay:     time.Microsecond,
+	}}
+
+	w.commit(batch)
+	if stats := w.Stats(); stats.Written != 1 || stats.Quarantined != 1 || stats.Spilled != 2 {
+		t.Errorf("stats = %+v, want 1 written, 1 quarantined and 2 spilled", stats)
+	}
+	if n := lines(t, w.config.SpillFile); n != 2 {
+		t.Errorf("spill file has %d rows, want the 2 left when the connection failed", n)
+	}
+
+	// The spilled rows are replayed once the store is back
+	store.dropAfter = 0
+	written, quarantined, err := replaySpill(context.Background(), store, w.config.SpillFile, w.config.QuarantineFile)
+	if err != nil || written != 2 || quarantined != 0 {
+		t.Errorf("replaySpill = %d, %d, %v; want 2 written", written, quarantined, err)
+	}
+}
+
+func TestReplaySpillKeepsRowsAfterTheConnectionFails(t *testing.T) {
+	dir := t.TempDir()
+	spill, quarantine := filepath.Join(dir, "spill.jsonl"), filepath.Join(dir, "quarantine.jsonl")
+	if err := appendSpill(spill, testBatch()); err != nil {
+		t.Fatal(err)
+	}
+	// The batch fails, then the first row is written and the second rejected
+	store := &rejectingStore{memoryStore: newMemoryStore(), dropAfter: 3}
+	written, quarantined, err := replaySpill(context.Background(), store, spill, quarantine)
+	if !errors.Is(err, driver.ErrBadConn) || written != 1 || quarantined != 1 {
+		t.Errorf("replaySpill = %d, %d, %v; want 1 written, 1 quarantined and the connection error", written, quarantined, err)
+	}
+	if n := lines(t, spill); n != 1 {
+		t.Errorf("spill file has %d rows, want the row left when the connection failed", n)
+	}
+}
+
+func TestReplaySpill(t *testing.T) {
+	dir := t.TempDir()
+	spill, quarantine := filepath.Join(dir, "spill.jsonl"), filepath.Join(dir, "quarantine.jsonl")
+	if err := appendSpill(spill, testBatch()); err != nil {
+		t.Fatal(err)
+	}
+	store := &rejectingStore{memoryStore: newMemoryStore(), down: true}
+
+	// A store that accepts nothing leaves the file for the next start
+	if written, quarantined, err := replaySpill(context.Background(), store, spill, quarantine); err == nil || written != 0 || quarantined != 0 {
+		t.Errorf("replaySpill with the store down = %d, %d, %v; want an error", written, quarantined, err)
+	}
+	if n := lines(t, spill); n != 3 {
+		t.Errorf("spill file has %d rows after a failed replay, want 3", n)
+	}
+
+	store.down = false
+	written, quarantined, err := replaySpill(context.Background(), store, spill, quarantine)
+	if err != nil || written != 2 || quarantined != 1 {
+		t.Errorf("replaySpill = %d, %d, %v; want 2 written and 1 quarantined", written, quarantined, err)
+	}
+	if _, err := os.Stat(spill); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("spill file still exists after replay: %v", err)
+	}
+	if n := lines(t, quarantine); n != 1 {
+		t.Errorf("quarantine file has %d rows, want 1", n)
+	}
+	if got, _ := store.Interactions(context.Background()); len(got) != 2 {
+		t.Errorf("store holds %+v, want the 2 good turns", got)
+	}
+}
+
+// hangingStore blocks every write until its context ends.
+type hangingStore struct {
+	*memoryStore
+}
+
+func (s hangingStore) WriteBatch(ctx context.Context, batch []WriteOp) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCloseSpillsWhenTheTimeoutRunsOut(t *testing.T) {
+	tests := []struct {
+		name  string
+		store Store
+		wait  time.Duration // How long Close gets
+	}{
+		// Draining used up the shutdown timeout before the flush started
+		{"timeout already expired", newMemoryStore(), 0},
+		// The store hangs in the middle of the flush
+		{"store hangs", hangingStore{newMemoryStore()}, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := newWriteBehind(tt.store, writeBehindConfig{
+				QueueSize:      16,
+				BatchSize:      2,
+				FlushInterval:  time.Hour,
+				SpillFile:      filepath.Join(dir, "spill.jsonl"),
+				QuarantineFile: filepath.Join(dir, "quarantine.jsonl"),
+			})
+			for _, op := range testBatch() {
+				w.Enqueue(op)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.wait)
+			defer cancel()
+			if err := w.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Close = %v, want the deadline error", err)
+			}
+			stats := w.Stats()
+			if stats.Written+stats.Spilled != 3 || stats.Lost != 0 {
+				t.Errorf("stats = %+v, want every write written or spilled", stats)
+			}
+			if n := lines(t, w.config.SpillFile); uint64(n) != stats.Spilled {
+				t.Errorf("spill file has %d rows, want %d", n, stats.Spilled)
+			}
+		})
+	}
+}
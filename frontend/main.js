@@ -1,77 +1,93 @@
-const connection = new WebSocket('ws://localhost:8080/ws');
+const PROTOCOL_VERSION = 1;
+
+// When the server requires the chat role, open the page with ?token=... to pass it on
+const token = new URLSearchParams(window.location.search).get('token');
+const connection = new WebSocket('ws://localhost:8080/ws' + (token ? '?token=' + encodeURIComponent(token) : ''));
+
+// Queries waiting for a response, keyed by message id
+const pendingQueries = {};
+// Text received so far for answers that are still streaming, keyed by query id
+const responses = {};
+let nextMessageId = 1;
+
+function send(type, fields) {
+    const id = String(nextMessageId++);
+    connection.send(JSON.stringify({ version: PROTOCOL_VERSION, id, type, ...fields }));
+    return id;
+}
 
 connection.onopen = () => {
     console.log('WebSocket connected');
 };
 
+connection.onclose = (event) => {
+    // Code 1001 means the server is restarting; reload to reconnect
+    console.log(`WebSocket closed (${event.code}): ${event.reason}`);
+};
+
 connection.onmessage = (event) => {
     const msg = JSON.parse(event.data);
-    
-    if (msg.type === "response") {
-        const messagesContainer = document.getElementById('messages');
-        messagesContainer.innerHTML += `<div>${msg.response}</div>`;
-        
+    const messagesContainer = document.getElementById('messages');
+
+    if (msg.type === "response.start") {
+        // Each answer streams into its own element
+        const div = document.createElement('div');
+        div.id = `response-${msg.id}`;
+        messagesContainer.appendChild(div);
+        responses[msg.id] = "";
+    } else if (msg.type === "response.delta") {
+        responses[msg.id] += msg.delta;
+        document.getElementById(`response-${msg.id}`).textContent = responses[msg.id];
+    } else if (msg.type === "response.end") {
+        if (msg.section) {
+            console.log(`Answer ${msg.id} from section "${msg.section}" (score ${msg.score.toFixed(3)})`);
+        }
         // Show feedback options after displaying the response
-        showFeedbackOptions(msg.response);
+        if (!msg.cancelled) {
+            showFeedbackOptions(msg.turn_id, pendingQueries[msg.id], responses[msg.id]);
+        }
+        delete pendingQueries[msg.id];
+        delete responses[msg.id];
+    } else if (msg.type === "error") {
+        console.warn(`Server rejected message ${msg.id}: ${msg.code}: ${msg.detail}`);
+        delete pendingQueries[msg.id];
     }
 };
 
+// Stop the answer to a query that is still streaming
+function cancelResponse(queryId) {
+    send("cancel", { target: queryId });
+}
+
 // Show the feedback options after receiving a response
-function showFeedbackOptions(response) {
+function showFeedbackOptions(turnId, query, response) {
     const feedbackDiv = document.getElementById('feedback');
     feedbackDiv.style.display = "block"; // Show feedback options
-    
-    // Store the last response and query for feedback submission
-    window.lastQuery = document.getElementById('query').value;
+
+    // Store the last turn, response and query for feedback submission
+    window.lastTurnId = turnId;
+    window.lastQuery = query;
     window.lastResponse = response;
 }
 
 // Function to submit feedback
 function submitFeedback(rating) {
-    connection.send(JSON.stringify({ 
-        type: "feedback", 
-        query: window.lastQuery, 
-        response: window.lastResponse, 
-        rating: rating 
-    }));
+    send("feedback", {
+        turn_id: window.lastTurnId,
+        query: window.lastQuery,
+        response: window.lastResponse,
+        rating: rating
+    });
 
     // Hide feedback options after submission
     const feedbackDiv = document.getElementById('feedback');
     feedbackDiv.style.display = "none";
-    
-    // Reset the input field after sending feedback
-    document.getElementById('query').value = ''; 
 }
 
 // Event listener for the send button
 document.getElementById('send').onclick = () => {
     const query = document.getElementById('query').value;
-    connection.send(JSON.stringify({ type: "query", query }));
+    const id = send("query", { query });
+    pendingQueries[id] = query;
     document.getElementById('query').value = ''; // Clear input field
 };
-
-document.getElementById('send').onclick = () => {
-    const query = document.getElementById('query').value;
-    connection.send(JSON.stringify({ query }));
-    document.getElementById('query').value = ''; // Clear input field
-};
-
-document.getElementById('send').onclick = () => {
-    const query = document.getElementById('query').value;
-    connection.send(JSON.stringify({ type: "query", query }));
-    document.getElementById('query').value = ''; // Clear input field
-};
-
-// Feedback submission
-function submitFeedback(query, response, rating) {
-    connection.send(JSON.stringify({ 
-        type: "feedback", 
-        query: query, 
-        response: response, 
-        rating: rating 
-    }));
-}
-
-// Example of using the feedback
-// Call submitFeedback after a response is displayed to the user
-// You can implement a simple rating UI using buttons for 1 to 5 stars
\ No newline at end of file